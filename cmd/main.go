@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"slices"
+	"strings"
 
 	"github.com/apkatsikas/archiver/db"
 	"github.com/apkatsikas/archiver/fileutil"
@@ -17,13 +21,19 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Discord rejects messages over 2000 characters.
+const (
+	alertMessageLimit = 2000
+	alertTruncated    = "\n..."
+)
+
 func main() {
 	fu := flagutil.Get()
 	fu.Setup()
 
 	switch fu.RunMode {
 	case flagutil.RunModeLedger:
-		if err := runLedger(); err != nil {
+		if err := runLedger(fu); err != nil {
 			panic(err)
 		}
 		return
@@ -32,20 +42,64 @@ func main() {
 			panic(err)
 		}
 		return
+	case flagutil.RunModeRestore:
+		if err := runRestore(fu); err != nil {
+			panic(err)
+		}
+		return
+	case flagutil.RunModeRestoreDb:
+		if err := runRestoreDb(fu); err != nil {
+			panic(err)
+		}
+		return
+	case flagutil.RunModeVerify:
+		if err := runVerify(fu); err != nil {
+			panic(err)
+		}
+		return
+	case flagutil.RunModeScrub:
+		if err := runScrub(fu); err != nil {
+			errMsg := fmt.Sprintf("\nARCHIVER: Failed to scrub archives - %v", err.Error())
+			var failedScrub *runner.FailedScrubError
+			if errors.As(err, &failedScrub) {
+				errMsg = fmt.Sprintf("\nARCHIVER: Scrub found damaged archives - %v",
+					failedScrub.Report.Summary())
+			}
+			sendAlert(errMsg)
+			panic(err)
+		}
+		return
+	case flagutil.RunModeMigrateIds:
+		if err := runMigrateIds(fu); err != nil {
+			panic(err)
+		}
+		return
 	}
 
 	err := performScheduledArchive(fu)
 	if err != nil {
 		errMsg := fmt.Sprintf("\nARCHIVER: Failed to perform scheduled archive - %v", err.Error())
-		alertErr := alert.SendAlert(errMsg)
-		if alertErr != nil {
-			log.Printf("Encountered issue trying to send alert %v", alertErr)
+		var failedFolders *runner.FailedFoldersError
+		if errors.As(err, &failedFolders) {
+			errMsg = fmt.Sprintf("\nARCHIVER: Scheduled archive finished with failures - %v",
+				failedFolders.Report.Summary())
 		}
+		sendAlert(errMsg)
 		panic(err)
 	}
 }
 
-func runLedger() error {
+func sendAlert(errMsg string) {
+	if len(errMsg) > alertMessageLimit {
+		errMsg = errMsg[:alertMessageLimit-len(alertTruncated)] + alertTruncated
+	}
+	alertErr := alert.SendAlert(errMsg)
+	if alertErr != nil {
+		log.Printf("Encountered issue trying to send alert %v", alertErr)
+	}
+}
+
+func runLedger(flagUtil *flagutil.FlagUtil) error {
 	arguments := flag.Args()
 
 	if len(arguments) < 2 {
@@ -60,7 +114,8 @@ func runLedger() error {
 	mfr := &db.MusicFoldersRepository{SqliteHandler: sqliteHandler}
 	libraryRepository := &db.LibraryRepository{SqliteHandler: sqliteHandler}
 	runn := &runner.Runner{MusicFoldersRepository: mfr,
-		LibraryRepository: libraryRepository, FileSystemOperator: &fileutil.FileSystemOperator{}}
+		LibraryRepository: libraryRepository, FileSystemOperator: &fileutil.FileSystemOperator{},
+		FilterService: newFilterService(flagUtil)}
 
 	if err := runn.BuildLedger(ledgerOutputFile); err != nil {
 		return err
@@ -72,22 +127,35 @@ func runBatch(flagUtil *flagutil.FlagUtil) error {
 	arguments := flag.Args()
 
 	if len(arguments) < 1 {
-		return fmt.Errorf("batch mode requires arguments for ledger file")
+		return fmt.Errorf("batch mode requires arguments for ledger file and optional archive DB path")
 	}
 
 	ledgerFile := arguments[0]
 
 	fso := &fileutil.FileSystemOperator{}
 
-	zipper := &zipper.Zipper{
-		FileSystemOperator: fso,
-	}
-	zipper.SetFileLimits(uint(flagUtil.FileSizeLimit), uint(flagUtil.FileCountLimit))
+	zipper := newZipper(fso, flagUtil)
 
 	runn := &runner.Runner{
+		FilterService:      newFilterService(flagUtil),
 		FileSystemOperator: fso,
 		Zipper:             zipper,
-		StorageClient:      storageclient.New(),
+		StorageClient:      newStorageClient(flagUtil),
+		ContinueOnError:    flagUtil.ContinueOnError,
+		ReportFile:         flagUtil.ReportFile,
+		StreamUploads:      flagUtil.StreamUploads,
+	}
+
+	if len(arguments) > 1 {
+		sqliteHandlerArchiveRun := &db.SQLiteHandler{}
+		if err := sqliteHandlerArchiveRun.ConnectSQLite(arguments[1]); err != nil {
+			return err
+		}
+		runn.ArchivedFolderRepository = &db.ArchivedFolderRepository{SqliteHandler: sqliteHandlerArchiveRun}
+		if err := runn.ArchivedFolderRepository.CreateTable(); err != nil {
+			return err
+		}
+		runn.FileHashRepository = &db.FileHashRepository{SqliteHandler: sqliteHandlerArchiveRun}
 	}
 
 	if err := runn.RunBatch(ledgerFile); err != nil {
@@ -96,6 +164,203 @@ func runBatch(flagUtil *flagutil.FlagUtil) error {
 	return nil
 }
 
+func runRestore(flagUtil *flagutil.FlagUtil) error {
+	arguments := flag.Args()
+
+	if len(arguments) < 2 {
+		return fmt.Errorf("restore mode requires arguments for archive location, target directory " +
+			"and optional object pattern")
+	}
+
+	backend := restoreBackend(flagUtil)
+	restorePrefix, err := setArchiveLocation(backend, arguments[0])
+	if err != nil {
+		return err
+	}
+	targetDir := arguments[1]
+	pattern := ""
+	if len(arguments) > 2 {
+		pattern = arguments[2]
+	}
+
+	fso := &fileutil.FileSystemOperator{}
+
+	runn := &runner.Runner{
+		FileSystemOperator: fso,
+		Zipper:             &zipper.Zipper{FileSystemOperator: fso},
+		StorageClient:      newBackendStorageClient(backend),
+		RestoreVersion:     flagUtil.RestoreVersion,
+		RestorePrefix:      restorePrefix,
+	}
+
+	if err := runn.RunRestore(targetDir, pattern); err != nil {
+		return err
+	}
+	return nil
+}
+
+// restoreBackend returns the backend archives are restored from, the first of
+// the configured ones.
+func restoreBackend(flagUtil *flagutil.FlagUtil) flagutil.StorageBackend {
+	if len(flagUtil.StorageBackends) == 0 {
+		return flagutil.StorageBackendGCS
+	}
+	return flagUtil.StorageBackends[0]
+}
+
+// setArchiveLocation points the backend at an archive location, a bucket
+// with an optional prefix such as "my-bucket/archives/" for GCS and S3, or a
+// directory for the filesystem backend. It returns the prefix to restore from.
+func setArchiveLocation(backend flagutil.StorageBackend, location string) (string, error) {
+	if backend == flagutil.StorageBackendFileSystem {
+		return "", os.Setenv("FILESYSTEM_STORAGE_PATH", location)
+	}
+
+	bucketName, prefix, _ := strings.Cut(location, "/")
+	if bucketName == "" {
+		return "", fmt.Errorf("archive location %v does not name a bucket", location)
+	}
+	bucketEnvVar := "GCS_BUCKET_NAME"
+	if backend == flagutil.StorageBackendS3 {
+		bucketEnvVar = "S3_BUCKET_NAME"
+	}
+	return prefix, os.Setenv(bucketEnvVar, bucketName)
+}
+
+func runRestoreDb(flagUtil *flagutil.FlagUtil) error {
+	arguments := flag.Args()
+
+	if len(arguments) != 1 && len(arguments) != 3 {
+		return fmt.Errorf("restoreDb mode requires arguments for destination DB path " +
+			"and optionally the old and new library root paths")
+	}
+
+	destination := arguments[0]
+	oldRoot, newRoot := "", ""
+	if len(arguments) == 3 {
+		oldRoot, newRoot = arguments[1], arguments[2]
+	}
+
+	runn := &runner.Runner{
+		FileSystemOperator: &fileutil.FileSystemOperator{},
+		StorageClient:      newStorageClient(flagUtil),
+		DbSnapshot:         flagUtil.DbSnapshot,
+	}
+
+	if err := runn.RunRestoreDb(destination, oldRoot, newRoot); err != nil {
+		return err
+	}
+	return nil
+}
+
+func runVerify(flagUtil *flagutil.FlagUtil) error {
+	arguments := flag.Args()
+
+	if len(arguments) < 1 {
+		return fmt.Errorf("verify mode requires arguments for navidrome DB path and optional archive DB path")
+	}
+
+	sqliteNavidrome := &db.SQLiteHandler{}
+	if err := sqliteNavidrome.ConnectSQLite(arguments[0]); err != nil {
+		return err
+	}
+
+	runn := &runner.Runner{
+		FilterService:          newFilterService(flagUtil),
+		StorageClient:          newStorageClient(flagUtil),
+		MusicFoldersRepository: &db.MusicFoldersRepository{SqliteHandler: sqliteNavidrome},
+		LibraryRepository:      &db.LibraryRepository{SqliteHandler: sqliteNavidrome},
+		FileSystemOperator:     &fileutil.FileSystemOperator{},
+		ReportFile:             flagUtil.ReportFile,
+	}
+
+	if len(arguments) > 1 {
+		sqliteHandlerArchiveRun := &db.SQLiteHandler{}
+		if err := sqliteHandlerArchiveRun.ConnectSQLite(arguments[1]); err != nil {
+			return err
+		}
+		runn.ArchivedFolderRepository = &db.ArchivedFolderRepository{SqliteHandler: sqliteHandlerArchiveRun}
+	}
+
+	report, err := runn.RunVerify()
+	if err != nil {
+		return err
+	}
+	fmt.Println(report.Summary())
+	if !report.OK() {
+		return fmt.Errorf("storage does not match the library")
+	}
+	return nil
+}
+
+func runMigrateIds(flagUtil *flagutil.FlagUtil) error {
+	arguments := flag.Args()
+
+	if len(arguments) < 2 {
+		return fmt.Errorf("migrateIds mode requires arguments for navidrome DB path and archive DB path")
+	}
+
+	sqliteNavidrome := &db.SQLiteHandler{}
+	if err := sqliteNavidrome.ConnectSQLite(arguments[0]); err != nil {
+		return err
+	}
+	sqliteHandlerArchiveRun := &db.SQLiteHandler{}
+	if err := sqliteHandlerArchiveRun.ConnectSQLite(arguments[1]); err != nil {
+		return err
+	}
+
+	fso := &fileutil.FileSystemOperator{}
+	runn := &runner.Runner{
+		FilterService:            newFilterService(flagUtil),
+		StorageClient:            newStorageClient(flagUtil),
+		MusicFoldersRepository:   &db.MusicFoldersRepository{SqliteHandler: sqliteNavidrome},
+		LibraryRepository:        &db.LibraryRepository{SqliteHandler: sqliteNavidrome},
+		ArchivedFolderRepository: &db.ArchivedFolderRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		FileHashRepository:       &db.FileHashRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		FileSystemOperator:       fso,
+		Zipper:                   newZipper(fso, flagUtil),
+	}
+
+	return runn.RunMigrateIds()
+}
+
+func runScrub(flagUtil *flagutil.FlagUtil) error {
+	arguments := flag.Args()
+
+	if len(arguments) < 1 {
+		return fmt.Errorf("scrub mode requires an argument for archive DB path")
+	}
+
+	sqliteHandlerArchiveRun := &db.SQLiteHandler{}
+	if err := sqliteHandlerArchiveRun.ConnectSQLite(arguments[0]); err != nil {
+		return err
+	}
+
+	scrubDir := flagUtil.WorkDir
+	if scrubDir == "" {
+		scrubDir = os.TempDir()
+	}
+
+	fso := &fileutil.FileSystemOperator{}
+	runn := &runner.Runner{
+		StorageClient:            newStorageClient(flagUtil),
+		ArchivedFolderRepository: &db.ArchivedFolderRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		ScrubResultRepository:    &db.ScrubResultRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		Zipper:                   newZipper(fso, flagUtil),
+		FileSystemOperator:       fso,
+		ReportFile:               flagUtil.ReportFile,
+		ScrubCount:               int(flagUtil.ScrubCount),
+		ScrubRandom:              flagUtil.ScrubRandom,
+		ScrubDir:                 scrubDir,
+	}
+
+	report, err := runn.RunScrub()
+	if report != nil {
+		fmt.Println(report.Summary())
+	}
+	return err
+}
+
 func performScheduledArchive(flagUtil *flagutil.FlagUtil) error {
 	arguments := flag.Args()
 
@@ -103,6 +368,11 @@ func performScheduledArchive(flagUtil *flagutil.FlagUtil) error {
 		return fmt.Errorf("scheduled mode requires arguments for navidrome DB path and archive DB path")
 	}
 
+	deletedMediaPolicy, err := deletedMediaPolicy(flagUtil)
+	if err != nil {
+		return err
+	}
+
 	navidromeDbPath := arguments[0]
 	archiveDbPath := arguments[1]
 
@@ -117,20 +387,35 @@ func performScheduledArchive(flagUtil *flagutil.FlagUtil) error {
 	}
 
 	fso := &fileutil.FileSystemOperator{}
-	zipper := &zipper.Zipper{
-		FileSystemOperator: fso,
-	}
-	zipper.SetFileLimits(uint(flagUtil.FileSizeLimit), uint(flagUtil.FileCountLimit))
+	zipper := newZipper(fso, flagUtil)
 
 	runn := &runner.Runner{
-		FilterService:          &filter.FilterService{},
-		StorageClient:          storageclient.New(),
-		MusicFoldersRepository: &db.MusicFoldersRepository{SqliteHandler: sqliteNavidrome},
-		ArchiveRunRepository:   &db.ArchiveRunRepository{SqliteHandler: sqliteHandlerArchiveRun},
-		AdminRepository:        &db.AdminRepository{SqliteHandler: sqliteNavidrome},
-		LibraryRepository:      &db.LibraryRepository{SqliteHandler: sqliteNavidrome},
-		Zipper:                 zipper,
-		FileSystemOperator:     fso,
+		FilterService:            newFilterService(flagUtil),
+		StorageClient:            newStorageClient(flagUtil),
+		MusicFoldersRepository:   &db.MusicFoldersRepository{SqliteHandler: sqliteNavidrome},
+		ArchiveRunRepository:     &db.ArchiveRunRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		ArchivedFolderRepository: &db.ArchivedFolderRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		TombstoneRepository:      &db.TombstoneRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		FileHashRepository:       &db.FileHashRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		ArchiveVersionRepository: &db.ArchiveVersionRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		DbBackupRepository:       &db.DbBackupRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		FolderRetryRepository:    &db.FolderRetryRepository{SqliteHandler: sqliteHandlerArchiveRun},
+		AdminRepository:          &db.AdminRepository{SqliteHandler: sqliteNavidrome},
+		LibraryRepository:        &db.LibraryRepository{SqliteHandler: sqliteNavidrome},
+		Zipper:                   zipper,
+		FileSystemOperator:       fso,
+		ContinueOnError:          flagUtil.ContinueOnError,
+		ReportFile:               flagUtil.ReportFile,
+		StreamUploads:            flagUtil.StreamUploads,
+		DeletedMediaPolicy:       deletedMediaPolicy,
+		DeleteGracePeriod:        flagUtil.DeleteGracePeriod,
+		KeepVersions:             int(flagUtil.KeepVersions),
+		VersionMaxAge:            flagUtil.VersionMaxAge,
+		DbBackupRetention: runner.DbBackupRetention{
+			Daily:   int(flagUtil.DbKeepDaily),
+			Weekly:  int(flagUtil.DbKeepWeekly),
+			Monthly: int(flagUtil.DbKeepMonthly),
+		},
 	}
 
 	if err := runn.RunScheduled(); err != nil {
@@ -138,3 +423,71 @@ func performScheduledArchive(flagUtil *flagutil.FlagUtil) error {
 	}
 	return nil
 }
+
+func newZipper(fso fileutil.IFileSystemOperator, flagUtil *flagutil.FlagUtil) *zipper.Zipper {
+	zipp := &zipper.Zipper{
+		FileSystemOperator: fso,
+	}
+	zipp.SetFileLimits(uint(flagUtil.FileSizeLimit), uint(flagUtil.FileCountLimit))
+	zipp.SetPartLimits(uint(flagUtil.PartSizeLimit), uint(flagUtil.PartFileLimit))
+	zipp.SetWorkDir(flagUtil.WorkDir)
+	// An album root is only complete with its disc folders inside it.
+	zipp.SetRecursive(flagUtil.Recursive || flagUtil.DetectAlbumRoots)
+	zipp.SetArchiveFormat(archiveFormat(flagUtil))
+	return zipp
+}
+
+func newFilterService(flagUtil *flagutil.FlagUtil) *filter.FilterService {
+	return &filter.FilterService{
+		DetectAlbumRoots: flagUtil.DetectAlbumRoots,
+		ArchiveExtension: archiveFormat(flagUtil).Extension(),
+	}
+}
+
+func archiveFormat(flagUtil *flagutil.FlagUtil) zipper.ArchiveFormat {
+	if flagUtil.ArchiveFormat == "" {
+		return zipper.FormatZipDeflate
+	}
+	return zipper.FormatByName(string(flagUtil.ArchiveFormat))
+}
+
+// deletedMediaPolicy checks the deletedMediaPolicy flag names one of the
+// runner.DeletedMediaPolicies, leaving it empty to ignore deleted folders.
+func deletedMediaPolicy(flagUtil *flagutil.FlagUtil) (runner.DeletedMediaPolicy, error) {
+	policy := runner.DeletedMediaPolicy(flagUtil.DeletedMediaPolicy)
+	if policy != "" && !slices.Contains(runner.DeletedMediaPolicies, policy) {
+		return "", fmt.Errorf("invalid value for deletedMediaPolicy: %s", policy)
+	}
+	return policy, nil
+}
+
+func newStorageClient(flagUtil *flagutil.FlagUtil) storageclient.IStorageClient {
+	backends := flagUtil.StorageBackends
+	if len(backends) == 0 {
+		backends = flagutil.StorageBackends{flagutil.StorageBackendGCS}
+	}
+	if len(backends) == 1 {
+		return newBackendStorageClient(backends[0])
+	}
+
+	var destinations []storageclient.Destination
+	for i, backend := range backends {
+		destinations = append(destinations, storageclient.Destination{
+			Name:     string(backend),
+			Client:   newBackendStorageClient(backend),
+			Required: i == 0 || flagUtil.SecondaryStorageFatal,
+		})
+	}
+	return storageclient.NewMulti(destinations...)
+}
+
+func newBackendStorageClient(backend flagutil.StorageBackend) storageclient.IStorageClient {
+	switch backend {
+	case flagutil.StorageBackendS3:
+		return storageclient.NewS3()
+	case flagutil.StorageBackendFileSystem:
+		return storageclient.NewFileSystem()
+	default:
+		return storageclient.New()
+	}
+}
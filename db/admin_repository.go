@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"strings"
 )
 
 type AdminRepository struct {
@@ -15,3 +16,72 @@ func (adR *AdminRepository) CreateBackup(file string) error {
 	}
 	return nil
 }
+
+// IntegrityCheck runs PRAGMA integrity_check and returns an error describing
+// any problems found.
+func (adR *AdminRepository) IntegrityCheck() error {
+	return adR.pragmaCheck("integrity_check")
+}
+
+// QuickCheck runs PRAGMA quick_check, which skips the index contents
+// integrity_check compares, and returns an error describing any problems found.
+func (adR *AdminRepository) QuickCheck() error {
+	return adR.pragmaCheck("quick_check")
+}
+
+func (adR *AdminRepository) pragmaCheck(pragma string) error {
+	rows, err := adR.SqliteHandler.Db().Query(fmt.Sprintf("PRAGMA %v;", pragma))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%v failed: %v", pragma, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// SchemaVersion returns the newest migration applied to the Navidrome DB, from
+// the goose_db_version table Navidrome migrates with, or the schema_migrations
+// table of older releases. It is empty if the DB has neither.
+func (adR *AdminRepository) SchemaVersion() (string, error) {
+	migrationTables := []struct {
+		name  string
+		query string
+	}{
+		{"goose_db_version", "SELECT COALESCE(MAX(version_id), '') FROM goose_db_version WHERE is_applied"},
+		{"schema_migrations", "SELECT COALESCE(MAX(version), '') FROM schema_migrations"},
+	}
+	for _, table := range migrationTables {
+		var found int
+		err := adR.SqliteHandler.Db().QueryRow(
+			"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table.name).Scan(&found)
+		if err != nil {
+			return "", err
+		}
+		if found == 0 {
+			continue
+		}
+		var version string
+		if err := adR.SqliteHandler.Db().QueryRow(table.query).Scan(&version); err != nil {
+			return "", fmt.Errorf("failed to read %v: %v", table.name, err)
+		}
+		return version, nil
+	}
+	return "", nil
+}
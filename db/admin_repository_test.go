@@ -0,0 +1,82 @@
+package db_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/apkatsikas/archiver/db"
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var _ = Describe("IntegrityCheck", func() {
+	var sqliteHandler *db.SQLiteHandler
+	var adminRepository *db.AdminRepository
+
+	Context("When the DB is healthy", func() {
+		BeforeEach(func() {
+			By("Resetting and connecting to DB")
+			testDbFullPath, err := testutils.SetupTestDb("fakenavidrome")
+			Expect(err).To(BeNil(), "Error trying to setup DB")
+			sqliteHandler = &db.SQLiteHandler{}
+			Expect(sqliteHandler.ConnectSQLite(testDbFullPath)).To(BeNil(), "Failed to connect to sqlite")
+			adminRepository = &db.AdminRepository{SqliteHandler: sqliteHandler}
+		})
+
+		It("Returns nil", func() {
+			Expect(adminRepository.IntegrityCheck()).To(BeNil())
+			Expect(adminRepository.QuickCheck()).To(BeNil())
+		})
+	})
+
+	Context("When the file is not a DB", func() {
+		BeforeEach(func() {
+			corruptDbPath := filepath.Join(GinkgoT().TempDir(), "corrupt.db")
+			Expect(os.WriteFile(corruptDbPath, []byte("definitely not sqlite, sorry"), 0644)).To(BeNil())
+			sqliteHandler = &db.SQLiteHandler{}
+			Expect(sqliteHandler.ConnectSQLite(corruptDbPath)).To(BeNil(), "Failed to connect to sqlite")
+			adminRepository = &db.AdminRepository{SqliteHandler: sqliteHandler}
+		})
+
+		It("Returns an error", func() {
+			Expect(adminRepository.IntegrityCheck()).To(Not(BeNil()))
+			Expect(adminRepository.QuickCheck()).To(Not(BeNil()))
+		})
+	})
+})
+
+var _ = Describe("SchemaVersion", func() {
+	var sqliteHandler *db.SQLiteHandler
+	var adminRepository *db.AdminRepository
+
+	BeforeEach(func() {
+		By("Resetting and connecting to DB")
+		testDbFullPath, err := testutils.SetupTestDb("fakenavidrome")
+		Expect(err).To(BeNil(), "Error trying to setup DB")
+		sqliteHandler = &db.SQLiteHandler{}
+		Expect(sqliteHandler.ConnectSQLite(testDbFullPath)).To(BeNil(), "Failed to connect to sqlite")
+		adminRepository = &db.AdminRepository{SqliteHandler: sqliteHandler}
+	})
+
+	It("Returns an empty version when the DB has no migrations", func() {
+		Expect(adminRepository.SchemaVersion()).To(BeEmpty())
+	})
+
+	Context("When goose has migrated the DB", func() {
+		BeforeEach(func() {
+			_, err := sqliteHandler.Db().Exec(
+				"CREATE TABLE goose_db_version (id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+					"version_id INTEGER NOT NULL, is_applied INTEGER NOT NULL);" +
+					"INSERT INTO goose_db_version (version_id, is_applied) VALUES " +
+					"(0, 1), (20240122223340, 1), (20240511220020, 0);")
+			Expect(err).To(BeNil())
+		})
+
+		It("Returns the newest migration applied", func() {
+			Expect(adminRepository.SchemaVersion()).To(Equal("20240122223340"))
+		})
+	})
+})
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"time"
+)
+
+// ArchiveVersion is an earlier upload of an archived folder, kept when the
+// folder was archived again instead of being overwritten.
+type ArchiveVersion struct {
+	Path string
+	// Version names the copy in storage, which holds the objects of
+	// Destination as they were before being replaced.
+	Version     string
+	Destination string
+	Size        int64
+	Checksum    string
+	CRC32C      string
+	UploadedAt  time.Time
+	ReplacedAt  time.Time
+}
+
+type ArchiveVersionRepository struct {
+	SqliteHandler *SQLiteHandler
+}
+
+const archiveVersionColumns = "path, version, destination, size, checksum, crc32c, uploaded_at, replaced_at"
+
+func (avr *ArchiveVersionRepository) CreateTable() error {
+	_, err := avr.SqliteHandler.Db().Exec(
+		"CREATE TABLE IF NOT EXISTS archive_version (" +
+			"path TEXT NOT NULL," +
+			"version TEXT NOT NULL," +
+			"destination TEXT NOT NULL," +
+			"size INTEGER NOT NULL," +
+			"checksum TEXT NOT NULL," +
+			"crc32c TEXT NOT NULL," +
+			"uploaded_at DATE NOT NULL," +
+			"replaced_at DATE NOT NULL," +
+			"PRIMARY KEY (path, version));")
+	return err
+}
+
+// SaveArchiveVersion records a kept version of a folder, replacing any earlier
+// record of the same version.
+func (avr *ArchiveVersionRepository) SaveArchiveVersion(archiveVersion ArchiveVersion) error {
+	statement, err := avr.SqliteHandler.Db().Prepare(
+		"INSERT OR REPLACE INTO archive_version (" + archiveVersionColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(
+		archiveVersion.Path,
+		archiveVersion.Version,
+		archiveVersion.Destination,
+		archiveVersion.Size,
+		archiveVersion.Checksum,
+		archiveVersion.CRC32C,
+		archiveVersion.UploadedAt.UTC().Format(timeFormat),
+		archiveVersion.ReplacedAt.UTC().Format(timeFormat))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteArchiveVersion forgets a version, once its objects are pruned.
+func (avr *ArchiveVersionRepository) DeleteArchiveVersion(path string, version string) error {
+	_, err := avr.SqliteHandler.Db().Exec(
+		"DELETE FROM archive_version WHERE path = ? AND version = ?", path, version)
+	return err
+}
+
+// MoveArchiveVersions keeps the versions of a folder that was renamed or moved.
+func (avr *ArchiveVersionRepository) MoveArchiveVersions(oldPath string, newPath string) error {
+	_, err := avr.SqliteHandler.Db().Exec(
+		"UPDATE archive_version SET path = ? WHERE path = ?", newPath, oldPath)
+	return err
+}
+
+// ArchiveVersionsByPath returns the versions of a folder, newest first.
+func (avr *ArchiveVersionRepository) ArchiveVersionsByPath(path string) ([]ArchiveVersion, error) {
+	return avr.queryArchiveVersions(
+		"SELECT "+archiveVersionColumns+" FROM archive_version WHERE path = ? ORDER BY replaced_at DESC, version DESC",
+		path)
+}
+
+// AllArchiveVersions returns every version, grouped by folder and newest first.
+func (avr *ArchiveVersionRepository) AllArchiveVersions() ([]ArchiveVersion, error) {
+	return avr.queryArchiveVersions(
+		"SELECT " + archiveVersionColumns + " FROM archive_version ORDER BY path, replaced_at DESC, version DESC")
+}
+
+func (avr *ArchiveVersionRepository) queryArchiveVersions(query string, args ...any) ([]ArchiveVersion, error) {
+	rows, err := avr.SqliteHandler.Db().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []ArchiveVersion
+	for rows.Next() {
+		var archiveVersion ArchiveVersion
+		if err := rows.Scan(
+			&archiveVersion.Path,
+			&archiveVersion.Version,
+			&archiveVersion.Destination,
+			&archiveVersion.Size,
+			&archiveVersion.Checksum,
+			&archiveVersion.CRC32C,
+			&archiveVersion.UploadedAt,
+			&archiveVersion.ReplacedAt); err != nil {
+			return nil, err
+		}
+		all = append(all, archiveVersion)
+	}
+	return all, rows.Err()
+}
@@ -0,0 +1,92 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var _ = Describe("ArchiveVersionRepository", func() {
+	var archiveVersionRepository *db.ArchiveVersionRepository
+	var older = db.ArchiveVersion{
+		Path:        "/lib/path/music/Crazy Rhythms",
+		Version:     "20240112T131951Z",
+		Destination: "Crazy Rhythms37141ae2932c8e06cc3716c3b9c55a48.zip",
+		Size:        1024,
+		Checksum:    "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		CRC32C:      "e3069283",
+		UploadedAt:  lastRun.Add(-time.Hour),
+		ReplacedAt:  lastRun,
+	}
+	var newer = db.ArchiveVersion{
+		Path:        "/lib/path/music/Crazy Rhythms",
+		Version:     "20240113T131951Z",
+		Destination: "Crazy Rhythms37141ae2932c8e06cc3716c3b9c55a48.zip",
+		Size:        2048,
+		Checksum:    "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+		CRC32C:      "a6f3e9c1",
+		UploadedAt:  lastRun,
+		ReplacedAt:  lastRun.Add(24 * time.Hour),
+	}
+	var otherFolder = db.ArchiveVersion{
+		Path:        "/lib/path/music/Marquee Moon",
+		Version:     "20240112T131951Z",
+		Destination: "Marquee Moon5c214deb5b2dba739e0d6af56f61d1c7.zip",
+		Size:        512,
+		Checksum:    "baa5a0964d3320fbc0c6a922140453c8513ea24ab8fd0577034804a967248096",
+		CRC32C:      "1c291ca3",
+		UploadedAt:  lastRun.Add(-time.Hour),
+		ReplacedAt:  lastRun,
+	}
+
+	BeforeEach(func() {
+		By("Resetting and connecting to DB")
+		testDbFullPath, err := testutils.SetupTestDb(fakedb)
+		Expect(err).To(BeNil(), "Error trying to setup DB")
+		sqliteHandler := &db.SQLiteHandler{}
+		Expect(sqliteHandler.ConnectSQLite(testDbFullPath)).To(BeNil(), "Failed to connect to sqlite")
+		archiveVersionRepository = &db.ArchiveVersionRepository{SqliteHandler: sqliteHandler}
+		Expect(archiveVersionRepository.CreateTable()).To(BeNil(), "Failed to create table")
+	})
+
+	It("Returns no versions before anything is replaced", func() {
+		Expect(archiveVersionRepository.AllArchiveVersions()).To(BeEmpty())
+	})
+
+	Context("When folders have been replaced", func() {
+		BeforeEach(func() {
+			Expect(archiveVersionRepository.SaveArchiveVersion(otherFolder)).To(BeNil())
+			Expect(archiveVersionRepository.SaveArchiveVersion(older)).To(BeNil())
+			Expect(archiveVersionRepository.SaveArchiveVersion(newer)).To(BeNil())
+		})
+
+		It("Returns every version, newest first within each folder", func() {
+			Expect(archiveVersionRepository.AllArchiveVersions()).To(
+				Equal([]db.ArchiveVersion{newer, older, otherFolder}))
+		})
+
+		It("Returns the versions of a folder, newest first", func() {
+			Expect(archiveVersionRepository.ArchiveVersionsByPath(older.Path)).To(
+				Equal([]db.ArchiveVersion{newer, older}))
+		})
+
+		It("Forgets a pruned version", func() {
+			Expect(archiveVersionRepository.DeleteArchiveVersion(older.Path, older.Version)).To(BeNil())
+			Expect(archiveVersionRepository.ArchiveVersionsByPath(older.Path)).To(
+				Equal([]db.ArchiveVersion{newer}))
+		})
+
+		It("Moves the versions of a renamed folder", func() {
+			Expect(archiveVersionRepository.MoveArchiveVersions(older.Path, "/lib/path/music/Renamed")).To(BeNil())
+			Expect(archiveVersionRepository.ArchiveVersionsByPath(older.Path)).To(BeEmpty())
+			versions, err := archiveVersionRepository.ArchiveVersionsByPath("/lib/path/music/Renamed")
+			Expect(err).To(BeNil())
+			Expect(versions).To(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type ArchivedFolder struct {
+	Path string
+	// FolderId is the stable identity of the folder, which its destination is
+	// named after. It is the media file ID the folder was first archived
+	// under, and is kept when that media file goes away.
+	FolderId    string
+	Destination string
+	Size        int64
+	Checksum    string
+	// CRC32C is the hex encoded CRC32C of the uploaded object, which storage
+	// was checked against.
+	CRC32C string
+	// Fingerprint hashes the files in the folder, leaving out the folder's own
+	// name, so a renamed or moved folder can be matched with its archive.
+	Fingerprint   string
+	NewestMediaAt time.Time
+	UploadedAt    time.Time
+}
+
+type ArchivedFolderRepository struct {
+	SqliteHandler *SQLiteHandler
+}
+
+const archivedFolderColumns = "path, folder_id, destination, size, checksum, crc32c, fingerprint, newest_media_at, uploaded_at"
+
+func (afr *ArchivedFolderRepository) CreateTable() error {
+	_, err := afr.SqliteHandler.Db().Exec(
+		"CREATE TABLE IF NOT EXISTS archived_folder (" +
+			"path TEXT PRIMARY KEY NOT NULL," +
+			"destination TEXT NOT NULL," +
+			"size INTEGER NOT NULL," +
+			"checksum TEXT NOT NULL," +
+			"newest_media_at DATE," +
+			"uploaded_at DATE NOT NULL);")
+	if err != nil {
+		return err
+	}
+	if err := afr.SqliteHandler.AddColumnIfMissing("archived_folder", "crc32c", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := afr.SqliteHandler.AddColumnIfMissing("archived_folder", "folder_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	return afr.SqliteHandler.AddColumnIfMissing("archived_folder", "fingerprint", "TEXT NOT NULL DEFAULT ''")
+}
+
+// SaveArchivedFolder records the latest upload of a folder, replacing any earlier record.
+func (afr *ArchivedFolderRepository) SaveArchivedFolder(archivedFolder ArchivedFolder) error {
+	statement, err := afr.SqliteHandler.Db().Prepare(
+		"INSERT OR REPLACE INTO archived_folder (" + archivedFolderColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	var newestMediaAt any
+	if !archivedFolder.NewestMediaAt.IsZero() {
+		newestMediaAt = archivedFolder.NewestMediaAt.UTC().Format(timeFormat)
+	}
+
+	_, err = statement.Exec(
+		archivedFolder.Path,
+		archivedFolder.FolderId,
+		archivedFolder.Destination,
+		archivedFolder.Size,
+		archivedFolder.Checksum,
+		archivedFolder.CRC32C,
+		archivedFolder.Fingerprint,
+		newestMediaAt,
+		archivedFolder.UploadedAt.UTC().Format(timeFormat))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ArchivedFolderByPath returns nil if the folder has never been archived.
+func (afr *ArchivedFolderRepository) ArchivedFolderByPath(path string) (*ArchivedFolder, error) {
+	row := afr.SqliteHandler.Db().QueryRow(
+		"SELECT "+archivedFolderColumns+" FROM archived_folder WHERE path = ?", path)
+
+	archivedFolder, err := scanArchivedFolder(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return archivedFolder, nil
+}
+
+func (afr *ArchivedFolderRepository) AllArchivedFolders() ([]ArchivedFolder, error) {
+	rows, err := afr.SqliteHandler.Db().Query(
+		"SELECT " + archivedFolderColumns + " FROM archived_folder ORDER BY path")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []ArchivedFolder
+	for rows.Next() {
+		archivedFolder, err := scanArchivedFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, *archivedFolder)
+	}
+	return all, nil
+}
+
+// ArchivedFoldersByFingerprint returns every folder archived with the
+// fingerprint, which is more than one when the same files are in several folders.
+func (afr *ArchivedFolderRepository) ArchivedFoldersByFingerprint(fingerprint string) ([]ArchivedFolder, error) {
+	rows, err := afr.SqliteHandler.Db().Query(
+		"SELECT "+archivedFolderColumns+" FROM archived_folder WHERE fingerprint = ? ORDER BY path", fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []ArchivedFolder
+	for rows.Next() {
+		archivedFolder, err := scanArchivedFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, *archivedFolder)
+	}
+	return all, nil
+}
+
+// DeleteArchivedFolder forgets a folder, so it is archived again from scratch
+// if it ever comes back.
+func (afr *ArchivedFolderRepository) DeleteArchivedFolder(path string) error {
+	_, err := afr.SqliteHandler.Db().Exec("DELETE FROM archived_folder WHERE path = ?", path)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanArchivedFolder(row rowScanner) (*ArchivedFolder, error) {
+	var archivedFolder ArchivedFolder
+	var newestMediaAt sql.NullTime
+	if err := row.Scan(
+		&archivedFolder.Path,
+		&archivedFolder.FolderId,
+		&archivedFolder.Destination,
+		&archivedFolder.Size,
+		&archivedFolder.Checksum,
+		&archivedFolder.CRC32C,
+		&archivedFolder.Fingerprint,
+		&newestMediaAt,
+		&archivedFolder.UploadedAt); err != nil {
+		return nil, err
+	}
+	archivedFolder.NewestMediaAt = newestMediaAt.Time
+	return &archivedFolder, nil
+}
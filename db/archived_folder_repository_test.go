@@ -0,0 +1,140 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var _ = Describe("ArchivedFolderRepository", func() {
+	const folderPath = "/lib/path/music/Crazy Rhythms"
+
+	var archivedFolderRepository *db.ArchivedFolderRepository
+	var archivedFolder = db.ArchivedFolder{
+		Path:          folderPath,
+		FolderId:      "37141ae2932c8e06cc3716c3b9c55a48",
+		Destination:   "Crazy Rhythms37141ae2932c8e06cc3716c3b9c55a48.zip",
+		Size:          1234,
+		Checksum:      "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		CRC32C:        "e3069283",
+		Fingerprint:   "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		NewestMediaAt: lastRun,
+		UploadedAt:    lastRun.Add(time.Hour),
+	}
+
+	var sqliteHandler *db.SQLiteHandler
+
+	BeforeEach(func() {
+		By("Resetting and connecting to DB")
+		testDbFullPath, err := testutils.SetupTestDb(fakedb)
+		Expect(err).To(BeNil(), "Error trying to setup DB")
+		sqliteHandler = &db.SQLiteHandler{}
+		Expect(sqliteHandler.ConnectSQLite(testDbFullPath)).To(BeNil(), "Failed to connect to sqlite")
+		archivedFolderRepository = &db.ArchivedFolderRepository{SqliteHandler: sqliteHandler}
+		Expect(archivedFolderRepository.CreateTable()).To(BeNil(), "Failed to create table")
+	})
+
+	Context("When the folder has not been archived", func() {
+		It("Returns nil", func() {
+			Expect(archivedFolderRepository.ArchivedFolderByPath(folderPath)).To(BeNil())
+		})
+
+		It("Returns no archived folders", func() {
+			Expect(archivedFolderRepository.AllArchivedFolders()).To(BeEmpty())
+		})
+	})
+
+	Context("When the folder has been archived", func() {
+		BeforeEach(func() {
+			Expect(archivedFolderRepository.SaveArchivedFolder(archivedFolder)).To(BeNil())
+		})
+
+		It("Returns the archived folder", func() {
+			Expect(archivedFolderRepository.ArchivedFolderByPath(folderPath)).To(Equal(&archivedFolder))
+		})
+
+		It("Returns every archived folder", func() {
+			Expect(archivedFolderRepository.AllArchivedFolders()).To(Equal([]db.ArchivedFolder{archivedFolder}))
+		})
+
+		It("Finds the folder by its fingerprint", func() {
+			Expect(archivedFolderRepository.ArchivedFoldersByFingerprint(archivedFolder.Fingerprint)).To(
+				Equal([]db.ArchivedFolder{archivedFolder}))
+			Expect(archivedFolderRepository.ArchivedFoldersByFingerprint("other")).To(BeEmpty())
+		})
+
+		It("Forgets the folder when it is deleted", func() {
+			Expect(archivedFolderRepository.DeleteArchivedFolder(folderPath)).To(BeNil())
+			Expect(archivedFolderRepository.ArchivedFolderByPath(folderPath)).To(BeNil())
+		})
+	})
+
+	Context("When the folder is archived again", func() {
+		var rearchivedFolder = archivedFolder
+
+		BeforeEach(func() {
+			rearchivedFolder.Size = 5678
+			rearchivedFolder.UploadedAt = lastRun.Add(48 * time.Hour)
+			Expect(archivedFolderRepository.SaveArchivedFolder(archivedFolder)).To(BeNil())
+			Expect(archivedFolderRepository.SaveArchivedFolder(rearchivedFolder)).To(BeNil())
+		})
+
+		It("Replaces the earlier record", func() {
+			Expect(archivedFolderRepository.AllArchivedFolders()).To(Equal([]db.ArchivedFolder{rearchivedFolder}))
+		})
+	})
+
+	Context("When the newest media time is unknown", func() {
+		var batchFolder = archivedFolder
+
+		BeforeEach(func() {
+			batchFolder.NewestMediaAt = time.Time{}
+			Expect(archivedFolderRepository.SaveArchivedFolder(batchFolder)).To(BeNil())
+		})
+
+		It("Returns a zero time", func() {
+			Expect(archivedFolderRepository.ArchivedFolderByPath(folderPath)).To(Equal(&batchFolder))
+		})
+	})
+
+	Context("When the table was created before the CRC32C, folder ID and fingerprint columns existed", func() {
+		BeforeEach(func() {
+			_, err := sqliteHandler.Db().Exec("DROP TABLE archived_folder")
+			Expect(err).To(BeNil())
+			_, err = sqliteHandler.Db().Exec(
+				"CREATE TABLE archived_folder (" +
+					"path TEXT PRIMARY KEY NOT NULL," +
+					"destination TEXT NOT NULL," +
+					"size INTEGER NOT NULL," +
+					"checksum TEXT NOT NULL," +
+					"newest_media_at DATE," +
+					"uploaded_at DATE NOT NULL);")
+			Expect(err).To(BeNil())
+			_, err = sqliteHandler.Db().Exec(
+				"INSERT INTO archived_folder VALUES (?, ?, ?, ?, NULL, ?)",
+				folderPath, archivedFolder.Destination, archivedFolder.Size, archivedFolder.Checksum,
+				archivedFolder.UploadedAt.Format("2006-01-02 15:04:05"))
+			Expect(err).To(BeNil())
+
+			Expect(archivedFolderRepository.CreateTable()).To(BeNil())
+		})
+
+		It("Adds the columns, leaving them empty for existing folders", func() {
+			existing, err := archivedFolderRepository.ArchivedFolderByPath(folderPath)
+			Expect(err).To(BeNil())
+			Expect(existing.CRC32C).To(BeEmpty())
+			Expect(existing.FolderId).To(BeEmpty())
+			Expect(existing.Fingerprint).To(BeEmpty())
+			Expect(existing.Checksum).To(Equal(archivedFolder.Checksum))
+		})
+
+		It("Can be created again without error", func() {
+			Expect(archivedFolderRepository.CreateTable()).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DbBackup is the last Navidrome DB backup uploaded, so a run can tell
+// whether the Navidrome DB has changed since.
+type DbBackup struct {
+	Object     string
+	Size       int64
+	Checksum   string
+	UploadedAt time.Time
+}
+
+type DbBackupRepository struct {
+	SqliteHandler *SQLiteHandler
+}
+
+const dbBackupColumns = "object, size, checksum, uploaded_at"
+
+func (dbr *DbBackupRepository) CreateTable() error {
+	_, err := dbr.SqliteHandler.Db().Exec(
+		"CREATE TABLE IF NOT EXISTS db_backup (" +
+			"id INTEGER PRIMARY KEY NOT NULL," +
+			"object TEXT NOT NULL," +
+			"size INTEGER NOT NULL," +
+			"checksum TEXT NOT NULL," +
+			"uploaded_at DATE NOT NULL);")
+	return err
+}
+
+// LastDbBackup returns the last backup uploaded, or nil if there has not
+// been one.
+func (dbr *DbBackupRepository) LastDbBackup() (*DbBackup, error) {
+	var dbBackup DbBackup
+	err := dbr.SqliteHandler.Db().QueryRow(
+		"SELECT "+dbBackupColumns+" FROM db_backup WHERE id = 1").Scan(
+		&dbBackup.Object, &dbBackup.Size, &dbBackup.Checksum, &dbBackup.UploadedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dbBackup, nil
+}
+
+// SaveDbBackup records the backup uploaded, replacing the last one.
+func (dbr *DbBackupRepository) SaveDbBackup(dbBackup DbBackup) error {
+	statement, err := dbr.SqliteHandler.Db().Prepare(
+		"INSERT OR REPLACE INTO db_backup (id, " + dbBackupColumns + ") VALUES (1, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(
+		dbBackup.Object,
+		dbBackup.Size,
+		dbBackup.Checksum,
+		dbBackup.UploadedAt.UTC().Format(timeFormat))
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var _ = Describe("DbBackupRepository", func() {
+	var dbBackupRepository *db.DbBackupRepository
+
+	BeforeEach(func() {
+		By("Resetting and connecting to DB")
+		testDbFullPath, err := testutils.SetupTestDb(fakedb)
+		Expect(err).To(BeNil(), "Error trying to setup DB")
+		sqliteHandler := &db.SQLiteHandler{}
+		Expect(sqliteHandler.ConnectSQLite(testDbFullPath)).To(BeNil(), "Failed to connect to sqlite")
+		dbBackupRepository = &db.DbBackupRepository{SqliteHandler: sqliteHandler}
+		Expect(dbBackupRepository.CreateTable()).To(BeNil(), "Failed to create table")
+	})
+
+	It("Returns nil before any backup is uploaded", func() {
+		Expect(dbBackupRepository.LastDbBackup()).To(BeNil())
+	})
+
+	Context("When backups are uploaded", func() {
+		var latest = db.DbBackup{
+			Object:     "db-backups/navidrome-backup-20240112T131951Z.sqlite",
+			Size:       4096,
+			Checksum:   "second",
+			UploadedAt: lastRun,
+		}
+
+		BeforeEach(func() {
+			Expect(dbBackupRepository.SaveDbBackup(db.DbBackup{
+				Object:     "navidrome-backup.sqlite",
+				Size:       2048,
+				Checksum:   "first",
+				UploadedAt: lastRun.Add(-24 * time.Hour),
+			})).To(BeNil())
+			Expect(dbBackupRepository.SaveDbBackup(latest)).To(BeNil())
+		})
+
+		It("Returns the last one", func() {
+			Expect(dbBackupRepository.LastDbBackup()).To(Equal(&latest))
+		})
+	})
+})
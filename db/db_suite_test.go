@@ -3,6 +3,8 @@ package db_test
 import (
 	"testing"
 
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -11,3 +13,7 @@ func TestDb(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Db Suite")
 }
+
+var _ = AfterSuite(func() {
+	Expect(testutils.RemoveTestDbs("fakenavidrome", fakedb, "missing_fakenavidrome")).To(BeNil())
+})
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// FileHashRepository caches the SHA-256 of files in the library, keyed by
+// path, size and modification time, so folders can be fingerprinted without
+// reading files that have not changed.
+// Modification times are kept to the nanosecond, as a file rewritten within
+// the same second is otherwise taken to be unchanged.
+type FileHashRepository struct {
+	SqliteHandler *SQLiteHandler
+}
+
+func (fhr *FileHashRepository) CreateTable() error {
+	_, err := fhr.SqliteHandler.Db().Exec(
+		"CREATE TABLE IF NOT EXISTS file_hash (" +
+			"path TEXT PRIMARY KEY NOT NULL," +
+			"size INTEGER NOT NULL," +
+			"mod_time TEXT NOT NULL," +
+			"sha256 TEXT NOT NULL);")
+	return err
+}
+
+// CachedHash returns the SHA-256 the file had when it last had this size and
+// modification time, or an empty string if it has changed since.
+func (fhr *FileHashRepository) CachedHash(path string, size int64, modTime time.Time) (string, error) {
+	var sha256 string
+	err := fhr.SqliteHandler.Db().QueryRow(
+		"SELECT sha256 FROM file_hash WHERE path = ? AND size = ? AND mod_time = ?",
+		path, size, modTime.UTC().Format(time.RFC3339Nano)).Scan(&sha256)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return sha256, err
+}
+
+// CacheHash records the SHA-256 of the file, replacing what was cached for an
+// earlier version of it.
+func (fhr *FileHashRepository) CacheHash(path string, size int64, modTime time.Time, sha256 string) error {
+	statement, err := fhr.SqliteHandler.Db().Prepare(
+		"INSERT OR REPLACE INTO file_hash (path, size, mod_time, sha256) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(path, size, modTime.UTC().Format(time.RFC3339Nano), sha256)
+	return err
+}
+
+// CachedPaths returns the path of every file with a cached hash.
+func (fhr *FileHashRepository) CachedPaths() ([]string, error) {
+	rows, err := fhr.SqliteHandler.Db().Query("SELECT path FROM file_hash ORDER BY path")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// DeleteCachedHash forgets the hash of a file that is no longer in the library.
+func (fhr *FileHashRepository) DeleteCachedHash(path string) error {
+	_, err := fhr.SqliteHandler.Db().Exec("DELETE FROM file_hash WHERE path = ?", path)
+	return err
+}
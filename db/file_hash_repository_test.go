@@ -0,0 +1,67 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var _ = Describe("FileHashRepository", func() {
+	const path = "/lib/path/music/Crazy Rhythms/01 - Shy.mp3"
+	const sha256 = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	var fileHashRepository *db.FileHashRepository
+	var modTime = lastRun.Add(123 * time.Millisecond)
+
+	BeforeEach(func() {
+		By("Resetting and connecting to DB")
+		testDbFullPath, err := testutils.SetupTestDb(fakedb)
+		Expect(err).To(BeNil(), "Error trying to setup DB")
+		sqliteHandler := &db.SQLiteHandler{}
+		Expect(sqliteHandler.ConnectSQLite(testDbFullPath)).To(BeNil(), "Failed to connect to sqlite")
+		fileHashRepository = &db.FileHashRepository{SqliteHandler: sqliteHandler}
+		Expect(fileHashRepository.CreateTable()).To(BeNil(), "Failed to create table")
+	})
+
+	It("Returns no hash for a file that was never cached", func() {
+		Expect(fileHashRepository.CachedHash(path, 1024, modTime)).To(BeEmpty())
+	})
+
+	Context("When a hash is cached", func() {
+		BeforeEach(func() {
+			Expect(fileHashRepository.CacheHash(path, 1024, modTime, sha256)).To(BeNil())
+		})
+
+		It("Returns the hash while the file is unchanged", func() {
+			Expect(fileHashRepository.CachedHash(path, 1024, modTime)).To(Equal(sha256))
+		})
+
+		It("Returns no hash once the size or modification time changes", func() {
+			Expect(fileHashRepository.CachedHash(path, 2048, modTime)).To(BeEmpty())
+			Expect(fileHashRepository.CachedHash(path, 1024, modTime.Add(time.Millisecond))).To(BeEmpty())
+		})
+
+		It("Replaces the hash of an earlier version of the file", func() {
+			Expect(fileHashRepository.CacheHash(path, 2048, modTime, "other")).To(BeNil())
+			Expect(fileHashRepository.CachedHash(path, 2048, modTime)).To(Equal("other"))
+			Expect(fileHashRepository.CachedHash(path, 1024, modTime)).To(BeEmpty())
+		})
+
+		It("Lists the path of every cached file", func() {
+			Expect(fileHashRepository.CacheHash("/lib/path/music/Crazy Rhythms/02 - Raised Eyebrows.mp3",
+				1024, modTime, sha256)).To(BeNil())
+			Expect(fileHashRepository.CachedPaths()).To(Equal([]string{
+				path, "/lib/path/music/Crazy Rhythms/02 - Raised Eyebrows.mp3"}))
+		})
+
+		It("Forgets the hash of a deleted file", func() {
+			Expect(fileHashRepository.DeleteCachedHash(path)).To(BeNil())
+			Expect(fileHashRepository.CachedHash(path, 1024, modTime)).To(BeEmpty())
+			Expect(fileHashRepository.CachedPaths()).To(BeEmpty())
+		})
+	})
+})
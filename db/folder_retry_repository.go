@@ -0,0 +1,96 @@
+package db
+
+import (
+	"time"
+)
+
+// FolderRetry records a folder a scheduled run failed to archive, so later
+// runs retry it even though its media is no longer newer than the last run.
+type FolderRetry struct {
+	Path string
+	// PathIdentifier is the folder's path identifier as JSON, as it was when
+	// the folder was first picked up.
+	PathIdentifier string
+	// NewestMediaAt is the newest media time of the folder, which the path
+	// identifier leaves out, so a retry is not taken as already archived.
+	NewestMediaAt time.Time
+	Reason        string
+	Attempts      int
+	FirstFailedAt time.Time
+	LastFailedAt  time.Time
+}
+
+type FolderRetryRepository struct {
+	SqliteHandler *SQLiteHandler
+}
+
+const folderRetryColumns = "path, path_identifier, newest_media_at, reason, attempts, first_failed_at, last_failed_at"
+
+func (frr *FolderRetryRepository) CreateTable() error {
+	_, err := frr.SqliteHandler.Db().Exec(
+		"CREATE TABLE IF NOT EXISTS folder_retry (" +
+			"path TEXT PRIMARY KEY NOT NULL," +
+			"path_identifier TEXT NOT NULL," +
+			"newest_media_at DATE NOT NULL," +
+			"reason TEXT NOT NULL," +
+			"attempts INTEGER NOT NULL," +
+			"first_failed_at DATE NOT NULL," +
+			"last_failed_at DATE NOT NULL);")
+	return err
+}
+
+// SaveFolderRetry records a failed folder, replacing any earlier record.
+func (frr *FolderRetryRepository) SaveFolderRetry(folderRetry FolderRetry) error {
+	statement, err := frr.SqliteHandler.Db().Prepare(
+		"INSERT OR REPLACE INTO folder_retry (" + folderRetryColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(
+		folderRetry.Path,
+		folderRetry.PathIdentifier,
+		folderRetry.NewestMediaAt.UTC().Format(timeFormat),
+		folderRetry.Reason,
+		folderRetry.Attempts,
+		folderRetry.FirstFailedAt.UTC().Format(timeFormat),
+		folderRetry.LastFailedAt.UTC().Format(timeFormat))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteFolderRetry forgets a failed folder, for when it is archived or
+// given up on.
+func (frr *FolderRetryRepository) DeleteFolderRetry(path string) error {
+	_, err := frr.SqliteHandler.Db().Exec("DELETE FROM folder_retry WHERE path = ?", path)
+	return err
+}
+
+func (frr *FolderRetryRepository) AllFolderRetries() ([]FolderRetry, error) {
+	rows, err := frr.SqliteHandler.Db().Query(
+		"SELECT " + folderRetryColumns + " FROM folder_retry ORDER BY path")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []FolderRetry
+	for rows.Next() {
+		var folderRetry FolderRetry
+		if err := rows.Scan(
+			&folderRetry.Path,
+			&folderRetry.PathIdentifier,
+			&folderRetry.NewestMediaAt,
+			&folderRetry.Reason,
+			&folderRetry.Attempts,
+			&folderRetry.FirstFailedAt,
+			&folderRetry.LastFailedAt); err != nil {
+			return nil, err
+		}
+		all = append(all, folderRetry)
+	}
+	return all, rows.Err()
+}
@@ -0,0 +1,72 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var _ = Describe("FolderRetryRepository", func() {
+	var folderRetryRepository *db.FolderRetryRepository
+	var crazyRhythms = db.FolderRetry{
+		Path:           "/lib/path/music/Crazy Rhythms",
+		PathIdentifier: `{"UploadType":0,"Id":"37141ae2932c8e06cc3716c3b9c55a48","BasePath":"Crazy Rhythms"}`,
+		NewestMediaAt:  lastRun.Add(-time.Hour),
+		Reason:         "bucket unavailable",
+		Attempts:       1,
+		FirstFailedAt:  lastRun,
+		LastFailedAt:   lastRun,
+	}
+	var marqueeMoon = db.FolderRetry{
+		Path:           "/lib/path/music/Marquee Moon",
+		PathIdentifier: `{"UploadType":1,"Id":"5c214deb5b2dba739e0d6af56f61d1c7","BasePath":"Marquee Moon"}`,
+		NewestMediaAt:  lastRun.Add(-48 * time.Hour),
+		Reason:         "permission denied",
+		Attempts:       2,
+		FirstFailedAt:  lastRun.Add(-24 * time.Hour),
+		LastFailedAt:   lastRun,
+	}
+
+	BeforeEach(func() {
+		By("Resetting and connecting to DB")
+		testDbFullPath, err := testutils.SetupTestDb(fakedb)
+		Expect(err).To(BeNil(), "Error trying to setup DB")
+		sqliteHandler := &db.SQLiteHandler{}
+		Expect(sqliteHandler.ConnectSQLite(testDbFullPath)).To(BeNil(), "Failed to connect to sqlite")
+		folderRetryRepository = &db.FolderRetryRepository{SqliteHandler: sqliteHandler}
+		Expect(folderRetryRepository.CreateTable()).To(BeNil(), "Failed to create table")
+	})
+
+	It("Returns no retries before anything fails", func() {
+		Expect(folderRetryRepository.AllFolderRetries()).To(BeEmpty())
+	})
+
+	Context("When folders have failed", func() {
+		BeforeEach(func() {
+			Expect(folderRetryRepository.SaveFolderRetry(marqueeMoon)).To(BeNil())
+			Expect(folderRetryRepository.SaveFolderRetry(crazyRhythms)).To(BeNil())
+		})
+
+		It("Returns every retry", func() {
+			Expect(folderRetryRepository.AllFolderRetries()).To(Equal([]db.FolderRetry{crazyRhythms, marqueeMoon}))
+		})
+
+		It("Replaces the earlier failure of a folder", func() {
+			failedAgain := crazyRhythms
+			failedAgain.Attempts = 2
+			failedAgain.LastFailedAt = lastRun.Add(24 * time.Hour)
+			Expect(folderRetryRepository.SaveFolderRetry(failedAgain)).To(BeNil())
+			Expect(folderRetryRepository.AllFolderRetries()).To(Equal([]db.FolderRetry{failedAgain, marqueeMoon}))
+		})
+
+		It("Forgets a folder once it is archived", func() {
+			Expect(folderRetryRepository.DeleteFolderRetry(crazyRhythms.Path)).To(BeNil())
+			Expect(folderRetryRepository.AllFolderRetries()).To(Equal([]db.FolderRetry{marqueeMoon}))
+		})
+	})
+})
@@ -1,5 +1,11 @@
 package db
 
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
 type Library struct {
 	Id   int
 	Path string
@@ -25,3 +31,60 @@ func (lr *LibraryRepository) LibraryById(id int) (*Library, error) {
 	}
 	return &library, nil
 }
+
+// AllLibraries returns every library, or none for a Navidrome DB from before
+// libraries were added.
+func (lr *LibraryRepository) AllLibraries() ([]Library, error) {
+	exists, err := lr.SqliteHandler.TableExists("library")
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := lr.SqliteHandler.Db().Query("SELECT id, path FROM library ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []Library
+	for rows.Next() {
+		var library Library
+		if err := rows.Scan(&library.Id, &library.Path); err != nil {
+			return nil, err
+		}
+		all = append(all, library)
+	}
+	return all, rows.Err()
+}
+
+// ReplacePathRoot moves every library, and any media file stored with an
+// absolute path, from beneath oldRoot to beneath newRoot.
+func (lr *LibraryRepository) ReplacePathRoot(oldRoot string, newRoot string) (int64, error) {
+	oldRoot = strings.TrimSuffix(oldRoot, "/")
+	newRoot = strings.TrimSuffix(newRoot, "/")
+	oldRootLength := utf8.RuneCountInString(oldRoot)
+
+	var updated int64
+	for _, table := range []string{"library", "media_file"} {
+		exists, err := lr.SqliteHandler.TableExists(table)
+		if err != nil {
+			return updated, err
+		}
+		if !exists {
+			continue
+		}
+
+		result, err := lr.SqliteHandler.Db().Exec(fmt.Sprintf(
+			"UPDATE %v SET path = ? || substr(path, ?) WHERE path = ? OR substr(path, 1, ?) = ?", table),
+			newRoot, oldRootLength+1, oldRoot, oldRootLength+1, oldRoot+"/")
+		if err != nil {
+			return updated, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return updated, err
+		}
+		updated += rowsAffected
+	}
+	return updated, nil
+}
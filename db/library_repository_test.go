@@ -34,4 +34,53 @@ var _ = Describe("LastRun", func() {
 			Path: "/lib/path",
 		}))
 	})
+
+	It("Returns every library", func() {
+		Expect(libraryRepository.AllLibraries()).To(Equal([]db.Library{{Id: 1, Path: "/lib/path"}}))
+	})
+})
+
+var _ = Describe("ReplacePathRoot", func() {
+	const absoluteMediaFileId = "5c214deb5b2dba739e0d6af56f61d1c7"
+	const relativeMediaFileId = "37141ae2932c8e06cc3716c3b9c55a48"
+
+	var testDbFullPath = ""
+	var err error
+	var libraryRepository *db.LibraryRepository
+	var updated int64
+
+	BeforeEach(func() {
+		By("Resetting and connecting to DB")
+		testDbFullPath, err = testutils.SetupTestDb("fakenavidrome")
+		Expect(err).To(BeNil(), "Error trying to setup DB")
+		sqliteHandler := &db.SQLiteHandler{}
+		Expect(sqliteHandler.ConnectSQLite(testDbFullPath)).To(BeNil(), "Failed to connect to sqlite")
+		libraryRepository = &db.LibraryRepository{SqliteHandler: sqliteHandler}
+
+		By("Making one media file path absolute, as older Navidrome versions stored them")
+		Expect(testutils.UpdateMediaFilePathPrefix(testDbFullPath, "/lib/path", "music/Crazy Rhythms/feelies, the - crazy rhythms - 09")).
+			To(BeNil(), "Failed to update media file path")
+
+		updated, err = libraryRepository.ReplacePathRoot("/lib/path/", "/mnt/nas/music")
+	})
+
+	It("Returns nil", func() {
+		Expect(err).To(BeNil())
+	})
+
+	It("Updates the library and absolute media file paths", func() {
+		Expect(updated).To(Equal(int64(2)))
+	})
+
+	It("Moves the library root", func() {
+		Expect(testutils.LibraryPathRecord(testDbFullPath, 1)).To(Equal("/mnt/nas/music"))
+	})
+
+	It("Moves absolute media file paths", func() {
+		Expect(testutils.MediaFilePathRecord(testDbFullPath, absoluteMediaFileId)).To(HavePrefix("/mnt/nas/music/music/Crazy Rhythms/"))
+	})
+
+	It("Leaves relative media file paths alone", func() {
+		Expect(testutils.MediaFilePathRecord(testDbFullPath, relativeMediaFileId)).To(HavePrefix("music/Crazy Rhythms/"))
+	})
 })
@@ -80,6 +80,27 @@ func (mfr *MusicFoldersRepository) AllMediaFiles() ([]MediaFile, error) {
 	return all, nil
 }
 
+// PresentMediaFiles is every media file except the ones Navidrome has marked
+// missing, for versions of Navidrome that keep missing files in the library.
+func (mfr *MusicFoldersRepository) PresentMediaFiles() ([]MediaFile, error) {
+	hasMissing, err := mfr.SqliteHandler.ColumnExists("media_file", "missing")
+	if err != nil {
+		return nil, err
+	}
+	if !hasMissing {
+		return mfr.AllMediaFiles()
+	}
+
+	rows, err := mfr.SqliteHandler.Db().Query(
+		"SELECT id, path, created_at, updated_at, library_id FROM media_file WHERE NOT missing")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return mfr.mediaFilesFromRows(rows)
+}
+
 func (mfr *MusicFoldersRepository) mediaFilesFromRows(rows *sql.Rows) ([]MediaFile, error) {
 	var all []MediaFile
 	for rows.Next() {
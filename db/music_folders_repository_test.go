@@ -386,6 +386,19 @@ var _ = Describe("AllMediaFiles", func() {
 	It("should return the expected media files", func() {
 		Expect(musicFoldersRepository.AllMediaFiles()).To(ConsistOf(expectedMediaFiles))
 	})
+
+	It("should return every media file as present when Navidrome does not track missing files", func() {
+		Expect(musicFoldersRepository.PresentMediaFiles()).To(ConsistOf(expectedMediaFiles))
+	})
+
+	It("should leave out the media files Navidrome has marked missing", func() {
+		_, err := sqliteHandler.Db().Exec("ALTER TABLE media_file ADD COLUMN missing BOOL DEFAULT FALSE NOT NULL")
+		Expect(err).To(BeNil())
+		_, err = sqliteHandler.Db().Exec("UPDATE media_file SET missing = TRUE WHERE path LIKE ?", "%Lovedrug%")
+		Expect(err).To(BeNil())
+
+		Expect(musicFoldersRepository.PresentMediaFiles()).To(ConsistOf(expectedMediaFiles[:3]))
+	})
 })
 
 func timeParse(dateString string) time.Time {
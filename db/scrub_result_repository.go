@@ -0,0 +1,74 @@
+package db
+
+import (
+	"time"
+)
+
+// ScrubResult is the outcome of the last time an archived folder was
+// downloaded and checked.
+type ScrubResult struct {
+	Path        string
+	Destination string
+	ScrubbedAt  time.Time
+	// Error is empty when the archive passed.
+	Error string
+}
+
+type ScrubResultRepository struct {
+	SqliteHandler *SQLiteHandler
+}
+
+const scrubResultColumns = "path, destination, scrubbed_at, error"
+
+func (srr *ScrubResultRepository) CreateTable() error {
+	_, err := srr.SqliteHandler.Db().Exec(
+		"CREATE TABLE IF NOT EXISTS scrub_result (" +
+			"path TEXT PRIMARY KEY NOT NULL," +
+			"destination TEXT NOT NULL," +
+			"scrubbed_at DATE NOT NULL," +
+			"error TEXT NOT NULL);")
+	return err
+}
+
+// SaveScrubResult records the latest scrub of a folder, replacing any earlier record.
+func (srr *ScrubResultRepository) SaveScrubResult(scrubResult ScrubResult) error {
+	statement, err := srr.SqliteHandler.Db().Prepare(
+		"INSERT OR REPLACE INTO scrub_result (" + scrubResultColumns + ") VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(
+		scrubResult.Path,
+		scrubResult.Destination,
+		scrubResult.ScrubbedAt.UTC().Format(timeFormat),
+		scrubResult.Error)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (srr *ScrubResultRepository) AllScrubResults() ([]ScrubResult, error) {
+	rows, err := srr.SqliteHandler.Db().Query(
+		"SELECT " + scrubResultColumns + " FROM scrub_result ORDER BY path")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []ScrubResult
+	for rows.Next() {
+		var scrubResult ScrubResult
+		if err := rows.Scan(
+			&scrubResult.Path,
+			&scrubResult.Destination,
+			&scrubResult.ScrubbedAt,
+			&scrubResult.Error); err != nil {
+			return nil, err
+		}
+		all = append(all, scrubResult)
+	}
+	return all, rows.Err()
+}
@@ -0,0 +1,60 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var _ = Describe("ScrubResultRepository", func() {
+	var scrubResultRepository *db.ScrubResultRepository
+	var passed = db.ScrubResult{
+		Path:        "/lib/path/music/Crazy Rhythms",
+		Destination: "Crazy Rhythms37141ae2932c8e06cc3716c3b9c55a48.zip",
+		ScrubbedAt:  lastRun,
+	}
+	var failed = db.ScrubResult{
+		Path:        "/lib/path/music/Marquee Moon",
+		Destination: "Marquee Moon5c214deb5b2dba739e0d6af56f61d1c7.zip",
+		ScrubbedAt:  lastRun.Add(time.Hour),
+		Error:       "zip: checksum error",
+	}
+
+	BeforeEach(func() {
+		By("Resetting and connecting to DB")
+		testDbFullPath, err := testutils.SetupTestDb(fakedb)
+		Expect(err).To(BeNil(), "Error trying to setup DB")
+		sqliteHandler := &db.SQLiteHandler{}
+		Expect(sqliteHandler.ConnectSQLite(testDbFullPath)).To(BeNil(), "Failed to connect to sqlite")
+		scrubResultRepository = &db.ScrubResultRepository{SqliteHandler: sqliteHandler}
+		Expect(scrubResultRepository.CreateTable()).To(BeNil(), "Failed to create table")
+	})
+
+	It("Returns no results before anything is scrubbed", func() {
+		Expect(scrubResultRepository.AllScrubResults()).To(BeEmpty())
+	})
+
+	Context("When folders have been scrubbed", func() {
+		BeforeEach(func() {
+			Expect(scrubResultRepository.SaveScrubResult(failed)).To(BeNil())
+			Expect(scrubResultRepository.SaveScrubResult(passed)).To(BeNil())
+		})
+
+		It("Returns every result", func() {
+			Expect(scrubResultRepository.AllScrubResults()).To(Equal([]db.ScrubResult{passed, failed}))
+		})
+
+		It("Replaces the earlier result of a folder", func() {
+			rescrubbed := failed
+			rescrubbed.ScrubbedAt = lastRun.Add(48 * time.Hour)
+			rescrubbed.Error = ""
+			Expect(scrubResultRepository.SaveScrubResult(rescrubbed)).To(BeNil())
+			Expect(scrubResultRepository.AllScrubResults()).To(Equal([]db.ScrubResult{passed, rescrubbed}))
+		})
+	})
+})
@@ -2,6 +2,8 @@ package db
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 )
 
 type SQLiteHandler struct {
@@ -21,3 +23,56 @@ func (handler *SQLiteHandler) ConnectSQLite(dsn string) error {
 	handler.db = database
 	return nil
 }
+
+func (handler *SQLiteHandler) Close() error {
+	if handler.db == nil {
+		return nil
+	}
+	return handler.db.Close()
+}
+
+func (handler *SQLiteHandler) TableExists(table string) (bool, error) {
+	var name string
+	err := handler.db.QueryRow(
+		"SELECT name FROM sqlite_master WHERE type='table' AND name = ?", table).Scan(&name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ColumnExists reports whether a table has a column, which differs between
+// versions of the archiver and of Navidrome.
+func (handler *SQLiteHandler) ColumnExists(table string, column string) (bool, error) {
+	rows, err := handler.db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%v')", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// AddColumnIfMissing adds a column to a table that was created by an older
+// version of the archiver, before the column existed.
+func (handler *SQLiteHandler) AddColumnIfMissing(table string, column string, definition string) error {
+	exists, err := handler.ColumnExists(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = handler.db.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", table, column, definition))
+	return err
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"time"
+)
+
+type TombstoneState string
+
+const (
+	// TombstoneRecorded folders are gone from the library, but their
+	// objects are untouched.
+	TombstoneRecorded TombstoneState = "recorded"
+	// TombstoneMoved folders have had their objects moved under the
+	// deleted prefix.
+	TombstoneMoved TombstoneState = "moved"
+	// TombstonePurged folders have had their objects deleted from storage.
+	TombstonePurged TombstoneState = "purged"
+)
+
+// Tombstone records an archived folder that no longer has any media in the
+// library, and what has been done with its objects since.
+type Tombstone struct {
+	Path        string
+	Destination string
+	DeletedAt   time.Time
+	State       TombstoneState
+}
+
+type TombstoneRepository struct {
+	SqliteHandler *SQLiteHandler
+}
+
+const tombstoneColumns = "path, destination, deleted_at, state"
+
+func (tr *TombstoneRepository) CreateTable() error {
+	_, err := tr.SqliteHandler.Db().Exec(
+		"CREATE TABLE IF NOT EXISTS tombstone (" +
+			"path TEXT PRIMARY KEY NOT NULL," +
+			"destination TEXT NOT NULL," +
+			"deleted_at DATE NOT NULL," +
+			"state TEXT NOT NULL);")
+	return err
+}
+
+// SaveTombstone records a deleted folder, replacing any earlier record.
+func (tr *TombstoneRepository) SaveTombstone(tombstone Tombstone) error {
+	statement, err := tr.SqliteHandler.Db().Prepare(
+		"INSERT OR REPLACE INTO tombstone (" + tombstoneColumns + ") VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(
+		tombstone.Path,
+		tombstone.Destination,
+		tombstone.DeletedAt.UTC().Format(timeFormat),
+		string(tombstone.State))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteTombstone forgets a deleted folder, for when it comes back.
+func (tr *TombstoneRepository) DeleteTombstone(path string) error {
+	_, err := tr.SqliteHandler.Db().Exec("DELETE FROM tombstone WHERE path = ?", path)
+	return err
+}
+
+func (tr *TombstoneRepository) AllTombstones() ([]Tombstone, error) {
+	rows, err := tr.SqliteHandler.Db().Query(
+		"SELECT " + tombstoneColumns + " FROM tombstone ORDER BY path")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []Tombstone
+	for rows.Next() {
+		var tombstone Tombstone
+		if err := rows.Scan(
+			&tombstone.Path,
+			&tombstone.Destination,
+			&tombstone.DeletedAt,
+			&tombstone.State); err != nil {
+			return nil, err
+		}
+		all = append(all, tombstone)
+	}
+	return all, rows.Err()
+}
@@ -0,0 +1,65 @@
+package db_test
+
+import (
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var _ = Describe("TombstoneRepository", func() {
+	var tombstoneRepository *db.TombstoneRepository
+	var recorded = db.Tombstone{
+		Path:        "/lib/path/music/Crazy Rhythms",
+		Destination: "Crazy Rhythms37141ae2932c8e06cc3716c3b9c55a48.zip",
+		DeletedAt:   lastRun,
+		State:       db.TombstoneRecorded,
+	}
+	var moved = db.Tombstone{
+		Path:        "/lib/path/music/Marquee Moon",
+		Destination: "Marquee Moon5c214deb5b2dba739e0d6af56f61d1c7.zip",
+		DeletedAt:   lastRun.Add(time.Hour),
+		State:       db.TombstoneMoved,
+	}
+
+	BeforeEach(func() {
+		By("Resetting and connecting to DB")
+		testDbFullPath, err := testutils.SetupTestDb(fakedb)
+		Expect(err).To(BeNil(), "Error trying to setup DB")
+		sqliteHandler := &db.SQLiteHandler{}
+		Expect(sqliteHandler.ConnectSQLite(testDbFullPath)).To(BeNil(), "Failed to connect to sqlite")
+		tombstoneRepository = &db.TombstoneRepository{SqliteHandler: sqliteHandler}
+		Expect(tombstoneRepository.CreateTable()).To(BeNil(), "Failed to create table")
+	})
+
+	It("Returns no tombstones before anything is deleted", func() {
+		Expect(tombstoneRepository.AllTombstones()).To(BeEmpty())
+	})
+
+	Context("When folders have been deleted", func() {
+		BeforeEach(func() {
+			Expect(tombstoneRepository.SaveTombstone(moved)).To(BeNil())
+			Expect(tombstoneRepository.SaveTombstone(recorded)).To(BeNil())
+		})
+
+		It("Returns every tombstone", func() {
+			Expect(tombstoneRepository.AllTombstones()).To(Equal([]db.Tombstone{recorded, moved}))
+		})
+
+		It("Replaces the earlier state of a folder", func() {
+			purged := moved
+			purged.State = db.TombstonePurged
+			Expect(tombstoneRepository.SaveTombstone(purged)).To(BeNil())
+			Expect(tombstoneRepository.AllTombstones()).To(Equal([]db.Tombstone{recorded, purged}))
+		})
+
+		It("Forgets a folder that comes back", func() {
+			Expect(tombstoneRepository.DeleteTombstone(moved.Path)).To(BeNil())
+			Expect(tombstoneRepository.AllTombstones()).To(Equal([]db.Tombstone{recorded}))
+		})
+	})
+})
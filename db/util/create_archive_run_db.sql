@@ -5,3 +5,66 @@ CREATE TABLE IF NOT EXISTS archive_run (
     last_run DATE NOT NULL,
     CONSTRAINT id_unique UNIQUE (id)
 );
+
+CREATE TABLE IF NOT EXISTS archived_folder (
+    path TEXT PRIMARY KEY NOT NULL,
+    destination TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    checksum TEXT NOT NULL,
+    newest_media_at DATE,
+    uploaded_at DATE NOT NULL,
+    crc32c TEXT NOT NULL DEFAULT '',
+    folder_id TEXT NOT NULL DEFAULT '',
+    fingerprint TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS archive_version (
+    path TEXT NOT NULL,
+    version TEXT NOT NULL,
+    destination TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    checksum TEXT NOT NULL,
+    crc32c TEXT NOT NULL,
+    uploaded_at DATE NOT NULL,
+    replaced_at DATE NOT NULL,
+    PRIMARY KEY (path, version)
+);
+
+CREATE TABLE IF NOT EXISTS tombstone (
+    path TEXT PRIMARY KEY NOT NULL,
+    destination TEXT NOT NULL,
+    deleted_at DATE NOT NULL,
+    state TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS folder_retry (
+    path TEXT PRIMARY KEY NOT NULL,
+    path_identifier TEXT NOT NULL,
+    newest_media_at DATE NOT NULL,
+    reason TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    first_failed_at DATE NOT NULL,
+    last_failed_at DATE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS file_hash (
+    path TEXT PRIMARY KEY NOT NULL,
+    size INTEGER NOT NULL,
+    mod_time TEXT NOT NULL,
+    sha256 TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS scrub_result (
+    path TEXT PRIMARY KEY NOT NULL,
+    destination TEXT NOT NULL,
+    scrubbed_at DATE NOT NULL,
+    error TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS db_backup (
+    id INTEGER PRIMARY KEY NOT NULL,
+    object TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    checksum TEXT NOT NULL,
+    uploaded_at DATE NOT NULL
+);
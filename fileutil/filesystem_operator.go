@@ -1,6 +1,11 @@
 package fileutil
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
 	"io/fs"
 	"os"
 	"time"
@@ -9,6 +14,12 @@ import (
 type FileSystemOperator struct {
 }
 
+// CRC32CTable is the Castagnoli table for the CRC32C checksums GCS and S3
+// store objects with.
+var CRC32CTable = crc32.MakeTable(crc32.Castagnoli)
+
+var ErrFreeSpaceUnsupported = errors.New("checking free space is not supported on this platform")
+
 func (fso *FileSystemOperator) FileNamesFromPath(folderPath string) ([]string, error) {
 	filepathFolder, err := fso.OpenFile(folderPath)
 	if err != nil {
@@ -72,6 +83,53 @@ func (fso *FileSystemOperator) ReadFile(filePath string) ([]byte, error) {
 	return data, nil
 }
 
+func (fso *FileSystemOperator) CreateDirectory(folderPath string) error {
+	err := os.MkdirAll(folderPath, 0755)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (fso *FileSystemOperator) RenameFile(oldPath string, newPath string) error {
+	err := os.Rename(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// FileChecksum returns the hex encoded SHA-256 of the file.
+func (fso *FileSystemOperator) FileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// FileCRC32C returns the hex encoded CRC32C of the file, the checksum GCS
+// checks uploads against.
+func (fso *FileSystemOperator) FileCRC32C(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := crc32.New(CRC32CTable)
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 //go:generate mockery --name IFileSystemOperator
 type IFileSystemOperator interface {
 	FileNamesFromPath(folderPath string) ([]string, error)
@@ -81,6 +139,11 @@ type IFileSystemOperator interface {
 	DeleteFile(filePath string) error
 	WriteNewFile(filePath string, data []byte) error
 	ReadFile(filePath string) ([]byte, error)
+	CreateDirectory(folderPath string) error
+	RenameFile(oldPath string, newPath string) error
+	FileChecksum(filePath string) (string, error)
+	FileCRC32C(filePath string) (string, error)
+	FreeSpace(path string) (uint64, error)
 }
 
 //go:generate mockery --name IArchiveFile
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package fileutil
+
+// FreeSpace is not supported on this platform.
+func (fso *FileSystemOperator) FreeSpace(path string) (uint64, error) {
+	return 0, ErrFreeSpaceUnsupported
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package fileutil
+
+import "syscall"
+
+// FreeSpace returns the bytes available to an unprivileged user on the
+// filesystem holding path.
+func (fso *FileSystemOperator) FreeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -1,4 +1,4 @@
-// Code generated by mockery v2.23.4. DO NOT EDIT.
+// Code generated by mockery v2.53.7. DO NOT EDIT.
 
 package mocks
 
@@ -23,10 +23,60 @@ func (_m *IFileSystemOperator) EXPECT() *IFileSystemOperator_Expecter {
 	return &IFileSystemOperator_Expecter{mock: &_m.Mock}
 }
 
+// CreateDirectory provides a mock function with given fields: folderPath
+func (_m *IFileSystemOperator) CreateDirectory(folderPath string) error {
+	ret := _m.Called(folderPath)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateDirectory")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(folderPath)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IFileSystemOperator_CreateDirectory_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CreateDirectory'
+type IFileSystemOperator_CreateDirectory_Call struct {
+	*mock.Call
+}
+
+// CreateDirectory is a helper method to define mock.On call
+//   - folderPath string
+func (_e *IFileSystemOperator_Expecter) CreateDirectory(folderPath interface{}) *IFileSystemOperator_CreateDirectory_Call {
+	return &IFileSystemOperator_CreateDirectory_Call{Call: _e.mock.On("CreateDirectory", folderPath)}
+}
+
+func (_c *IFileSystemOperator_CreateDirectory_Call) Run(run func(folderPath string)) *IFileSystemOperator_CreateDirectory_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *IFileSystemOperator_CreateDirectory_Call) Return(_a0 error) *IFileSystemOperator_CreateDirectory_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *IFileSystemOperator_CreateDirectory_Call) RunAndReturn(run func(string) error) *IFileSystemOperator_CreateDirectory_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // CreateFile provides a mock function with given fields: zipFileFullPath
 func (_m *IFileSystemOperator) CreateFile(zipFileFullPath string) (fileutil.IArchiveFile, error) {
 	ret := _m.Called(zipFileFullPath)
 
+	if len(ret) == 0 {
+		panic("no return value specified for CreateFile")
+	}
+
 	var r0 fileutil.IArchiveFile
 	var r1 error
 	if rf, ok := ret.Get(0).(func(string) (fileutil.IArchiveFile, error)); ok {
@@ -81,6 +131,10 @@ func (_c *IFileSystemOperator_CreateFile_Call) RunAndReturn(run func(string) (fi
 func (_m *IFileSystemOperator) DeleteFile(filePath string) error {
 	ret := _m.Called(filePath)
 
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteFile")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(string) error); ok {
 		r0 = rf(filePath)
@@ -119,10 +173,126 @@ func (_c *IFileSystemOperator_DeleteFile_Call) RunAndReturn(run func(string) err
 	return _c
 }
 
+// FileCRC32C provides a mock function with given fields: filePath
+func (_m *IFileSystemOperator) FileCRC32C(filePath string) (string, error) {
+	ret := _m.Called(filePath)
+
+	if len(ret) == 0 {
+		panic("no return value specified for FileCRC32C")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (string, error)); ok {
+		return rf(filePath)
+	}
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(filePath)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(filePath)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IFileSystemOperator_FileCRC32C_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'FileCRC32C'
+type IFileSystemOperator_FileCRC32C_Call struct {
+	*mock.Call
+}
+
+// FileCRC32C is a helper method to define mock.On call
+//   - filePath string
+func (_e *IFileSystemOperator_Expecter) FileCRC32C(filePath interface{}) *IFileSystemOperator_FileCRC32C_Call {
+	return &IFileSystemOperator_FileCRC32C_Call{Call: _e.mock.On("FileCRC32C", filePath)}
+}
+
+func (_c *IFileSystemOperator_FileCRC32C_Call) Run(run func(filePath string)) *IFileSystemOperator_FileCRC32C_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *IFileSystemOperator_FileCRC32C_Call) Return(_a0 string, _a1 error) *IFileSystemOperator_FileCRC32C_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *IFileSystemOperator_FileCRC32C_Call) RunAndReturn(run func(string) (string, error)) *IFileSystemOperator_FileCRC32C_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// FileChecksum provides a mock function with given fields: filePath
+func (_m *IFileSystemOperator) FileChecksum(filePath string) (string, error) {
+	ret := _m.Called(filePath)
+
+	if len(ret) == 0 {
+		panic("no return value specified for FileChecksum")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (string, error)); ok {
+		return rf(filePath)
+	}
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(filePath)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(filePath)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IFileSystemOperator_FileChecksum_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'FileChecksum'
+type IFileSystemOperator_FileChecksum_Call struct {
+	*mock.Call
+}
+
+// FileChecksum is a helper method to define mock.On call
+//   - filePath string
+func (_e *IFileSystemOperator_Expecter) FileChecksum(filePath interface{}) *IFileSystemOperator_FileChecksum_Call {
+	return &IFileSystemOperator_FileChecksum_Call{Call: _e.mock.On("FileChecksum", filePath)}
+}
+
+func (_c *IFileSystemOperator_FileChecksum_Call) Run(run func(filePath string)) *IFileSystemOperator_FileChecksum_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *IFileSystemOperator_FileChecksum_Call) Return(_a0 string, _a1 error) *IFileSystemOperator_FileChecksum_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *IFileSystemOperator_FileChecksum_Call) RunAndReturn(run func(string) (string, error)) *IFileSystemOperator_FileChecksum_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // FileNamesFromPath provides a mock function with given fields: folderPath
 func (_m *IFileSystemOperator) FileNamesFromPath(folderPath string) ([]string, error) {
 	ret := _m.Called(folderPath)
 
+	if len(ret) == 0 {
+		panic("no return value specified for FileNamesFromPath")
+	}
+
 	var r0 []string
 	var r1 error
 	if rf, ok := ret.Get(0).(func(string) ([]string, error)); ok {
@@ -173,10 +343,70 @@ func (_c *IFileSystemOperator_FileNamesFromPath_Call) RunAndReturn(run func(stri
 	return _c
 }
 
+// FreeSpace provides a mock function with given fields: path
+func (_m *IFileSystemOperator) FreeSpace(path string) (uint64, error) {
+	ret := _m.Called(path)
+
+	if len(ret) == 0 {
+		panic("no return value specified for FreeSpace")
+	}
+
+	var r0 uint64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (uint64, error)); ok {
+		return rf(path)
+	}
+	if rf, ok := ret.Get(0).(func(string) uint64); ok {
+		r0 = rf(path)
+	} else {
+		r0 = ret.Get(0).(uint64)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(path)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IFileSystemOperator_FreeSpace_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'FreeSpace'
+type IFileSystemOperator_FreeSpace_Call struct {
+	*mock.Call
+}
+
+// FreeSpace is a helper method to define mock.On call
+//   - path string
+func (_e *IFileSystemOperator_Expecter) FreeSpace(path interface{}) *IFileSystemOperator_FreeSpace_Call {
+	return &IFileSystemOperator_FreeSpace_Call{Call: _e.mock.On("FreeSpace", path)}
+}
+
+func (_c *IFileSystemOperator_FreeSpace_Call) Run(run func(path string)) *IFileSystemOperator_FreeSpace_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *IFileSystemOperator_FreeSpace_Call) Return(_a0 uint64, _a1 error) *IFileSystemOperator_FreeSpace_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *IFileSystemOperator_FreeSpace_Call) RunAndReturn(run func(string) (uint64, error)) *IFileSystemOperator_FreeSpace_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // GetInfo provides a mock function with given fields: path
 func (_m *IFileSystemOperator) GetInfo(path string) (fs.FileInfo, error) {
 	ret := _m.Called(path)
 
+	if len(ret) == 0 {
+		panic("no return value specified for GetInfo")
+	}
+
 	var r0 fs.FileInfo
 	var r1 error
 	if rf, ok := ret.Get(0).(func(string) (fs.FileInfo, error)); ok {
@@ -231,6 +461,10 @@ func (_c *IFileSystemOperator_GetInfo_Call) RunAndReturn(run func(string) (fs.Fi
 func (_m *IFileSystemOperator) OpenFile(filePath string) (fileutil.IArchiveFile, error) {
 	ret := _m.Called(filePath)
 
+	if len(ret) == 0 {
+		panic("no return value specified for OpenFile")
+	}
+
 	var r0 fileutil.IArchiveFile
 	var r1 error
 	if rf, ok := ret.Get(0).(func(string) (fileutil.IArchiveFile, error)); ok {
@@ -285,6 +519,10 @@ func (_c *IFileSystemOperator_OpenFile_Call) RunAndReturn(run func(string) (file
 func (_m *IFileSystemOperator) ReadFile(filePath string) ([]byte, error) {
 	ret := _m.Called(filePath)
 
+	if len(ret) == 0 {
+		panic("no return value specified for ReadFile")
+	}
+
 	var r0 []byte
 	var r1 error
 	if rf, ok := ret.Get(0).(func(string) ([]byte, error)); ok {
@@ -335,10 +573,61 @@ func (_c *IFileSystemOperator_ReadFile_Call) RunAndReturn(run func(string) ([]by
 	return _c
 }
 
+// RenameFile provides a mock function with given fields: oldPath, newPath
+func (_m *IFileSystemOperator) RenameFile(oldPath string, newPath string) error {
+	ret := _m.Called(oldPath, newPath)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RenameFile")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(oldPath, newPath)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IFileSystemOperator_RenameFile_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RenameFile'
+type IFileSystemOperator_RenameFile_Call struct {
+	*mock.Call
+}
+
+// RenameFile is a helper method to define mock.On call
+//   - oldPath string
+//   - newPath string
+func (_e *IFileSystemOperator_Expecter) RenameFile(oldPath interface{}, newPath interface{}) *IFileSystemOperator_RenameFile_Call {
+	return &IFileSystemOperator_RenameFile_Call{Call: _e.mock.On("RenameFile", oldPath, newPath)}
+}
+
+func (_c *IFileSystemOperator_RenameFile_Call) Run(run func(oldPath string, newPath string)) *IFileSystemOperator_RenameFile_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *IFileSystemOperator_RenameFile_Call) Return(_a0 error) *IFileSystemOperator_RenameFile_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *IFileSystemOperator_RenameFile_Call) RunAndReturn(run func(string, string) error) *IFileSystemOperator_RenameFile_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // WriteNewFile provides a mock function with given fields: filePath, data
 func (_m *IFileSystemOperator) WriteNewFile(filePath string, data []byte) error {
 	ret := _m.Called(filePath, data)
 
+	if len(ret) == 0 {
+		panic("no return value specified for WriteNewFile")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(string, []byte) error); ok {
 		r0 = rf(filePath, data)
@@ -4,14 +4,24 @@ import (
 	"fmt"
 	"maps"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/apkatsikas/archiver/db"
 )
 
 type FilterService struct {
+	// DetectAlbumRoots treats disc folders such as CD1 or Disc 2 as part of
+	// their parent folder, so a multi-disc release is archived as one unit.
+	DetectAlbumRoots bool
+	// ArchiveExtension is the extension of the archive format in use,
+	// including the dot - the default is .zip.
+	ArchiveExtension string
 }
 
+var discFolderPattern = regexp.MustCompile(`(?i)^(cd|disc|disk)[\s._-]*\d+\b`)
+
 type UploadType int
 
 const (
@@ -23,6 +33,11 @@ type PathIdentifier struct {
 	UploadType
 	Id       string
 	BasePath string
+	// FolderPath, NewestMediaAt, MediaFileIds and Fingerprint are not part of the ledger
+	FolderPath    string    `json:"-"`
+	NewestMediaAt time.Time `json:"-"`
+	MediaFileIds  []string  `json:"-"`
+	Fingerprint   string    `json:"-"`
 }
 
 type IdentifiedPaths map[string]PathIdentifier
@@ -31,20 +46,19 @@ func (fs *FilterService) IdentifiedPaths(mediaFiles []db.MediaFile, uploadType U
 	identifiedPaths := make(IdentifiedPaths)
 	for _, mediaFile := range mediaFiles {
 
-		pathDirectory := filepath.Dir(mediaFile.Path)
+		pathDirectory := fs.folder(mediaFile)
 		basePath := filepath.Base(pathDirectory)
 
-		if _, pathExists := identifiedPaths[pathDirectory]; pathExists {
-			if fs.isIdLowerThanExistingId(mediaFile.Id, identifiedPaths[pathDirectory].Id) {
-				identifiedPaths[pathDirectory] = PathIdentifier{
-					Id:         mediaFile.Id,
-					UploadType: uploadType,
-					BasePath:   basePath,
-				}
-			}
-		} else {
-			identifiedPaths[pathDirectory] = PathIdentifier{Id: mediaFile.Id, UploadType: uploadType, BasePath: basePath}
+		pathIdentifier, pathExists := identifiedPaths[pathDirectory]
+		if !pathExists || fs.isIdLowerThanExistingId(mediaFile.Id, pathIdentifier.Id) {
+			pathIdentifier.Id = mediaFile.Id
+			pathIdentifier.UploadType = uploadType
+			pathIdentifier.BasePath = basePath
 		}
+		pathIdentifier.NewestMediaAt = newestTime(
+			pathIdentifier.NewestMediaAt, mediaFile.CreatedAt, mediaFile.UpdatedAt)
+
+		identifiedPaths[pathDirectory] = pathIdentifier
 	}
 
 	return identifiedPaths
@@ -62,7 +76,30 @@ func (fs *FilterService) UpdatedAndNewIdentifiedPaths(newMediaFiles []db.MediaFi
 }
 
 func (fs *FilterService) UploadDestination(pathIdentifider PathIdentifier) string {
-	return fmt.Sprintf("%v%v.zip", pathIdentifider.BasePath, pathIdentifider.Id)
+	extension := fs.ArchiveExtension
+	if extension == "" {
+		extension = ".zip"
+	}
+	return fmt.Sprintf("%v%v%v", pathIdentifider.BasePath, pathIdentifider.Id, extension)
+}
+
+// MediaFileIdsByFolder groups the IDs of the media files by the folder they
+// are archived in.
+func (fs *FilterService) MediaFileIdsByFolder(mediaFiles []db.MediaFile) map[string][]string {
+	mediaFileIds := make(map[string][]string)
+	for _, mediaFile := range mediaFiles {
+		pathDirectory := fs.folder(mediaFile)
+		mediaFileIds[pathDirectory] = append(mediaFileIds[pathDirectory], mediaFile.Id)
+	}
+	return mediaFileIds
+}
+
+func (fs *FilterService) folder(mediaFile db.MediaFile) string {
+	pathDirectory := filepath.Dir(mediaFile.Path)
+	if fs.DetectAlbumRoots {
+		pathDirectory = albumRoot(pathDirectory)
+	}
+	return pathDirectory
 }
 
 // If a path is considered both new AND updated, we can just consider it new
@@ -76,6 +113,24 @@ func (fs *FilterService) removeDupesFromUpdatedFiles(updatedIdentifiedPaths Iden
 	maps.Copy(newIdentifiedPaths, updatedIdentifiedPaths)
 }
 
+func newestTime(times ...time.Time) time.Time {
+	var newest time.Time
+	for _, t := range times {
+		if t.After(newest) {
+			newest = t
+		}
+	}
+	return newest
+}
+
 func (fs *FilterService) isIdLowerThanExistingId(id string, existingId string) bool {
 	return strings.Compare(id, existingId) < 0
 }
+
+// albumRoot returns the parent of a disc folder, or the folder itself.
+func albumRoot(pathDirectory string) string {
+	if discFolderPattern.MatchString(filepath.Base(pathDirectory)) {
+		return filepath.Dir(pathDirectory)
+	}
+	return pathDirectory
+}
@@ -1,6 +1,8 @@
 package filter_test
 
 import (
+	"time"
+
 	"github.com/apkatsikas/archiver/db"
 	"github.com/apkatsikas/archiver/filter"
 	. "github.com/onsi/ginkgo/v2"
@@ -43,6 +45,53 @@ var _ = Describe("IdentifiedPaths with duplicate paths", func() {
 	})
 })
 
+var _ = Describe("IdentifiedPaths with media file timestamps", func() {
+	var older = time.Date(2024, time.January, 12, 13, 19, 51, 0, time.UTC)
+	var newer = older.Add(time.Hour)
+	var newest = older.Add(2 * time.Hour)
+
+	var fs *filter.FilterService
+	var identifiedPaths filter.IdentifiedPaths
+
+	BeforeEach(func() {
+		fs = &filter.FilterService{}
+		identifiedPaths = fs.IdentifiedPaths([]db.MediaFile{
+			{
+				Id:        "abde123",
+				Path:      "/path/to/stuff/01 track.mp3",
+				CreatedAt: older,
+				UpdatedAt: newest,
+			},
+			{
+				Id:        "1abde123",
+				Path:      "/path/to/stuff/02 track.mp3",
+				CreatedAt: newer,
+			},
+		}, filter.UpdatedMedia)
+	})
+
+	It("will record the newest created or updated time in the folder", func() {
+		Expect(identifiedPaths).To(Equal(filter.IdentifiedPaths{
+			"/path/to/stuff": filter.PathIdentifier{
+				Id: "1abde123", UploadType: filter.UpdatedMedia, BasePath: "stuff", NewestMediaAt: newest},
+		}))
+	})
+})
+
+var _ = Describe("MediaFileIdsByFolder", func() {
+	It("will group every media file id by the folder it is archived in", func() {
+		fs := &filter.FilterService{DetectAlbumRoots: true}
+		Expect(fs.MediaFileIdsByFolder([]db.MediaFile{
+			{Id: "a123", Path: "/path/to/album/CD1/01 track.mp3"},
+			{Id: "b123", Path: "/path/to/album/CD2/01 track.mp3"},
+			{Id: "c123", Path: "/path/to/stuff/01 track.mp3"},
+		})).To(Equal(map[string][]string{
+			"/path/to/album": {"a123", "b123"},
+			"/path/to/stuff": {"c123"},
+		}))
+	})
+})
+
 var _ = Describe("IdentifiedPaths with nil input", func() {
 	var fs *filter.FilterService
 
@@ -138,4 +187,43 @@ var _ = Describe("UploadDestination", func() {
 	It("Will return the expected destination", func() {
 		Expect(fs.UploadDestination(pathIdentifier)).To(Equal(expectedDestination))
 	})
+
+	It("Will use the extension of the archive format", func() {
+		fs.ArchiveExtension = ".tar.zst"
+		Expect(fs.UploadDestination(pathIdentifier)).To(Equal("testpathabcd1234.tar.zst"))
+	})
+})
+
+var _ = Describe("IdentifiedPaths when detecting album roots", func() {
+	var fs *filter.FilterService
+	var identifiedPaths filter.IdentifiedPaths
+
+	BeforeEach(func() {
+		fs = &filter.FilterService{DetectAlbumRoots: true}
+		identifiedPaths = fs.IdentifiedPaths([]db.MediaFile{
+			{
+				Id:   "b123",
+				Path: "/path/to/album/CD1/01 track.mp3",
+			},
+			{
+				Id:   "a123",
+				Path: "/path/to/album/Disc 2 - Bonus/01 track.mp3",
+			},
+			{
+				Id:   "c123",
+				Path: "/path/to/album/disk_3/01 track.mp3",
+			},
+			{
+				Id:   "d123",
+				Path: "/path/to/discography/01 track.mp3",
+			},
+		}, filter.NewMedia)
+	})
+
+	It("will consolidate disc folders into their parent album folder", func() {
+		Expect(identifiedPaths).To(Equal(filter.IdentifiedPaths{
+			"/path/to/album":       filter.PathIdentifier{Id: "a123", UploadType: filter.NewMedia, BasePath: "album"},
+			"/path/to/discography": filter.PathIdentifier{Id: "d123", UploadType: filter.NewMedia, BasePath: "discography"},
+		}))
+	})
 })
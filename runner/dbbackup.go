@@ -0,0 +1,376 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	"github.com/apkatsikas/archiver/filter"
+	storageclient "github.com/apkatsikas/archiver/storage-client"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// navidromeBackupObject is the zstd compressed backup, which replaced
+	// the uncompressed navidromeBackupDB object.
+	navidromeBackupObject  = navidromeBackupDB + zstdExtension
+	zstdExtension          = ".zst"
+	dbBackupsPrefix        = "db-backups/"
+	dbSnapshotPrefix       = dbBackupsPrefix + "navidrome-backup-"
+	dbSnapshotExtension    = ".sqlite"
+	dbSnapshotFormat       = "20060102T150405Z"
+	latestDbSnapshotObject = dbBackupsPrefix + "latest"
+	// schemaVersionMetadata is the object metadata holding the Navidrome
+	// schema version a backup was taken at.
+	schemaVersionMetadata = "navidrome-schema-version"
+)
+
+// DbBackupRetention is how many snapshots of the Navidrome DB are kept in
+// each period, grandfather-father-son style. The newest snapshot in each of
+// the most recent Daily days, Weekly ISO weeks and Monthly months is kept.
+type DbBackupRetention struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Enabled is false when no snapshots are kept, and the backup is overwritten
+// instead.
+func (dbr DbBackupRetention) Enabled() bool {
+	return dbr.Daily > 0 || dbr.Weekly > 0 || dbr.Monthly > 0
+}
+
+type dbSnapshot struct {
+	object  string
+	takenAt time.Time
+}
+
+// backupNavidromeDb vacuums the Navidrome DB into a backup, checks it and
+// uploads it compressed - as a dated snapshot when DbBackupRetention is
+// enabled, or over the single backup object otherwise. With a
+// DbBackupRepository, a backup identical to the last one uploaded is not
+// uploaded again.
+func (r *Runner) backupNavidromeDb() error {
+	if err := r.FileSystemOperator.DeleteFile(navidromeBackupDB); err != nil {
+		log.Printf("failed to delete navidrome backup DB: %v", err)
+	}
+	if err := r.AdminRepository.CreateBackup(navidromeBackupDB); err != nil {
+		return fmt.Errorf("failed to vacuum: %v", err)
+	}
+
+	// A corrupt backup must not replace a good one in storage.
+	schemaVersion, err := checkDbBackup(navidromeBackupDB)
+	if err != nil {
+		return fmt.Errorf("refusing to upload navidrome backup DB: %v", err)
+	}
+
+	var checksum string
+	if r.DbBackupRepository != nil {
+		if checksum, err = r.FileSystemOperator.FileChecksum(navidromeBackupDB); err != nil {
+			return fmt.Errorf("failed to checksum navidrome backup DB: %v", err)
+		}
+		lastBackup, err := r.DbBackupRepository.LastDbBackup()
+		if err != nil {
+			return fmt.Errorf("failed to get last DB backup: %v", err)
+		}
+		if lastBackup != nil && lastBackup.Checksum == checksum {
+			log.Printf("Navidrome DB has not changed since %v was uploaded", lastBackup.Object)
+			return nil
+		}
+	}
+
+	if err := r.compressFile(navidromeBackupDB, navidromeBackupObject); err != nil {
+		return fmt.Errorf("failed to compress navidrome backup DB: %v", err)
+	}
+	defer func() {
+		if err := r.FileSystemOperator.DeleteFile(navidromeBackupObject); err != nil {
+			log.Printf("failed to delete %v: %v", navidromeBackupObject, err)
+		}
+	}()
+
+	object, err := r.uploadNavidromeDb(navidromeBackupObject, schemaVersion)
+	if err != nil {
+		return err
+	}
+
+	// A backup that missed a destination is not recorded, so the next run
+	// uploads it again.
+	if r.DbBackupRepository != nil && len(r.missedDestinations()) == 0 {
+		info, err := r.FileSystemOperator.GetInfo(navidromeBackupObject)
+		if err != nil {
+			return err
+		}
+		err = r.DbBackupRepository.SaveDbBackup(db.DbBackup{
+			Object:     object,
+			Size:       info.Size(),
+			Checksum:   checksum,
+			UploadedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record DB backup %v: %v", object, err)
+		}
+	}
+	if r.DbBackupRetention.Enabled() {
+		return r.pruneDbSnapshots()
+	}
+	return nil
+}
+
+// checkDbBackup runs integrity_check and quick_check on the backup, and
+// returns the Navidrome schema version it is at.
+func checkDbBackup(dbPath string) (string, error) {
+	sqliteHandler := &db.SQLiteHandler{}
+	if err := sqliteHandler.ConnectSQLite(dbPath); err != nil {
+		return "", err
+	}
+	defer sqliteHandler.Close()
+
+	adminRepository := &db.AdminRepository{SqliteHandler: sqliteHandler}
+	if err := adminRepository.IntegrityCheck(); err != nil {
+		return "", err
+	}
+	if err := adminRepository.QuickCheck(); err != nil {
+		return "", err
+	}
+	return adminRepository.SchemaVersion()
+}
+
+// uploadNavidromeDb uploads the compressed backup and returns the object it
+// was stored as.
+func (r *Runner) uploadNavidromeDb(backupPath string, schemaVersion string) (string, error) {
+	object := navidromeBackupObject
+	if r.DbBackupRetention.Enabled() {
+		object = dbSnapshotName(time.Now().UTC())
+		log.Printf("Uploading navidrome backup DB as %v", object)
+		if err := r.StorageClient.UploadNewFile(backupPath, object); err != nil {
+			return "", fmt.Errorf("failed to send %v to storage: %v", object, err)
+		}
+	} else if err := r.storeFile(backupPath, object, filter.UpdatedMedia); err != nil {
+		return "", fmt.Errorf("failed to send navidrome backup DB to storage: %v", err)
+	}
+
+	if schemaVersion != "" {
+		metadata := map[string]string{schemaVersionMetadata: schemaVersion}
+		if err := r.StorageClient.SetMetadata(object, metadata); err != nil {
+			return "", err
+		}
+	}
+	if !r.DbBackupRetention.Enabled() {
+		return object, nil
+	}
+
+	// The pointer is only moved once the snapshot it names is stored.
+	write := func(w io.Writer) error {
+		_, err := io.WriteString(w, object)
+		return err
+	}
+	if err := r.storeStream(write, latestDbSnapshotObject, filter.UpdatedMedia); err != nil {
+		return "", fmt.Errorf("failed to send %v to storage: %v", latestDbSnapshotObject, err)
+	}
+	return object, nil
+}
+
+func dbSnapshotName(takenAt time.Time) string {
+	return dbSnapshotPrefix + takenAt.Format(dbSnapshotFormat) + dbSnapshotExtension + zstdExtension
+}
+
+// pruneDbSnapshots deletes the snapshots DbBackupRetention does not keep,
+// compressed or not. Objects under the snapshot prefix that are not named like
+// a snapshot are left alone.
+func (r *Runner) pruneDbSnapshots() error {
+	backupFiles, err := r.StorageClient.ListFiles(dbSnapshotPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list %v: %v", dbSnapshotPrefix, err)
+	}
+
+	var snapshots []dbSnapshot
+	for _, backupFile := range backupFiles {
+		taken, found := strings.CutPrefix(backupFile.Name, dbSnapshotPrefix)
+		taken = strings.TrimSuffix(taken, zstdExtension)
+		taken, hasExtension := strings.CutSuffix(taken, dbSnapshotExtension)
+		takenAt, err := time.Parse(dbSnapshotFormat, taken)
+		if !found || !hasExtension || err != nil {
+			continue
+		}
+		snapshots = append(snapshots, dbSnapshot{object: backupFile.Name, takenAt: takenAt})
+	}
+
+	for _, object := range r.DbBackupRetention.expired(snapshots) {
+		log.Printf("Pruning %v", object)
+		if err := r.StorageClient.DeleteFile(object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expired returns the snapshots that are not the newest in any of the
+// periods kept. The newest snapshot is always kept, as the latest pointer
+// names it.
+func (dbr DbBackupRetention) expired(snapshots []dbSnapshot) []string {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	snapshots = slices.Clone(snapshots)
+	slices.SortFunc(snapshots, func(a, b dbSnapshot) int {
+		return b.takenAt.Compare(a.takenAt)
+	})
+
+	periods := []struct {
+		count int
+		key   func(time.Time) string
+	}{
+		{dbr.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{dbr.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{dbr.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	kept := map[string]bool{snapshots[0].object: true}
+	for _, period := range periods {
+		seen := make(map[string]bool)
+		for _, snapshot := range snapshots {
+			key := period.key(snapshot.takenAt)
+			if seen[key] {
+				continue
+			}
+			if len(seen) >= period.count {
+				break
+			}
+			seen[key] = true
+			kept[snapshot.object] = true
+		}
+	}
+
+	var expired []string
+	for _, snapshot := range snapshots {
+		if !kept[snapshot.object] {
+			expired = append(expired, snapshot.object)
+		}
+	}
+	return expired
+}
+
+// dbBackupToRestore is the snapshot named by the latest pointer, or the single
+// backup object when no snapshots have been taken - compressed unless only an
+// uncompressed backup from an earlier release is stored.
+func (r *Runner) dbBackupToRestore(destination string) (string, error) {
+	pointers, err := r.StorageClient.ListFiles(latestDbSnapshotObject)
+	if err != nil {
+		return "", fmt.Errorf("failed to list %v: %v", latestDbSnapshotObject, err)
+	}
+	if !slices.ContainsFunc(pointers, isObject(latestDbSnapshotObject)) {
+		backupFiles, err := r.StorageClient.ListFiles(navidromeBackupDB)
+		if err != nil {
+			return "", fmt.Errorf("failed to list %v: %v", navidromeBackupDB, err)
+		}
+		if slices.ContainsFunc(backupFiles, isObject(navidromeBackupObject)) {
+			return navidromeBackupObject, nil
+		}
+		return navidromeBackupDB, nil
+	}
+
+	downloadPath := destination + restoreDbDownloadSuffix + "-latest"
+	if err := r.StorageClient.DownloadFile(latestDbSnapshotObject, downloadPath); err != nil {
+		return "", fmt.Errorf("failed to download %v: %v", latestDbSnapshotObject, err)
+	}
+	defer func() {
+		if err := r.FileSystemOperator.DeleteFile(downloadPath); err != nil {
+			log.Printf("failed to delete %v: %v", downloadPath, err)
+		}
+	}()
+	data, err := r.FileSystemOperator.ReadFile(downloadPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func isObject(name string) func(storageclient.BackupFile) bool {
+	return func(backupFile storageclient.BackupFile) bool {
+		return backupFile.Name == name
+	}
+}
+
+// downloadDbBackup downloads the backup to downloadPath, decompressing it if
+// it is compressed.
+func (r *Runner) downloadDbBackup(backup string, downloadPath string) error {
+	if !strings.HasSuffix(backup, zstdExtension) {
+		if err := r.StorageClient.DownloadFile(backup, downloadPath); err != nil {
+			return fmt.Errorf("failed to download %v: %v", backup, err)
+		}
+		return nil
+	}
+
+	compressedPath := downloadPath + zstdExtension
+	if err := r.StorageClient.DownloadFile(backup, compressedPath); err != nil {
+		return fmt.Errorf("failed to download %v: %v", backup, err)
+	}
+	defer func() {
+		if err := r.FileSystemOperator.DeleteFile(compressedPath); err != nil {
+			log.Printf("failed to delete %v: %v", compressedPath, err)
+		}
+	}()
+	if err := r.decompressFile(compressedPath, downloadPath); err != nil {
+		return fmt.Errorf("failed to decompress %v: %v", backup, err)
+	}
+	return nil
+}
+
+func (r *Runner) compressFile(srcPath string, destPath string) error {
+	src, err := r.FileSystemOperator.OpenFile(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dest, err := r.FileSystemOperator.CreateFile(destPath)
+	if err != nil {
+		return err
+	}
+
+	encoder, err := zstd.NewWriter(dest, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		dest.Close()
+		return err
+	}
+	if _, err := io.Copy(encoder, src); err != nil {
+		encoder.Close()
+		dest.Close()
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		dest.Close()
+		return err
+	}
+	return dest.Close()
+}
+
+func (r *Runner) decompressFile(srcPath string, destPath string) error {
+	src, err := r.FileSystemOperator.OpenFile(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	decoder, err := zstd.NewReader(src)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	dest, err := r.FileSystemOperator.CreateFile(destPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dest, decoder); err != nil {
+		dest.Close()
+		return err
+	}
+	return dest.Close()
+}
@@ -0,0 +1,187 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	"github.com/apkatsikas/archiver/zipper"
+)
+
+// DeletedMediaPolicy is what a scheduled run does with the archives of folders
+// that are gone from the library.
+type DeletedMediaPolicy string
+
+const (
+	// DeletedMediaIgnore leaves deleted folders alone - the default.
+	DeletedMediaIgnore DeletedMediaPolicy = "ignore"
+	// DeletedMediaTombstone records deleted folders in the archive DB, but
+	// leaves their objects in place.
+	DeletedMediaTombstone DeletedMediaPolicy = "tombstone"
+	// DeletedMediaMove moves the objects of deleted folders under deletedPrefix.
+	DeletedMediaMove DeletedMediaPolicy = "move"
+	// DeletedMediaDelete deletes the objects of deleted folders from storage,
+	// once they have been gone for DeleteGracePeriod.
+	DeletedMediaDelete DeletedMediaPolicy = "delete"
+)
+
+var DeletedMediaPolicies = []DeletedMediaPolicy{
+	DeletedMediaIgnore, DeletedMediaTombstone, DeletedMediaMove, DeletedMediaDelete}
+
+const deletedPrefix = "deleted/"
+
+// handleDeletedFolders tombstones the archived folders that no longer have any
+// media in the library, then moves or deletes their objects as the policy says.
+// A folder that comes back loses its tombstone.
+func (r *Runner) handleDeletedFolders() error {
+	if r.DeletedMediaPolicy == "" || r.DeletedMediaPolicy == DeletedMediaIgnore {
+		return nil
+	}
+	if err := r.TombstoneRepository.CreateTable(); err != nil {
+		return fmt.Errorf("failed to CreateTable tombstone: %v", err)
+	}
+
+	mediaFiles, err := r.MusicFoldersRepository.PresentMediaFiles()
+	if err != nil {
+		return fmt.Errorf("failed to get present media files: %v", err)
+	}
+	// An empty library is more likely a broken mount or scan than every
+	// album being deleted.
+	if len(mediaFiles) == 0 {
+		log.Printf("WARNING: No media files in the library, not looking for deleted folders")
+		return nil
+	}
+	absoluteMediaFiles, err := r.absoluteMediaFiles(mediaFiles)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute media files: %v", err)
+	}
+	presentFolders := r.FilterService.MediaFileIdsByFolder(absoluteMediaFiles)
+
+	tombstones, err := r.TombstoneRepository.AllTombstones()
+	if err != nil {
+		return fmt.Errorf("failed to get tombstones: %v", err)
+	}
+	tombstoned := make(map[string]bool)
+	for _, tombstone := range tombstones {
+		if _, present := presentFolders[tombstone.Path]; present {
+			log.Printf("%v is back in the library, removing its tombstone", tombstone.Path)
+			if err := r.TombstoneRepository.DeleteTombstone(tombstone.Path); err != nil {
+				return fmt.Errorf("failed to delete tombstone of %v: %v", tombstone.Path, err)
+			}
+			continue
+		}
+		tombstoned[tombstone.Path] = true
+	}
+
+	archivedFolders, err := r.ArchivedFolderRepository.AllArchivedFolders()
+	if err != nil {
+		return fmt.Errorf("failed to get archived folders: %v", err)
+	}
+	for _, archivedFolder := range archivedFolders {
+		if _, present := presentFolders[archivedFolder.Path]; present || tombstoned[archivedFolder.Path] {
+			continue
+		}
+		log.Printf("%v is no longer in the library, recording a tombstone", archivedFolder.Path)
+		err := r.TombstoneRepository.SaveTombstone(db.Tombstone{
+			Path:        archivedFolder.Path,
+			Destination: archivedFolder.Destination,
+			DeletedAt:   time.Now().UTC(),
+			State:       db.TombstoneRecorded,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record tombstone of %v: %v", archivedFolder.Path, err)
+		}
+	}
+
+	tombstones, err = r.TombstoneRepository.AllTombstones()
+	if err != nil {
+		return fmt.Errorf("failed to get tombstones: %v", err)
+	}
+	for _, tombstone := range tombstones {
+		if err := r.applyDeletedMediaPolicy(tombstone); err != nil {
+			return fmt.Errorf("failed to handle deleted folder %v: %v", tombstone.Path, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) applyDeletedMediaPolicy(tombstone db.Tombstone) error {
+	switch {
+	case r.DeletedMediaPolicy == DeletedMediaMove && tombstone.State == db.TombstoneRecorded:
+		objects, err := r.destinationObjects(tombstone.Destination, "")
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			log.Printf("Moving %v to %v", object, deletedPrefix+object)
+			if err := r.StorageClient.MoveFile(object, deletedPrefix+object); err != nil {
+				return err
+			}
+		}
+		tombstone.State = db.TombstoneMoved
+
+	case r.DeletedMediaPolicy == DeletedMediaDelete && tombstone.State != db.TombstonePurged &&
+		time.Since(tombstone.DeletedAt) >= r.DeleteGracePeriod:
+		// The objects may have been moved by an earlier run with the move policy.
+		objects, err := r.destinationObjects(tombstone.Destination, "")
+		if err != nil {
+			return err
+		}
+		movedObjects, err := r.destinationObjects(tombstone.Destination, deletedPrefix)
+		if err != nil {
+			return err
+		}
+		for _, object := range append(objects, movedObjects...) {
+			log.Printf("Deleting %v", object)
+			if err := r.StorageClient.DeleteFile(object); err != nil {
+				return err
+			}
+		}
+		tombstone.State = db.TombstonePurged
+
+	default:
+		return nil
+	}
+
+	if err := r.TombstoneRepository.SaveTombstone(tombstone); err != nil {
+		return err
+	}
+	// With its objects gone from where they were, the folder is archived
+	// from scratch if it comes back.
+	return r.ArchivedFolderRepository.DeleteArchivedFolder(tombstone.Path)
+}
+
+// forgetTombstone deletes the tombstone of a folder, if deleted folders are
+// being tracked.
+func (r *Runner) forgetTombstone(folderPath string) error {
+	if r.TombstoneRepository == nil || r.DeletedMediaPolicy == "" || r.DeletedMediaPolicy == DeletedMediaIgnore {
+		return nil
+	}
+	if err := r.TombstoneRepository.CreateTable(); err != nil {
+		return err
+	}
+	return r.TombstoneRepository.DeleteTombstone(folderPath)
+}
+
+// destinationObjects lists the objects under prefix that make up destination -
+// the archive itself or, for a split folder, its parts and parts manifest.
+func (r *Runner) destinationObjects(destination string, prefix string) ([]string, error) {
+	manifestName := partsManifestName(destination)
+	stem := strings.TrimSuffix(manifestName, partsManifestExtension)
+	backupFiles, err := r.StorageClient.ListFiles(prefix + stem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %v: %v", prefix+stem, err)
+	}
+
+	var objects []string
+	for _, backupFile := range backupFiles {
+		name := strings.TrimPrefix(backupFile.Name, prefix)
+		wholeName, _, isPart := zipper.ParsePartName(name)
+		if name == destination || name == manifestName || (isPart && wholeName == destination) {
+			objects = append(objects, backupFile.Name)
+		}
+	}
+	return objects, nil
+}
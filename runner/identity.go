@@ -0,0 +1,216 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	"github.com/apkatsikas/archiver/filter"
+	storageclient "github.com/apkatsikas/archiver/storage-client"
+	"github.com/apkatsikas/archiver/zipper"
+)
+
+// Navidrome media file IDs are 32 character hex MD5 hashes in older versions
+// and 22 character alphanumeric strings in newer ones. Matching their exact
+// length stops a folder from claiming the archive of another folder whose name
+// starts with its own, such as Album claiming Album2<id>.zip.
+var folderIdPattern = regexp.MustCompile(`^(?:[0-9a-f]{32}|[0-9A-Za-z]{22})$`)
+
+// applyFolderIdentity swaps the ID of pathId for the stable identity the folder
+// was archived under before, so deleting or rescanning the media file it was
+// named after does not change its destination. A folder whose object is
+// already stored under that destination is replaced rather than uploaded new.
+func (r *Runner) applyFolderIdentity(folderPath string, pathId filter.PathIdentifier) (filter.PathIdentifier, error) {
+	if r.ArchivedFolderRepository == nil {
+		return pathId, nil
+	}
+
+	archivedFolder, err := r.ArchivedFolderRepository.ArchivedFolderByPath(folderPath)
+	if err != nil || archivedFolder == nil || archivedFolder.FolderId == "" {
+		return pathId, err
+	}
+
+	pathId.Id = archivedFolder.FolderId
+	if archivedFolder.Destination == r.FilterService.UploadDestination(pathId) {
+		pathId.UploadType = filter.UpdatedMedia
+	}
+	return pathId, nil
+}
+
+// backfillFolderIds gives the folders archived before folder IDs were recorded
+// the ID their destination was named after.
+func (r *Runner) backfillFolderIds() error {
+	archivedFolders, err := r.ArchivedFolderRepository.AllArchivedFolders()
+	if err != nil {
+		return err
+	}
+
+	for _, archivedFolder := range archivedFolders {
+		if archivedFolder.FolderId != "" {
+			continue
+		}
+		folderId, ok := folderIdFromObject(filepath.Base(archivedFolder.Path), archivedFolder.Destination)
+		if !ok {
+			log.Printf("WARNING: Could not work out the folder ID of %v from %v",
+				archivedFolder.Path, archivedFolder.Destination)
+			continue
+		}
+		archivedFolder.FolderId = folderId
+		if err := r.ArchivedFolderRepository.SaveArchivedFolder(archivedFolder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// folderIdFromObject is the reverse of UploadDestination, returning the ID an
+// archive of the folder named basePath was stored under.
+func folderIdFromObject(basePath string, object string) (string, bool) {
+	format := zipper.FormatForFile(object)
+	if format == nil {
+		return "", false
+	}
+	folderId, found := strings.CutPrefix(strings.TrimSuffix(object, format.Extension()), basePath)
+	return folderId, found && folderIdPattern.MatchString(folderId)
+}
+
+// RunMigrateIds records a stable identity for every folder in the library that
+// is already in storage, so it keeps its object name from then on. Folders in
+// the archive DB keep the ID of their destination, and the rest are matched
+// with an object named after the folder - preferring one named after a media
+// file still in it, for folders that share a name. Folders are fingerprinted
+// too, so their archives can be moved if they are renamed.
+func (r *Runner) RunMigrateIds() error {
+	if err := r.ArchivedFolderRepository.CreateTable(); err != nil {
+		return fmt.Errorf("failed to CreateTable archived folder: %v", err)
+	}
+	if err := r.backfillFolderIds(); err != nil {
+		return fmt.Errorf("failed to backfill folder IDs: %v", err)
+	}
+	if err := r.useFileHashCache(); err != nil {
+		return err
+	}
+
+	mediaFiles, err := r.MusicFoldersRepository.AllMediaFiles()
+	if err != nil {
+		return fmt.Errorf("failed get media files from repository: %v", err)
+	}
+	absoluteMediaFiles, err := r.absoluteMediaFiles(mediaFiles)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute media files: %v", err)
+	}
+	mediaFileIds := r.FilterService.MediaFileIdsByFolder(absoluteMediaFiles)
+
+	archivedFolders, err := r.ArchivedFolderRepository.AllArchivedFolders()
+	if err != nil {
+		return fmt.Errorf("failed to get archived folders: %v", err)
+	}
+	archived := make(map[string]bool)
+	claimed := make(map[string]bool)
+	for _, archivedFolder := range archivedFolders {
+		archived[archivedFolder.Path] = true
+		claimed[archivedFolder.Destination] = true
+	}
+
+	backupFiles, err := r.StorageClient.ListFiles("")
+	if err != nil {
+		return fmt.Errorf("failed to list storage: %v", err)
+	}
+	objects := storedArchives(backupFiles)
+
+	migrated := 0
+	for _, folderPath := range slices.Sorted(maps.Keys(mediaFileIds)) {
+		if archived[folderPath] {
+			continue
+		}
+		object, folderId := matchStoredArchive(filepath.Base(folderPath), mediaFileIds[folderPath], objects, claimed)
+		if object == "" {
+			continue
+		}
+
+		log.Printf("Recording %v as archived in %v", folderPath, object)
+		claimed[object] = true
+		err := r.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+			Path:        folderPath,
+			FolderId:    folderId,
+			Destination: object,
+			Size:        objects[object].size,
+			UploadedAt:  objects[object].updated,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record %v: %v", folderPath, err)
+		}
+		migrated++
+	}
+
+	log.Printf("Migrated %v folders, %v were already recorded", migrated, len(archivedFolders))
+
+	if err := r.backfillFingerprints(); err != nil {
+		return fmt.Errorf("failed to backfill fingerprints: %v", err)
+	}
+	return nil
+}
+
+type storedArchiveObject struct {
+	size    int64
+	updated time.Time
+}
+
+// storedArchives groups the archives in storage by the name they were stored
+// under, adding up the parts of split folders.
+func storedArchives(backupFiles []storageclient.BackupFile) map[string]storedArchiveObject {
+	objects := make(map[string]storedArchiveObject)
+	for _, backupFile := range backupFiles {
+		if strings.HasPrefix(backupFile.Name, deletedPrefix) || strings.HasPrefix(backupFile.Name, versionsPrefix) ||
+			zipper.FormatForFile(backupFile.Name) == nil {
+			continue
+		}
+		name := backupFile.Name
+		if wholeName, _, isPart := zipper.ParsePartName(name); isPart {
+			name = wholeName
+		}
+		object := objects[name]
+		object.size += backupFile.Size
+		if backupFile.Updated.After(object.updated) {
+			object.updated = backupFile.Updated
+		}
+		objects[name] = object
+	}
+	return objects
+}
+
+// matchStoredArchive finds the unclaimed archive named after the folder. A
+// folder with more than one candidate and none named after its media is left
+// alone rather than guessed at.
+func matchStoredArchive(basePath string, mediaFileIds []string,
+	objects map[string]storedArchiveObject, claimed map[string]bool) (string, string) {
+	var candidates []string
+	for object := range objects {
+		if _, ok := folderIdFromObject(basePath, object); ok && !claimed[object] {
+			candidates = append(candidates, object)
+		}
+	}
+	slices.Sort(candidates)
+
+	for _, object := range candidates {
+		if folderId, _ := folderIdFromObject(basePath, object); slices.Contains(mediaFileIds, folderId) {
+			return object, folderId
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", ""
+	case 1:
+		folderId, _ := folderIdFromObject(basePath, candidates[0])
+		return candidates[0], folderId
+	default:
+		log.Printf("WARNING: Not migrating %v, as it could be any of %v", basePath, strings.Join(candidates, ", "))
+		return "", ""
+	}
+}
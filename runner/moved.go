@@ -0,0 +1,236 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	"github.com/apkatsikas/archiver/filter"
+	"github.com/apkatsikas/archiver/zipper"
+)
+
+const (
+	// folderPathMetadata and folderIdMetadata are the object metadata a moved
+	// archive records its new folder path and folder ID in, as the archive
+	// itself still holds the old ones.
+	folderPathMetadata = "folder-path"
+	folderIdMetadata   = "folder-id"
+)
+
+// addFingerprint fingerprints the files in the folder, so it can be matched
+// with its archive if it is renamed or moved later. It is a no-op when running
+// without an archive DB.
+func (r *Runner) addFingerprint(pathId filter.PathIdentifier) (filter.PathIdentifier, error) {
+	if r.ArchivedFolderRepository == nil {
+		return pathId, nil
+	}
+
+	fingerprint, err := r.Zipper.FolderFingerprint(pathId.FolderPath)
+	if err != nil {
+		return pathId, err
+	}
+	pathId.Fingerprint = fingerprint
+	return pathId, nil
+}
+
+// backfillFingerprints fingerprints the archived folders still on disk that
+// were archived before fingerprints were recorded. Their files are taken to be
+// what was archived, so folders with files modified since their upload, or
+// that were migrated without a newest media time, are left for their next
+// upload to fingerprint.
+func (r *Runner) backfillFingerprints() error {
+	archivedFolders, err := r.ArchivedFolderRepository.AllArchivedFolders()
+	if err != nil {
+		return err
+	}
+
+	fingerprinted := 0
+	for _, archivedFolder := range archivedFolders {
+		if archivedFolder.Fingerprint != "" || archivedFolder.NewestMediaAt.IsZero() {
+			continue
+		}
+		if _, err := r.FileSystemOperator.GetInfo(archivedFolder.Path); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		modified, err := r.modifiedSince(archivedFolder.Path, archivedFolder.UploadedAt)
+		if err != nil {
+			return fmt.Errorf("failed to check %v: %v", archivedFolder.Path, err)
+		}
+		if modified {
+			continue
+		}
+		archivedFolder.Fingerprint, err = r.Zipper.FolderFingerprint(archivedFolder.Path)
+		if err != nil {
+			return fmt.Errorf("failed to fingerprint %v: %v", archivedFolder.Path, err)
+		}
+		if err := r.ArchivedFolderRepository.SaveArchivedFolder(archivedFolder); err != nil {
+			return err
+		}
+		fingerprinted++
+	}
+	log.Printf("Fingerprinted %v folders", fingerprinted)
+	return nil
+}
+
+// modifiedSince checks whether any file that would be archived from the folder
+// was modified after t.
+func (r *Runner) modifiedSince(folderPath string, t time.Time) (bool, error) {
+	fileNames, err := r.Zipper.FilesToZip(folderPath)
+	if err != nil {
+		return false, err
+	}
+	for _, fileName := range fileNames {
+		info, err := r.FileSystemOperator.GetInfo(filepath.Join(folderPath, fileName))
+		if err != nil {
+			return false, err
+		}
+		if info.ModTime().After(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// moveArchivedFolder looks for a folder that was archived with the same files
+// and is gone from disk, meaning the folder was renamed or moved. Its objects
+// are moved to the destination of the folder in storage, instead of archiving
+// the folder again. It returns false if there was nothing to move.
+func (r *Runner) moveArchivedFolder(pathId filter.PathIdentifier) (bool, error) {
+	if r.ArchivedFolderRepository == nil || pathId.Fingerprint == "" {
+		return false, nil
+	}
+
+	existing, err := r.ArchivedFolderRepository.ArchivedFolderByPath(pathId.FolderPath)
+	if err != nil || existing != nil {
+		return false, err
+	}
+	movedFrom, err := r.movedFrom(pathId.Fingerprint)
+	if err != nil || movedFrom == nil {
+		return false, err
+	}
+
+	// The folder keeps the identity it was archived under, so only its name
+	// changes in storage.
+	if movedFrom.FolderId != "" {
+		pathId.Id = movedFrom.FolderId
+	}
+	destination := r.FilterService.UploadDestination(pathId)
+	objects, err := r.destinationObjects(movedFrom.Destination, "")
+	if err != nil {
+		return false, err
+	}
+	if len(objects) == 0 {
+		log.Printf("WARNING: %v was moved from %v, but %v is not in storage",
+			pathId.BasePath, movedFrom.Path, movedFrom.Destination)
+		return false, nil
+	}
+
+	log.Printf("%v was moved from %v, moving %v to %v",
+		pathId.FolderPath, movedFrom.Path, movedFrom.Destination, destination)
+	stored := storedArchive{size: movedFrom.Size, checksum: movedFrom.Checksum, crc32c: movedFrom.CRC32C}
+	metadata := map[string]string{folderPathMetadata: pathId.FolderPath, folderIdMetadata: pathId.Id}
+	stored, err = r.moveObjects(objects, movedFrom.Destination, destination, stored, metadata)
+	if err != nil {
+		return false, err
+	}
+
+	err = r.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+		Path:          pathId.FolderPath,
+		FolderId:      pathId.Id,
+		Destination:   destination,
+		Size:          stored.size,
+		Checksum:      stored.checksum,
+		CRC32C:        stored.crc32c,
+		Fingerprint:   pathId.Fingerprint,
+		NewestMediaAt: pathId.NewestMediaAt,
+		UploadedAt:    movedFrom.UploadedAt,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to record archived folder %v: %v", pathId.BasePath, err)
+	}
+	if err := r.ArchivedFolderRepository.DeleteArchivedFolder(movedFrom.Path); err != nil {
+		return false, fmt.Errorf("failed to forget %v: %v", movedFrom.Path, err)
+	}
+	if r.ArchiveVersionRepository != nil {
+		if err := r.ArchiveVersionRepository.MoveArchiveVersions(movedFrom.Path, pathId.FolderPath); err != nil {
+			return false, fmt.Errorf("failed to move the versions of %v: %v", movedFrom.Path, err)
+		}
+	}
+	// The old path must not be purged by the deleted media policy, as its
+	// objects may still be under the same destination.
+	if err := r.forgetTombstone(movedFrom.Path); err != nil {
+		return false, fmt.Errorf("failed to delete tombstone of %v: %v", movedFrom.Path, err)
+	}
+	return true, nil
+}
+
+// movedFrom returns the archived folder with the fingerprint that is no longer
+// on disk, or nil if there is none.
+func (r *Runner) movedFrom(fingerprint string) (*db.ArchivedFolder, error) {
+	archivedFolders, err := r.ArchivedFolderRepository.ArchivedFoldersByFingerprint(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	for _, archivedFolder := range archivedFolders {
+		if _, err := r.FileSystemOperator.GetInfo(archivedFolder.Path); errors.Is(err, fs.ErrNotExist) {
+			return &archivedFolder, nil
+		}
+	}
+	return nil, nil
+}
+
+// moveObjects moves the objects of an archive from one destination to another,
+// setting the metadata of the folder they now belong to on each archive
+// object. A split folder has the parts in its manifest renamed, so the manifest
+// is stored again under the new destination, changing its checksums.
+func (r *Runner) moveObjects(objects []string, from string, to string,
+	stored storedArchive, metadata map[string]string) (storedArchive, error) {
+	fromManifest := partsManifestName(from)
+	split := false
+	for _, object := range objects {
+		if object == fromManifest {
+			split = true
+			continue
+		}
+		target := to
+		if _, part, isPart := zipper.ParsePartName(object); isPart {
+			target = zipper.PartName(to, part)
+		}
+		if target != object {
+			log.Printf("Moving %v to %v", object, target)
+			if err := r.StorageClient.MoveFile(object, target); err != nil {
+				return storedArchive{}, fmt.Errorf("failed to move %v to %v: %v", object, target, err)
+			}
+		}
+		if err := r.StorageClient.SetMetadata(target, metadata); err != nil {
+			return storedArchive{}, fmt.Errorf("failed to set metadata of %v: %v", target, err)
+		}
+	}
+	if !split || to == from {
+		return stored, nil
+	}
+
+	manifest, err := r.downloadManifest(fromManifest, os.TempDir())
+	if err != nil {
+		return storedArchive{}, err
+	}
+	for i, part := range manifest.Parts {
+		if _, n, isPart := zipper.ParsePartName(part.Object); isPart {
+			manifest.Parts[i].Object = zipper.PartName(to, n)
+		}
+	}
+	// A manifest left by a move that failed part way is replaced.
+	stored, err = r.storeManifest(manifest, to, filter.UpdatedMedia)
+	if err != nil {
+		return storedArchive{}, err
+	}
+	if err := r.StorageClient.DeleteFile(fromManifest); err != nil {
+		return storedArchive{}, fmt.Errorf("failed to delete %v: %v", fromManifest, err)
+	}
+	return stored, nil
+}
@@ -0,0 +1,266 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/apkatsikas/archiver/fileutil"
+	"github.com/apkatsikas/archiver/filter"
+	storageclient "github.com/apkatsikas/archiver/storage-client"
+	"github.com/apkatsikas/archiver/zipper"
+)
+
+const partsManifestExtension = ".parts.json"
+
+// PartsManifest is uploaded after the parts of a folder that was split, so
+// restore knows which parts make up the folder and can tell if any are missing.
+type PartsManifest struct {
+	Folder string         `json:"folder"`
+	Parts  []ManifestPart `json:"parts"`
+}
+
+type ManifestPart struct {
+	Object   string `json:"object"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// partsManifestName swaps the archive extension of destination for the
+// manifest extension, turning album.zip into album.parts.json.
+func partsManifestName(destination string) string {
+	if format := zipper.FormatForFile(destination); format != nil {
+		destination = strings.TrimSuffix(destination, format.Extension())
+	}
+	return destination + partsManifestExtension
+}
+
+func (r *Runner) handleStorageInParts(partPaths []string, pathIdentifier filter.PathIdentifier) error {
+	log.Printf("%v is upload type %v in %v parts",
+		pathIdentifier.BasePath, pathIdentifier.UploadType, len(partPaths))
+	destination := r.FilterService.UploadDestination(pathIdentifier)
+
+	manifest := &PartsManifest{Folder: pathIdentifier.BasePath}
+	for i, partPath := range partPaths {
+		partDestination := zipper.PartName(destination, i+1)
+		if err := r.storeFile(partPath, partDestination, pathIdentifier.UploadType); err != nil {
+			return fmt.Errorf("failed to send %v to storage: %v", partPath, err)
+		}
+
+		partInfo, err := r.FileSystemOperator.GetInfo(partPath)
+		if err != nil {
+			return err
+		}
+		checksum, err := r.FileSystemOperator.FileChecksum(partPath)
+		if err != nil {
+			return err
+		}
+		manifest.Parts = append(manifest.Parts,
+			ManifestPart{Object: partDestination, Size: partInfo.Size(), Checksum: checksum})
+	}
+
+	stored, err := r.storeManifest(manifest, destination, pathIdentifier.UploadType)
+	if err != nil {
+		return err
+	}
+	err = r.removeStaleObjects(destination, len(partPaths), pathIdentifier.UploadType)
+	if err != nil {
+		return err
+	}
+	err = r.saveArchivedFolder(destination, pathIdentifier, stored)
+	if err != nil {
+		return fmt.Errorf("failed to record archived folder %v: %v", pathIdentifier.BasePath, err)
+	}
+
+	for _, partPath := range partPaths {
+		if err := r.FileSystemOperator.DeleteFile(partPath); err != nil {
+			return fmt.Errorf("failed to delete %v: %v", partPath, err)
+		}
+	}
+	log.Printf("Finished handleStorage for %v", pathIdentifier.BasePath)
+	return nil
+}
+
+// streamParts streams each part of a split folder into storage, then its
+// manifest.
+func (r *Runner) streamParts(
+	pathIdentifier filter.PathIdentifier, fileParts [][]string, destination string) (storedArchive, error) {
+	manifest := &PartsManifest{Folder: pathIdentifier.BasePath}
+	for i, fileNames := range fileParts {
+		partDestination := zipper.PartName(destination, i+1)
+		stored, err := r.streamArchive(pathIdentifier, fileNames, partDestination)
+		if err != nil {
+			return storedArchive{}, err
+		}
+		manifest.Parts = append(manifest.Parts,
+			ManifestPart{Object: partDestination, Size: stored.size, Checksum: stored.checksum})
+	}
+	return r.storeManifest(manifest, destination, pathIdentifier.UploadType)
+}
+
+// storeManifest uploads the manifest once every part is stored, so a manifest
+// is never left pointing at parts that were not uploaded. It returns the total
+// size of the parts and the checksums of the manifest, which cover every part.
+func (r *Runner) storeManifest(
+	manifest *PartsManifest, destination string, uploadType filter.UploadType) (storedArchive, error) {
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return storedArchive{}, fmt.Errorf("failed to marshal parts manifest: %v", err)
+	}
+
+	manifestDestination := partsManifestName(destination)
+	write := func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+	if err := r.storeStream(write, manifestDestination, uploadType); err != nil {
+		return storedArchive{}, fmt.Errorf("failed to send %v to storage: %v", manifestDestination, err)
+	}
+
+	stored := storedArchive{crc32c: fmt.Sprintf("%08x", crc32.Checksum(data, fileutil.CRC32CTable))}
+	for _, part := range manifest.Parts {
+		stored.size += part.Size
+	}
+	checksum := sha256.Sum256(data)
+	stored.checksum = hex.EncodeToString(checksum[:])
+	return stored, nil
+}
+
+// storeFile uploads a whole archive or a part. An updated folder can be split
+// into more parts than before, or no longer be split, so replacing an object
+// that does not exist yet falls back to uploading it as new.
+func (r *Runner) storeFile(filePath string, destination string, uploadType filter.UploadType) error {
+	if uploadType == filter.UpdatedMedia {
+		err := r.StorageClient.ReplaceFile(filePath, destination)
+		if !errors.Is(err, storageclient.ErrObjectNotFound) {
+			return err
+		}
+	}
+	return r.StorageClient.UploadNewFile(filePath, destination)
+}
+
+// storeStream is storeFile for a stream.
+func (r *Runner) storeStream(
+	write storageclient.StreamWriter, destination string, uploadType filter.UploadType) error {
+	if uploadType == filter.UpdatedMedia {
+		err := r.StorageClient.ReplaceStream(write, destination)
+		if !errors.Is(err, storageclient.ErrObjectNotFound) {
+			return err
+		}
+	}
+	return r.StorageClient.UploadNewStream(write, destination)
+}
+
+// storedObjects returns the objects an archive of destination is stored as -
+// the archive itself, or its parts and their manifest.
+func storedObjects(destination string, parts int) []string {
+	if parts == 0 {
+		return []string{destination}
+	}
+	var objects []string
+	for i := 1; i <= parts; i++ {
+		objects = append(objects, zipper.PartName(destination, i))
+	}
+	return append(objects, partsManifestName(destination))
+}
+
+// removeStaleObjects deletes what an earlier archive of an updated folder left
+// under destination in the other layout - the whole archive once the folder
+// is split, or the manifest and parts once it is not - along with any parts
+// beyond the ones just stored, so restore and verify only see the new archive.
+func (r *Runner) removeStaleObjects(destination string, parts int, uploadType filter.UploadType) error {
+	if uploadType != filter.UpdatedMedia {
+		return nil
+	}
+	objects, err := r.destinationObjects(destination, "")
+	if err != nil {
+		return err
+	}
+	stored := storedObjects(destination, parts)
+	for _, object := range objects {
+		if slices.Contains(stored, object) {
+			continue
+		}
+		log.Printf("Deleting %v, left over from an earlier archive", object)
+		if err := r.StorageClient.DeleteFile(object); err != nil {
+			return fmt.Errorf("failed to delete stale object %v: %v", object, err)
+		}
+	}
+	return nil
+}
+
+// partsToRestore returns the parts listed in the manifest of the folder that
+// part belongs to, failing if any of them are missing from storage. It
+// returns nil if there is no manifest.
+func (r *Runner) partsToRestore(wholeName string, targetDir string) ([]string, error) {
+	manifestObject := partsManifestName(wholeName)
+	// The manifest and every part start with the name they were split from,
+	// minus its extension.
+	objects, err := r.StorageClient.ListFiles(strings.TrimSuffix(manifestObject, partsManifestExtension))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts: %v", err)
+	}
+	stored := make(map[string]bool)
+	for _, object := range objects {
+		stored[object.Name] = true
+	}
+	if !stored[manifestObject] {
+		return nil, nil
+	}
+
+	manifest, err := r.downloadManifest(manifestObject, targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parts are listed under the names they were uploaded as, but are kept
+	// next to their manifest when it is moved or copied under a prefix.
+	prefix := strings.TrimSuffix(manifestObject, path.Base(manifestObject))
+	var parts, missing []string
+	for _, part := range manifest.Parts {
+		object := part.Object
+		if !strings.HasPrefix(object, prefix) {
+			object = prefix + object
+		}
+		parts = append(parts, object)
+		if !stored[object] {
+			missing = append(missing, object)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing %v of %v parts: %v",
+			len(missing), len(parts), strings.Join(missing, ", "))
+	}
+	return parts, nil
+}
+
+func (r *Runner) downloadManifest(manifestObject string, targetDir string) (*PartsManifest, error) {
+	downloadPath := filepath.Join(targetDir, restoreDownloadPrefix+path.Base(manifestObject))
+	if err := r.StorageClient.DownloadFile(manifestObject, downloadPath); err != nil {
+		return nil, fmt.Errorf("failed to download %v: %v", manifestObject, err)
+	}
+	defer func() {
+		if err := r.FileSystemOperator.DeleteFile(downloadPath); err != nil {
+			log.Printf("failed to delete %v: %v", downloadPath, err)
+		}
+	}()
+
+	data, err := r.FileSystemOperator.ReadFile(downloadPath)
+	if err != nil {
+		return nil, err
+	}
+	var manifest PartsManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to read %v: %v", manifestObject, err)
+	}
+	return &manifest, nil
+}
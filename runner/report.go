@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	storageclient "github.com/apkatsikas/archiver/storage-client"
+)
+
+type FolderOutcome string
+
+const (
+	FolderSucceeded FolderOutcome = "succeeded"
+	FolderSkipped   FolderOutcome = "skipped"
+	FolderFailed    FolderOutcome = "failed"
+)
+
+type FolderResult struct {
+	Folder  string        `json:"folder"`
+	Outcome FolderOutcome `json:"outcome"`
+	Reason  string        `json:"reason,omitempty"`
+	// MissedDestinations are the secondary destinations a failed folder was
+	// not stored in, when it was stored in the required ones.
+	MissedDestinations []string `json:"missedDestinations,omitempty"`
+}
+
+// RunReport holds what happened to every folder an archive run looked at.
+type RunReport struct {
+	Succeeded []FolderResult `json:"succeeded"`
+	Skipped   []FolderResult `json:"skipped"`
+	Failed    []FolderResult `json:"failed"`
+}
+
+func (rr *RunReport) succeeded(folder string) {
+	rr.Succeeded = append(rr.Succeeded, FolderResult{Folder: folder, Outcome: FolderSucceeded})
+}
+
+func (rr *RunReport) skipped(folder string, reason string) {
+	rr.Skipped = append(rr.Skipped, FolderResult{Folder: folder, Outcome: FolderSkipped, Reason: reason})
+}
+
+func (rr *RunReport) failed(folder string, err error) {
+	rr.Failed = append(rr.Failed, FolderResult{Folder: folder, Outcome: FolderFailed, Reason: err.Error()})
+}
+
+// missed records a folder that was not stored in every destination as failed,
+// so it is retried.
+func (rr *RunReport) missed(folder string, missed []storageclient.DestinationResult) {
+	var destinations, errs []string
+	for _, result := range missed {
+		destinations = append(destinations, result.Destination)
+		errs = append(errs, fmt.Sprintf("%v: %v", result.Destination, result.Err))
+	}
+	rr.Failed = append(rr.Failed, FolderResult{
+		Folder:             folder,
+		Outcome:            FolderFailed,
+		Reason:             "not stored in every destination - " + strings.Join(errs, "; "),
+		MissedDestinations: destinations,
+	})
+}
+
+// Summary is a human readable version of the report, counting the folders
+// skipped for each reason and listing every failure.
+func (rr *RunReport) Summary() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v folders succeeded, %v skipped, %v failed",
+		len(rr.Succeeded), len(rr.Skipped), len(rr.Failed))
+
+	var reasons []string
+	skipCounts := make(map[string]int)
+	for _, result := range rr.Skipped {
+		if skipCounts[result.Reason] == 0 {
+			reasons = append(reasons, result.Reason)
+		}
+		skipCounts[result.Reason]++
+	}
+	for _, reason := range reasons {
+		fmt.Fprintf(&sb, "\n- %v skipped: %v", skipCounts[reason], reason)
+	}
+	for _, result := range rr.Failed {
+		fmt.Fprintf(&sb, "\n- %v: %v", result.Folder, result.Reason)
+	}
+	return sb.String()
+}
+
+// FailedFoldersError is returned when a run that continues on error could not
+// archive every folder.
+type FailedFoldersError struct {
+	Report *RunReport
+}
+
+func (ffe *FailedFoldersError) Error() string {
+	return fmt.Sprintf("failed to archive %v folders - %v", len(ffe.Report.Failed), ffe.Report.Summary())
+}
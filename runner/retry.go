@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	"github.com/apkatsikas/archiver/filter"
+)
+
+// maxFolderAttempts is how many scheduled runs in a row a folder can fail
+// before it is given up on, until its media changes again.
+const maxFolderAttempts = 5
+
+// addFolderRetries adds the folders earlier scheduled runs failed to archive
+// to identifiedPaths, returning the retries recorded for them by path.
+func (r *Runner) addFolderRetries(identifiedPaths filter.IdentifiedPaths) (map[string]db.FolderRetry, error) {
+	retries := make(map[string]db.FolderRetry)
+	if r.FolderRetryRepository == nil {
+		return retries, nil
+	}
+
+	folderRetries, err := r.FolderRetryRepository.AllFolderRetries()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder retries: %v", err)
+	}
+	for _, folderRetry := range folderRetries {
+		retries[folderRetry.Path] = folderRetry
+		if _, identified := identifiedPaths[folderRetry.Path]; identified {
+			continue
+		}
+		var pathId filter.PathIdentifier
+		if err := json.Unmarshal([]byte(folderRetry.PathIdentifier), &pathId); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal retry of %v: %v", folderRetry.Path, err)
+		}
+		pathId.NewestMediaAt = folderRetry.NewestMediaAt
+		log.Printf("Retrying %v, which failed %v times: %v",
+			folderRetry.Path, folderRetry.Attempts, folderRetry.Reason)
+		identifiedPaths[folderRetry.Path] = pathId
+	}
+	return retries, nil
+}
+
+// recordFolderRetries records every failed folder to be retried by the next
+// scheduled run, and forgets the ones that no longer fail. A folder that has
+// failed maxFolderAttempts times is given up on, so it is only picked up again
+// once its media changes.
+func (r *Runner) recordFolderRetries(report *RunReport, identifiedPaths filter.IdentifiedPaths,
+	retries map[string]db.FolderRetry) error {
+	if r.FolderRetryRepository == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	for i, result := range report.Failed {
+		folderRetry, retried := retries[result.Folder]
+		if !retried {
+			pathIdentifier, err := json.Marshal(identifiedPaths[result.Folder])
+			if err != nil {
+				return fmt.Errorf("failed to marshal path identifier of %v: %v", result.Folder, err)
+			}
+			folderRetry = db.FolderRetry{
+				Path:           result.Folder,
+				PathIdentifier: string(pathIdentifier),
+				FirstFailedAt:  now,
+			}
+		}
+		if newest := identifiedPaths[result.Folder].NewestMediaAt; newest.After(folderRetry.NewestMediaAt) {
+			folderRetry.NewestMediaAt = newest
+		}
+		folderRetry.Reason = result.Reason
+		folderRetry.Attempts++
+		folderRetry.LastFailedAt = now
+
+		if folderRetry.Attempts >= maxFolderAttempts {
+			log.Printf("WARNING: Giving up on %v after %v failed attempts", result.Folder, folderRetry.Attempts)
+			report.Failed[i].Reason = fmt.Sprintf("%v - giving up after %v failed attempts",
+				result.Reason, folderRetry.Attempts)
+			if err := r.FolderRetryRepository.DeleteFolderRetry(result.Folder); err != nil {
+				return fmt.Errorf("failed to delete retry of %v: %v", result.Folder, err)
+			}
+			continue
+		}
+		if err := r.FolderRetryRepository.SaveFolderRetry(folderRetry); err != nil {
+			return fmt.Errorf("failed to save retry of %v: %v", result.Folder, err)
+		}
+	}
+
+	for _, results := range [][]FolderResult{report.Succeeded, report.Skipped} {
+		for _, result := range results {
+			if _, retried := retries[result.Folder]; !retried {
+				continue
+			}
+			if err := r.FolderRetryRepository.DeleteFolderRetry(result.Folder); err != nil {
+				return fmt.Errorf("failed to delete retry of %v: %v", result.Folder, err)
+			}
+		}
+	}
+	r.writeReport(report)
+	return nil
+}
@@ -1,10 +1,17 @@
 package runner
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"log"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/apkatsikas/archiver/db"
@@ -22,11 +29,58 @@ type Runner struct {
 	*db.ArchiveRunRepository
 	*db.AdminRepository
 	*db.LibraryRepository
+	*db.ArchivedFolderRepository
+	*db.ScrubResultRepository
+	*db.TombstoneRepository
+	*db.FileHashRepository
+	*db.ArchiveVersionRepository
+	*db.DbBackupRepository
+	*db.FolderRetryRepository
 	*zipper.Zipper
 	FileSystemOperator fileutil.IFileSystemOperator
+	// ContinueOnError records a failing folder in the run report and moves
+	// on to the next one, instead of stopping the run.
+	ContinueOnError bool
+	// ReportFile is where the JSON run report is written, if set.
+	ReportFile string
+	// StreamUploads zips folders straight into storage instead of writing
+	// the zip next to the folder first.
+	StreamUploads bool
+	// ScrubCount is how many archived folders a scrub checks - the default is 10.
+	ScrubCount int
+	// ScrubRandom scrubs a random sample of folders, instead of the ones
+	// scrubbed least recently.
+	ScrubRandom bool
+	// ScrubDir is where a scrub downloads archives to.
+	ScrubDir string
+	// DeletedMediaPolicy is what a scheduled run does with the archives of
+	// folders that are gone from the library - the default is to ignore them.
+	DeletedMediaPolicy DeletedMediaPolicy
+	// DeleteGracePeriod is how long a folder has to be gone before the
+	// delete policy deletes its objects.
+	DeleteGracePeriod time.Duration
+	// KeepVersions is how many earlier archives of a folder are kept when it
+	// is archived again - the default of 0 overwrites them.
+	KeepVersions int
+	// VersionMaxAge prunes kept versions replaced longer ago than this, if set.
+	VersionMaxAge time.Duration
+	// RestoreVersion restores the archives kept as this version, instead of
+	// the current ones.
+	RestoreVersion string
+	// RestorePrefix is the prefix restore mode looks for archives beneath.
+	RestorePrefix string
+	// DbBackupRetention keeps dated snapshots of the Navidrome DB backup - the
+	// default overwrites a single backup.
+	DbBackupRetention DbBackupRetention
+	// DbSnapshot restores this Navidrome DB snapshot, instead of the latest.
+	DbSnapshot string
 }
 
-const navidromeBackupDB = "navidrome-backup.sqlite"
+const (
+	navidromeBackupDB       = "navidrome-backup.sqlite"
+	restoreDownloadPrefix   = ".navarchiver-download-"
+	restoreDbDownloadSuffix = ".navarchiver-download"
+)
 
 func (r *Runner) RunScheduled() error {
 	err := r.ArchiveRunRepository.CreateTable()
@@ -34,6 +88,39 @@ func (r *Runner) RunScheduled() error {
 		return fmt.Errorf("failed to get CreateTable archive run: %v", err)
 	}
 
+	err = r.ArchivedFolderRepository.CreateTable()
+	if err != nil {
+		return fmt.Errorf("failed to CreateTable archived folder: %v", err)
+	}
+
+	err = r.backfillFolderIds()
+	if err != nil {
+		return fmt.Errorf("failed to backfill folder IDs: %v", err)
+	}
+
+	err = r.useFileHashCache()
+	if err != nil {
+		return err
+	}
+
+	if r.ArchiveVersionRepository != nil {
+		if err := r.ArchiveVersionRepository.CreateTable(); err != nil {
+			return fmt.Errorf("failed to CreateTable archive version: %v", err)
+		}
+	}
+
+	if r.DbBackupRepository != nil {
+		if err := r.DbBackupRepository.CreateTable(); err != nil {
+			return fmt.Errorf("failed to CreateTable db backup: %v", err)
+		}
+	}
+
+	if r.FolderRetryRepository != nil {
+		if err := r.FolderRetryRepository.CreateTable(); err != nil {
+			return fmt.Errorf("failed to CreateTable folder retry: %v", err)
+		}
+	}
+
 	lastRun, err := r.ArchiveRunRepository.LastRun()
 	if err != nil {
 		return fmt.Errorf("failed to get last archive run: %v", err)
@@ -68,29 +155,58 @@ func (r *Runner) RunScheduled() error {
 	identifiedPaths := r.FilterService.UpdatedAndNewIdentifiedPaths(
 		absoluteNewMediaFiles, absoluteUpdatedMediaFiles)
 
-	zips, err := r.zipFiles(identifiedPaths)
+	retries, err := r.addFolderRetries(identifiedPaths)
 	if err != nil {
-		return fmt.Errorf("failed to zip files: %v", err)
+		return err
 	}
 
-	err = r.handleStorage(zips)
-	if err != nil {
-		return fmt.Errorf("failed to handle storage: %v", err)
+	if len(identifiedPaths) > 0 {
+		if err := r.addMediaFileIds(identifiedPaths); err != nil {
+			return fmt.Errorf("failed to get media file IDs: %v", err)
+		}
 	}
 
-	if len(identifiedPaths) > 0 {
-		if err := r.FileSystemOperator.DeleteFile(navidromeBackupDB); err != nil {
-			log.Printf("failed to delete navidrome backup DB: %v", err)
+	// With ContinueOnError, the folders that did succeed still get their DB
+	// backup. Failures are retried by the next run - through the retry table
+	// with a FolderRetryRepository, otherwise by leaving the last run date alone.
+	report, archiveErr := r.archiveFolders(identifiedPaths)
+	var failedFolders *FailedFoldersError
+	if archiveErr != nil && !errors.As(archiveErr, &failedFolders) {
+		return archiveErr
+	}
+	if err := r.recordFolderRetries(report, identifiedPaths, retries); err != nil {
+		return err
+	}
+
+	// Play counts, stars, playlists and users change without any media
+	// changing, so with a DbBackupRepository the DB is backed up whenever
+	// it differs from the last backup.
+	if len(identifiedPaths) > 0 || r.DbBackupRepository != nil {
+		r.clearMissedDestinations()
+		if err := r.backupNavidromeDb(); err != nil {
+			return err
 		}
-		if err := r.AdminRepository.CreateBackup(navidromeBackupDB); err != nil {
-			return fmt.Errorf("failed to vacuum: %v", err)
+		if missed := r.missedDestinations(); len(missed) > 0 {
+			report.missed(navidromeBackupDB, missed)
+			r.writeReport(report)
+			failedFolders = &FailedFoldersError{Report: report}
 		}
+	}
 
-		if err := r.StorageClient.ReplaceFile(navidromeBackupDB, navidromeBackupDB); err != nil {
-			if err := r.StorageClient.UploadNewFile(navidromeBackupDB, navidromeBackupDB); err != nil {
-				return fmt.Errorf("failed to navidrome backup DB to storage: %v", err)
-			}
-		}
+	if err := r.pruneAllVersions(); err != nil {
+		return err
+	}
+
+	if err := r.handleDeletedFolders(); err != nil {
+		return err
+	}
+
+	if err := r.pruneFileHashCache(); err != nil {
+		return err
+	}
+
+	if failedFolders != nil && r.FolderRetryRepository == nil {
+		return failedFolders
 	}
 
 	err = r.ArchiveRunRepository.UpdateLastRun(time.Now().UTC())
@@ -98,6 +214,9 @@ func (r *Runner) RunScheduled() error {
 		return fmt.Errorf("failed to update last archive run: %v", err)
 	}
 
+	if failedFolders != nil {
+		return failedFolders
+	}
 	return nil
 }
 
@@ -113,14 +232,18 @@ func (r *Runner) RunBatch(jsonPath string) error {
 		return fmt.Errorf("got an error trying to unmarshal: %v", err)
 	}
 
-	zips, err := r.zipFiles(identifiedPaths)
-	if err != nil {
-		return fmt.Errorf("failed to zip files: %v", err)
+	if r.ArchivedFolderRepository != nil {
+		if err := r.backfillFolderIds(); err != nil {
+			return fmt.Errorf("failed to backfill folder IDs: %v", err)
+		}
+	}
+	if err := r.useFileHashCache(); err != nil {
+		return err
 	}
 
-	err = r.handleStorage(zips)
+	_, err = r.archiveFolders(identifiedPaths)
 	if err != nil {
-		return fmt.Errorf("failed to handle storage: %v", err)
+		return err
 	}
 
 	return nil
@@ -149,6 +272,243 @@ func (r *Runner) BuildLedger(destination string) error {
 	return nil
 }
 
+// RunRestore downloads every archived folder whose object name matches pattern
+// and extracts it beneath targetDir. An empty pattern restores everything.
+func (r *Runner) RunRestore(targetDir string, pattern string) error {
+	if pattern == "" {
+		pattern = "*"
+	}
+	if r.RestoreVersion != "" {
+		pattern = versionPrefix(r.RestoreVersion) + pattern
+	}
+	pattern = r.RestorePrefix + pattern
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid restore pattern %v: %v", pattern, err)
+	}
+
+	backupFiles, err := r.StorageClient.ListFiles(listPrefix(pattern))
+	if err != nil {
+		return fmt.Errorf("failed to list storage: %v", err)
+	}
+
+	if err := r.FileSystemOperator.CreateDirectory(targetDir); err != nil {
+		return fmt.Errorf("failed to create %v: %v", targetDir, err)
+	}
+
+	restored := 0
+	restoredSplits := make(map[string]bool)
+	for _, backupFile := range backupFiles {
+		if zipper.FormatForFile(backupFile.Name) == nil {
+			continue
+		}
+		if matched, _ := path.Match(pattern, backupFile.Name); !matched {
+			continue
+		}
+
+		// Any part of a split folder restores the whole folder, in the parts
+		// listed by its manifest.
+		if wholeName, _, isPart := zipper.ParsePartName(backupFile.Name); isPart {
+			if restoredSplits[wholeName] {
+				continue
+			}
+			restoredSplits[wholeName] = true
+
+			parts, err := r.partsToRestore(wholeName, targetDir)
+			if err != nil {
+				return fmt.Errorf("failed to restore %v: %v", wholeName, err)
+			}
+			if parts != nil {
+				for _, part := range parts {
+					log.Printf("Restoring %v", part)
+					if err := r.restoreArchive(part, targetDir); err != nil {
+						return fmt.Errorf("failed to restore %v: %v", part, err)
+					}
+					restored++
+				}
+				continue
+			}
+			log.Printf("WARNING: No parts manifest found for %v, restoring it on its own", backupFile.Name)
+		}
+
+		log.Printf("Restoring %v", backupFile.Name)
+		if err := r.restoreArchive(backupFile.Name, targetDir); err != nil {
+			return fmt.Errorf("failed to restore %v: %v", backupFile.Name, err)
+		}
+		restored++
+	}
+
+	if restored == 0 {
+		return fmt.Errorf("no archives matched %v", pattern)
+	}
+	log.Printf("Restored %v archives to %v", restored, targetDir)
+	return nil
+}
+
+func (r *Runner) restoreArchive(object string, targetDir string) error {
+	downloadPath := filepath.Join(targetDir, restoreDownloadPrefix+path.Base(object))
+
+	if err := r.StorageClient.DownloadFile(object, downloadPath); err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.FileSystemOperator.DeleteFile(downloadPath); err != nil {
+			log.Printf("failed to delete %v: %v", downloadPath, err)
+		}
+	}()
+
+	restoreDir, err := r.restoreDir(object, downloadPath, targetDir)
+	if err != nil {
+		return err
+	}
+	return r.Zipper.ExtractArchive(downloadPath, restoreDir)
+}
+
+// restoreDir is where an archive is extracted to, so that its folder ends up
+// at the same path beneath targetDir as it had beneath its library root.
+// Archives made without access to the Navidrome DB are extracted to targetDir.
+//
+// The archive of a renamed or moved folder is moved in storage without being
+// rewritten, so it still holds the path the folder was archived from, and is
+// restored there under its old name.
+func (r *Runner) restoreDir(object string, archivePath string, targetDir string) (string, error) {
+	manifest, err := r.Zipper.ReadManifest(archivePath)
+	if err != nil {
+		return "", err
+	}
+	if manifest == nil || manifest.Path == "" {
+		return targetDir, nil
+	}
+	relativePath := filepath.FromSlash(manifest.Path)
+	if !filepath.IsLocal(relativePath) {
+		return "", fmt.Errorf("archive has an invalid folder path: %v", manifest.Path)
+	}
+
+	name := path.Base(object)
+	if wholeName, _, isPart := zipper.ParsePartName(name); isPart {
+		name = wholeName
+	}
+	if _, ok := folderIdFromObject(path.Base(manifest.Path), name); !ok {
+		log.Printf("WARNING: %v was archived from %v and renamed since, restoring it to %v",
+			object, manifest.Path, filepath.Join(targetDir, relativePath))
+	}
+	return filepath.Join(targetDir, filepath.Dir(relativePath)), nil
+}
+
+// libraryRelativePath returns the path of folderPath beneath the library
+// holding it, which is recorded in the archive manifest for restore. It is
+// empty when no library holds the folder.
+func (r *Runner) libraryRelativePath(folderPath string) (string, error) {
+	if r.LibraryRepository == nil {
+		return "", nil
+	}
+	libraries, err := r.LibraryRepository.AllLibraries()
+	if err != nil {
+		return "", err
+	}
+
+	// Libraries can be nested, so the innermost one holding the folder wins.
+	relativePath := ""
+	for _, library := range libraries {
+		candidate, err := filepath.Rel(library.Path, folderPath)
+		if err != nil || !filepath.IsLocal(candidate) {
+			continue
+		}
+		if relativePath == "" || len(candidate) < len(relativePath) {
+			relativePath = candidate
+		}
+	}
+	return relativePath, nil
+}
+
+// RunRestoreDb downloads the Navidrome DB backup - DbSnapshot, the snapshot
+// named by the latest pointer or the single backup, in that order - checks its
+// integrity and, when oldRoot is set, moves library paths from oldRoot to
+// newRoot. The result is written to destination, which must not already exist.
+func (r *Runner) RunRestoreDb(destination string, oldRoot string, newRoot string) error {
+	if _, err := r.FileSystemOperator.GetInfo(destination); err == nil {
+		return fmt.Errorf("refusing to overwrite existing file %v", destination)
+	}
+
+	backup := r.DbSnapshot
+	if backup == "" {
+		var err error
+		if backup, err = r.dbBackupToRestore(destination); err != nil {
+			return err
+		}
+	}
+
+	downloadPath := destination + restoreDbDownloadSuffix
+	log.Printf("Restoring Navidrome DB from %v", backup)
+	if err := r.downloadDbBackup(backup, downloadPath); err != nil {
+		return err
+	}
+
+	if err := r.prepareRestoredDb(downloadPath, oldRoot, newRoot); err != nil {
+		if deleteErr := r.FileSystemOperator.DeleteFile(downloadPath); deleteErr != nil {
+			log.Printf("failed to delete %v: %v", downloadPath, deleteErr)
+		}
+		return err
+	}
+
+	if err := r.FileSystemOperator.RenameFile(downloadPath, destination); err != nil {
+		return fmt.Errorf("failed to move restored DB to %v: %v", destination, err)
+	}
+	log.Printf("Restored Navidrome DB to %v", destination)
+	return nil
+}
+
+func (r *Runner) prepareRestoredDb(dbPath string, oldRoot string, newRoot string) error {
+	sqliteHandler := &db.SQLiteHandler{}
+	if err := sqliteHandler.ConnectSQLite(dbPath); err != nil {
+		return err
+	}
+	defer sqliteHandler.Close()
+
+	adminRepository := &db.AdminRepository{SqliteHandler: sqliteHandler}
+	if err := adminRepository.IntegrityCheck(); err != nil {
+		return fmt.Errorf("restored DB failed integrity check: %v", err)
+	}
+
+	if oldRoot == "" {
+		return nil
+	}
+	libraryRepository := &db.LibraryRepository{SqliteHandler: sqliteHandler}
+	updated, err := libraryRepository.ReplacePathRoot(oldRoot, newRoot)
+	if err != nil {
+		return fmt.Errorf("failed to replace library root %v with %v: %v", oldRoot, newRoot, err)
+	}
+	log.Printf("Moved %v paths from %v to %v", updated, oldRoot, newRoot)
+	return nil
+}
+
+// listPrefix is the literal part of a restore pattern, which narrows the listing.
+func listPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// addMediaFileIds lists every media file in each folder for the archive
+// manifest, not just the new and updated ones that got the folder archived.
+func (r *Runner) addMediaFileIds(identifiedPaths filter.IdentifiedPaths) error {
+	mediaFiles, err := r.MusicFoldersRepository.AllMediaFiles()
+	if err != nil {
+		return err
+	}
+	absoluteMediaFiles, err := r.absoluteMediaFiles(mediaFiles)
+	if err != nil {
+		return err
+	}
+
+	mediaFileIds := r.FilterService.MediaFileIdsByFolder(absoluteMediaFiles)
+	for path, pathId := range identifiedPaths {
+		pathId.MediaFileIds = mediaFileIds[path]
+		identifiedPaths[path] = pathId
+	}
+	return nil
+}
+
 func (r *Runner) absoluteMediaFiles(mediaFiles []db.MediaFile) ([]db.MediaFile, error) {
 	var absoluteMediaFiles []db.MediaFile
 	for _, mediaFile := range mediaFiles {
@@ -176,50 +536,337 @@ func (r *Runner) absoluteMediaFiles(mediaFiles []db.MediaFile) ([]db.MediaFile,
 	return absoluteMediaFiles, nil
 }
 
-func (r *Runner) zipFiles(identifiedPaths filter.IdentifiedPaths) (filter.IdentifiedPaths, error) {
-	log.Printf("Zipping %v paths", len(identifiedPaths))
-	zips := make(filter.IdentifiedPaths)
+// missedDestinations returns the secondary destinations that failed since
+// clearMissedDestinations was called, when storing to more than one.
+func (r *Runner) missedDestinations() []storageclient.DestinationResult {
+	if tracker, ok := r.StorageClient.(storageclient.IMissedDestinations); ok {
+		return tracker.MissedDestinations()
+	}
+	return nil
+}
+
+func (r *Runner) clearMissedDestinations() {
+	if tracker, ok := r.StorageClient.(storageclient.IMissedDestinations); ok {
+		tracker.ClearMissedDestinations()
+	}
+}
+
+// archiveFolders zips and stores one folder at a time, so each finished folder
+// is checkpointed in the archive DB and skipped if the run has to be repeated.
+// Unless ContinueOnError is set, the first failing folder stops the run.
+func (r *Runner) archiveFolders(identifiedPaths filter.IdentifiedPaths) (*RunReport, error) {
+	log.Printf("Archiving %v paths", len(identifiedPaths))
+	report := &RunReport{}
 
 	for path, pathId := range identifiedPaths {
-		log.Printf("Zipping %v", pathId.BasePath)
-		zipPath, err := r.Zipper.ZipFilesInFolder(path)
-
-		if err != nil {
-			return nil, err
-		}
-		zips[zipPath] = filter.PathIdentifier{
-			UploadType: pathId.UploadType,
-			Id:         pathId.Id,
-			BasePath:   pathId.BasePath,
+		pathId.FolderPath = path
+
+		r.clearMissedDestinations()
+		skipReason, err := r.archiveFolder(pathId)
+		switch {
+		case err != nil:
+			report.failed(path, err)
+			if !r.ContinueOnError {
+				r.writeReport(report)
+				return report, err
+			}
+			log.Printf("ERROR: Failed to archive %v, continuing: %v", path, err)
+		case skipReason != "":
+			log.Printf("Skipping %v as %v", pathId.BasePath, skipReason)
+			report.skipped(path, skipReason)
+		case len(r.missedDestinations()) > 0:
+			log.Printf("WARNING: %v was not stored in every destination", path)
+			report.missed(path, r.missedDestinations())
+		default:
+			report.succeeded(path)
 		}
 	}
-	return zips, nil
+
+	log.Printf("Archive report: %v", report.Summary())
+	r.writeReport(report)
+	if len(report.Failed) > 0 {
+		return report, &FailedFoldersError{Report: report}
+	}
+	return report, nil
 }
 
-func (r *Runner) handleStorage(zips filter.IdentifiedPaths) error {
-	log.Printf("handleStorage for %v zips", len(zips))
-	for path, pathIdentifier := range zips {
-		log.Printf("%v is upload type %v", pathIdentifier.BasePath, pathIdentifier.UploadType)
-		var err error
+// archiveFolder archives a single folder, returning why it was skipped if
+// there was nothing to do.
+func (r *Runner) archiveFolder(pathId filter.PathIdentifier) (string, error) {
+	pathId, err := r.applyFolderIdentity(pathId.FolderPath, pathId)
+	if err != nil {
+		return "", fmt.Errorf("failed to get identity of %v: %v", pathId.BasePath, err)
+	}
 
-		destination := r.FilterService.UploadDestination(pathIdentifier)
+	archived, err := r.isAlreadyArchived(pathId)
+	if err != nil {
+		return "", fmt.Errorf("failed to check archive state of %v: %v", pathId.BasePath, err)
+	}
+	if archived {
+		return "it has already been archived", nil
+	}
 
-		switch pathIdentifier.UploadType {
-		case filter.NewMedia:
-			err = r.StorageClient.UploadNewFile(path, destination)
-		case filter.UpdatedMedia:
-			err = r.StorageClient.ReplaceFile(path, destination)
-		}
+	pathId, err = r.addFingerprint(pathId)
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint %v: %v", pathId.BasePath, err)
+	}
+	unchanged, err := r.isUnchanged(pathId)
+	if err != nil {
+		return "", fmt.Errorf("failed to check the files of %v: %v", pathId.BasePath, err)
+	}
+	if unchanged {
+		return "its files have not changed since it was archived", nil
+	}
+	if err := r.keepVersion(pathId); err != nil {
+		return "", fmt.Errorf("failed to keep the current archive of %v: %v", pathId.BasePath, err)
+	}
+	moved, err := r.moveArchivedFolder(pathId)
+	if err != nil {
+		return "", fmt.Errorf("failed to move the archive of %v: %v", pathId.BasePath, err)
+	}
+	if moved {
+		return "", nil
+	}
 
-		if err != nil {
-			return fmt.Errorf("failed to send %v to storage: %v", path, err)
+	r.Zipper.SetMediaFileIds(pathId.MediaFileIds)
+	relativePath, err := r.libraryRelativePath(pathId.FolderPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the library path of %v: %v", pathId.BasePath, err)
+	}
+	r.Zipper.SetRelativePath(relativePath)
+	if r.StreamUploads {
+		return r.streamFolder(pathId)
+	}
+
+	zipPaths, err := r.zipFiles(pathId)
+	if err != nil {
+		return "", fmt.Errorf("failed to zip files: %v", err)
+	}
+	if len(zipPaths) == 0 {
+		return "it has no files to zip", nil
+	}
+
+	if len(zipPaths) == 1 {
+		err = r.handleStorage(zipPaths[0], pathId)
+	} else {
+		err = r.handleStorageInParts(zipPaths, pathId)
+	}
+	if err != nil {
+		for _, zipPath := range zipPaths {
+			if deleteErr := r.FileSystemOperator.DeleteFile(zipPath); deleteErr != nil {
+				log.Printf("failed to delete %v: %v", zipPath, deleteErr)
+			}
 		}
+		return "", fmt.Errorf("failed to handle storage: %v", err)
+	}
+	return "", nil
+}
+
+// writeReport writes the report as JSON to ReportFile, if one is set. A report
+// that cannot be written is logged rather than failing the run.
+func (r *Runner) writeReport(report any) {
+	if r.ReportFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		log.Printf("ERROR: Failed to marshal report: %v", err)
+		return
+	}
+	if err := r.FileSystemOperator.WriteNewFile(r.ReportFile, data); err != nil {
+		log.Printf("ERROR: Failed to write report to %v: %v", r.ReportFile, err)
+	}
+}
+
+// isAlreadyArchived checks the checkpoint left by an earlier upload of the folder.
+// A folder is archived when its destination is unchanged and no media in it is
+// newer than what was uploaded.
+func (r *Runner) isAlreadyArchived(pathId filter.PathIdentifier) (bool, error) {
+	if r.ArchivedFolderRepository == nil {
+		return false, nil
+	}
+
+	archivedFolder, err := r.ArchivedFolderRepository.ArchivedFolderByPath(pathId.FolderPath)
+	if err != nil {
+		return false, err
+	}
+	if archivedFolder == nil {
+		return false, nil
+	}
+
+	return archivedFolder.Destination == r.FilterService.UploadDestination(pathId) &&
+		!pathId.NewestMediaAt.After(archivedFolder.NewestMediaAt), nil
+}
 
-		err = r.FileSystemOperator.DeleteFile(path)
+// zipFiles returns the path of the zip, or of each part when the folder was
+// split.
+func (r *Runner) zipFiles(pathId filter.PathIdentifier) ([]string, error) {
+	log.Printf("Zipping %v", pathId.BasePath)
+	if r.Zipper.SplitsFolders() {
+		return r.Zipper.ZipFilesInParts(pathId.FolderPath)
+	}
+
+	zipPath, err := r.Zipper.ZipFilesInFolder(pathId.FolderPath)
+	if err != nil || zipPath == "" {
+		return nil, err
+	}
+	return []string{zipPath}, nil
+}
+
+func (r *Runner) handleStorage(zipPath string, pathIdentifier filter.PathIdentifier) error {
+	log.Printf("%v is upload type %v", pathIdentifier.BasePath, pathIdentifier.UploadType)
+	destination := r.FilterService.UploadDestination(pathIdentifier)
+
+	// A folder that was stored in parts has no object under its destination
+	// to replace, which storeFile falls back on.
+	err := r.storeFile(zipPath, destination, pathIdentifier.UploadType)
+	if err != nil {
+		return fmt.Errorf("failed to send %v to storage: %v", zipPath, err)
+	}
+	err = r.removeStaleObjects(destination, 0, pathIdentifier.UploadType)
+	if err != nil {
+		return err
+	}
+
+	err = r.recordArchivedFolder(zipPath, destination, pathIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to record archived folder %v: %v", pathIdentifier.BasePath, err)
+	}
+
+	err = r.FileSystemOperator.DeleteFile(zipPath)
+	if err != nil {
+		return fmt.Errorf("failed to delete %v: %v", pathIdentifier.BasePath, err)
+	}
+	log.Printf("Finished handleStorage for %v", pathIdentifier.BasePath)
+	return nil
+}
+
+// recordArchivedFolder keeps track of what was uploaded for each folder.
+// It is a no-op when running without an archive DB.
+func (r *Runner) recordArchivedFolder(zipPath string, destination string, pathIdentifier filter.PathIdentifier) error {
+	if r.ArchivedFolderRepository == nil {
+		return nil
+	}
+
+	zipInfo, err := r.FileSystemOperator.GetInfo(zipPath)
+	if err != nil {
+		return err
+	}
+	checksum, err := r.FileSystemOperator.FileChecksum(zipPath)
+	if err != nil {
+		return err
+	}
+	crc32c, err := r.FileSystemOperator.FileCRC32C(zipPath)
+	if err != nil {
+		return err
+	}
+
+	return r.saveArchivedFolder(destination, pathIdentifier,
+		storedArchive{size: zipInfo.Size(), checksum: checksum, crc32c: crc32c})
+}
+
+// storedArchive is what the archive DB records about an uploaded archive.
+type storedArchive struct {
+	size     int64
+	checksum string
+	crc32c   string
+}
+
+func (r *Runner) saveArchivedFolder(
+	destination string, pathIdentifier filter.PathIdentifier, stored storedArchive) error {
+	if r.ArchivedFolderRepository == nil {
+		return nil
+	}
+	// Without a checkpoint, the next run stores the folder again - an
+	// identical object counts as stored, so only the missed destinations change.
+	if len(r.missedDestinations()) > 0 {
+		log.Printf("Not recording %v as archived, as it is missing from a destination",
+			pathIdentifier.BasePath)
+		return nil
+	}
+
+	return r.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+		Path:          pathIdentifier.FolderPath,
+		FolderId:      pathIdentifier.Id,
+		Destination:   destination,
+		Size:          stored.size,
+		Checksum:      stored.checksum,
+		CRC32C:        stored.crc32c,
+		Fingerprint:   pathIdentifier.Fingerprint,
+		NewestMediaAt: pathIdentifier.NewestMediaAt,
+		UploadedAt:    time.Now().UTC(),
+	})
+}
+
+// streamFolder zips the folder straight into storage, so nothing is written
+// next to the music and read-only library mounts can be archived.
+func (r *Runner) streamFolder(pathIdentifier filter.PathIdentifier) (string, error) {
+	fileParts, err := r.Zipper.FilePartsToZip(pathIdentifier.FolderPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to zip files: %v", err)
+	}
+	if len(fileParts) == 0 || len(fileParts[0]) == 0 {
+		return "it has no files to zip", nil
+	}
+
+	log.Printf("Streaming %v as upload type %v", pathIdentifier.BasePath, pathIdentifier.UploadType)
+	destination := r.FilterService.UploadDestination(pathIdentifier)
+
+	var stored storedArchive
+	parts := 0
+	if len(fileParts) == 1 {
+		stored, err = r.streamArchive(pathIdentifier, fileParts[0], destination)
+	} else {
+		parts = len(fileParts)
+		stored, err = r.streamParts(pathIdentifier, fileParts, destination)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to stream %v to storage: %v", pathIdentifier.BasePath, err)
+	}
+	if err := r.removeStaleObjects(destination, parts, pathIdentifier.UploadType); err != nil {
+		return "", err
+	}
+
+	err = r.saveArchivedFolder(destination, pathIdentifier, stored)
+	if err != nil {
+		return "", fmt.Errorf("failed to record archived folder %v: %v", pathIdentifier.BasePath, err)
+	}
+	log.Printf("Finished streaming %v", pathIdentifier.BasePath)
+	return "", nil
+}
+
+// streamArchive zips fileNames straight into destination, returning the size
+// and checksums of what was stored.
+func (r *Runner) streamArchive(
+	pathIdentifier filter.PathIdentifier, fileNames []string, destination string) (storedArchive, error) {
+	// The checksums and size of the last complete write are what got stored.
+	var stored storedArchive
+	write := func(w io.Writer) error {
+		hash := sha256.New()
+		crc32cHash := crc32.New(fileutil.CRC32CTable)
+		counter := &countingWriter{}
+		err := r.Zipper.ZipFilesToWriter(
+			pathIdentifier.FolderPath, fileNames, io.MultiWriter(w, hash, crc32cHash, counter))
 		if err != nil {
-			return fmt.Errorf("failed to delete %v: %v", pathIdentifier.BasePath, err)
+			return err
+		}
+		stored = storedArchive{
+			size:     counter.written,
+			checksum: hex.EncodeToString(hash.Sum(nil)),
+			crc32c:   hex.EncodeToString(crc32cHash.Sum(nil)),
 		}
-		log.Printf("Finished handleStorage for %v", pathIdentifier.BasePath)
+		return nil
 	}
-	return nil
+
+	err := r.storeStream(write, destination, pathIdentifier.UploadType)
+	return stored, err
+}
+
+type countingWriter struct {
+	written int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.written += int64(len(p))
+	return len(p), nil
 }
@@ -3,6 +3,8 @@ package runner_test
 import (
 	"testing"
 
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -11,3 +13,8 @@ func TestRunner(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Runner Suite")
 }
+
+var _ = AfterSuite(func() {
+	Expect(testutils.RemoveTestDbs(fakeNavidromeDb, fakeArchiveRunDb)).To(BeNil())
+	Expect(testutils.RemoveFileIfExists(navidromeBackup)).To(BeNil())
+})
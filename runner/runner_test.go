@@ -1,9 +1,13 @@
 package runner_test
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -14,18 +18,25 @@ import (
 	fsoMocks "github.com/apkatsikas/archiver/fileutil/mocks"
 	"github.com/apkatsikas/archiver/filter"
 	"github.com/apkatsikas/archiver/runner"
+	storageclient "github.com/apkatsikas/archiver/storage-client"
 	storageMocks "github.com/apkatsikas/archiver/storage-client/mocks"
+	"github.com/stretchr/testify/mock"
 
 	testutils "github.com/apkatsikas/archiver/tests/test-utils"
 	"github.com/apkatsikas/archiver/zipper"
+	"github.com/klauspost/compress/zstd"
 )
 
 const (
 	fakeNavidromeDb  = "fakenavidromerunner"
 	fakeArchiveRunDb = "fakearchiverun"
 	navidromeBackup  = "navidrome-backup.sqlite"
+	// navidromeBackupObject is the compressed backup stored by scheduled runs.
+	navidromeBackupObject = navidromeBackup + ".zst"
 )
 
+var schemaMetadata = map[string]string{"navidrome-schema-version": "20240122223340"}
+
 type runTestData struct {
 	runTypeTest
 	hueyTimeDiff timeDiff
@@ -73,6 +84,27 @@ var _ = DescribeTableSubtree("Runner when there is a prior run",
 			Expect(testutils.FileExists(artistPathZips.mc5PathZip)).To(
 				BeFalse(), "Did not expect file to exist %v", artistPathZips.mc5PathZip)
 		})
+
+		It("Records the archived folders", func() {
+			archivedFolders, err := runner.ArchivedFolderRepository.AllArchivedFolders()
+			Expect(err).To(BeNil())
+
+			if testData.runTypeTest == NoOp {
+				Expect(archivedFolders).To(BeEmpty())
+				return
+			}
+			var destinations []string
+			for _, archivedFolder := range archivedFolders {
+				destinations = append(destinations, archivedFolder.Destination)
+				Expect(archivedFolder.Size).To(BeNumerically(">", 0))
+				Expect(archivedFolder.Checksum).To(HaveLen(64))
+				Expect(archivedFolder.CRC32C).To(HaveLen(8))
+				Expect(archivedFolder.NewestMediaAt).To(BeTemporally(">", time.Date(2024, time.January, 12, 13, 19, 51, 0, time.UTC)))
+			}
+			Expect(destinations).To(ConsistOf(
+				"huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip",
+				"mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip"))
+		})
 	},
 	Entry("Upload only", runTestData{
 		hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
@@ -127,177 +159,2226 @@ var _ = Describe("Runner when there is not a prior run", func() {
 	})
 })
 
-func setup(runner *runner.Runner, testData runTestData) *artistPathZips {
-	var lastRun = time.Date(2024, time.January, 12, 13, 19, 51, 0, time.UTC)
-	const hueyPath = "tests/fixtures/huey lewis - sports"
-	const mc5Path = "tests/fixtures/mc5 - back in the usa"
-
-	GinkgoHelper()
-	gt := GinkgoT()
-
-	By("Setting up FileSystemOperator")
-	fso := &fileutil.FileSystemOperator{}
-
-	runner.FileSystemOperator = fso
-	By("Get working directory")
-	testDir, err := os.Getwd()
-	Expect(err).To(BeNil(), "Got an error getting working directory")
-	archiverDir := filepath.Join(testDir, "..")
+var _ = Describe("Runner when resuming an interrupted run", func() {
+	var runner = &runner.Runner{}
+	var err error
+	var artistPathZips *artistPathZips
 
-	By("Deleting prior artifacts")
-	hueyPathZip := deletePriorArtifacts(hueyPath, archiverDir)
-	mc5PathZip := deletePriorArtifacts(mc5Path, archiverDir)
+	BeforeEach(func() {
+		artistPathZips = setup(runner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
 
-	By("Delete prior backup")
-	err = testutils.RemoveFileIfExists(navidromeBackup)
-	Expect(err).To(BeNil(), "Got an error trying to remove backup file")
+		By("Checkpointing the huey lewis folder as already archived")
+		Expect(runner.ArchivedFolderRepository.CreateTable()).To(BeNil())
+		Expect(runner.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+			Path:          strings.TrimSuffix(artistPathZips.hueyPathZip, ".zip"),
+			Destination:   "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip",
+			Size:          1,
+			Checksum:      "checksum",
+			NewestMediaAt: time.Now().UTC(),
+			UploadedAt:    time.Now().UTC(),
+		})).To(BeNil())
 
-	By("Setting up MusicFoldersRepository")
-	fakeNavidromeDbFullPath := setupNavidromeRepositories(runner)
+		By("Expecting to only upload the mc5 folder")
+		mockStorageClient := storageMocks.NewIStorageClient(GinkgoT())
+		mockStorageClient.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil).Once()
+		mockStorageClient.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil).Once()
+		mockStorageClient.EXPECT().UploadNewFile(
+			artistPathZips.mc5PathZip, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip").Return(nil).Once()
+		runner.StorageClient = mockStorageClient
 
-	By("Setting up ArchiveRunRepository")
-	var fakeArchiveRunDbFullPath = ""
-	if testData.priorRun {
-		fakeArchiveRunDbFullPath, err = testutils.SetupTestDb(fakeArchiveRunDb)
-		Expect(err).To(BeNil(), "Error trying to setup fakenavidrome DB")
+		err = runner.RunScheduled()
+	})
 
-	} else {
-		dir, err := os.Getwd()
-		Expect(err).To(BeNil(), "Got an error getting working directory")
-		// If we want to parallelize these, we can use a random string when creating the DB
-		// and delete it after the test run. As of now, they operate on the same file - could collide on
-		// a parallel run
-		fixturesPath := "tests/fixtures"
-		testDbPath := fmt.Sprintf("%v/%v.db", fixturesPath, fakeArchiveRunDb)
-		testDbFullPath := filepath.Join(dir, "..", testDbPath)
+	It("Runs without error", func() {
+		Expect(err).To(BeNil())
+	})
 
-		Expect(testutils.RemoveFileIfExists(testDbFullPath)).To(BeNil(), "Got an error trying to remove %v", testDbFullPath)
-	}
-	runner.ArchiveRunRepository = &db.ArchiveRunRepository{SqliteHandler: &db.SQLiteHandler{}}
-	Expect(runner.ArchiveRunRepository.SqliteHandler.ConnectSQLite(fakeArchiveRunDbFullPath)).To(
-		BeNil(), "Failed to connect to sqlite for ArchiveRunRepository")
+	It("Does not zip the checkpointed folder", func() {
+		Expect(testutils.FileExists(artistPathZips.hueyPathZip)).To(
+			BeFalse(), "Did not expect file to exist %v", artistPathZips.hueyPathZip)
+	})
 
-	By("Setting up Zipper")
-	runner.Zipper = &zipper.Zipper{FileSystemOperator: fso}
+	It("Keeps the checkpoint of the skipped folder", func() {
+		archivedFolder, err := runner.ArchivedFolderRepository.ArchivedFolderByPath(
+			strings.TrimSuffix(artistPathZips.hueyPathZip, ".zip"))
+		Expect(err).To(BeNil())
+		Expect(archivedFolder.Checksum).To(Equal("checksum"))
+	})
+})
 
-	By("Setting up FilterService")
-	runner.FilterService = &filter.FilterService{}
+var _ = Describe("Runner when a folder fails", func() {
+	var archiveRunner = &runner.Runner{}
+	var err error
+	var artistPathZips *artistPathZips
+	var reportFile string
 
-	By("Setting up StorageClient")
-	mockStorageClient := storageMocks.NewIStorageClient(gt)
+	setupFailingUpload := func(continueOnError bool) {
+		artistPathZips = setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+		reportFile = filepath.Join(GinkgoT().TempDir(), "report.json")
+		archiveRunner.ContinueOnError = continueOnError
+		archiveRunner.ReportFile = reportFile
 
-	if testData.priorRun {
-		switch td := testData.runTypeTest; td {
-		case Upload:
-			By("Expecting to upload 2 new files")
-			mockStorageClient.EXPECT().ReplaceFile(navidromeBackup, navidromeBackup).Return(nil).Once()
-			mockStorageClient.EXPECT().UploadNewFile(
-				hueyPathZip, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip").Return(nil).Once()
+		By("Expecting the huey lewis upload to fail")
+		mockStorageClient := storageMocks.NewIStorageClient(GinkgoT())
+		mockStorageClient.EXPECT().UploadNewFile(
+			artistPathZips.hueyPathZip, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip").
+			Return(fmt.Errorf("bucket unavailable")).Once()
+		if continueOnError {
 			mockStorageClient.EXPECT().UploadNewFile(
-				mc5PathZip, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip").Return(nil).Once()
-		case Replace:
-			By("Expecting to replace 2 files")
-			mockStorageClient.EXPECT().ReplaceFile(navidromeBackup, navidromeBackup).Return(nil).Once()
-			mockStorageClient.EXPECT().ReplaceFile(
-				hueyPathZip, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip").Return(nil).Once()
-			mockStorageClient.EXPECT().ReplaceFile(
-				mc5PathZip, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip").Return(nil).Once()
-		case Both:
-			By("Expecting to upload 1 new file and replace 1 file")
-			mockStorageClient.EXPECT().ReplaceFile(navidromeBackup, navidromeBackup).Return(nil).Once()
+				artistPathZips.mc5PathZip, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip").Return(nil).Once()
+			mockStorageClient.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil).Once()
+			mockStorageClient.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil).Once()
+		} else {
 			mockStorageClient.EXPECT().UploadNewFile(
-				hueyPathZip, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip").Return(nil).Once()
-			mockStorageClient.EXPECT().ReplaceFile(
-				mc5PathZip, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip").Return(nil).Once()
-		case NoOp:
-			By("Expecting to do nothing with storage")
+				artistPathZips.mc5PathZip, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip").Return(nil).Maybe()
 		}
-		runner.StorageClient = mockStorageClient
+		archiveRunner.StorageClient = mockStorageClient
+
+		err = archiveRunner.RunScheduled()
 	}
 
-	By("Setting up media file record create and update dates")
-	hueySongPath := "tests/fixtures/huey lewis - sports/hue lou.mp3"
-	Expect(
-		testutils.UpdateMediaFileRecordCreatedAndUpdatedAt(
-			fakeNavidromeDbFullPath, testData.hueyTimeDiff.createdDiff, testData.hueyTimeDiff.updatedDiff, hueySongPath, lastRun)).
-		To(BeNil(), "Failed to update record %v", hueyPath)
-	mc5SongPath := "tests/fixtures/mc5 - back in the usa/tutti fruitti.mp3"
-	Expect(
-		testutils.UpdateMediaFileRecordCreatedAndUpdatedAt(
-			fakeNavidromeDbFullPath, testData.mc5TimeDiff.createdDiff, testData.mc5TimeDiff.updatedDiff, mc5SongPath, lastRun)).
-		To(BeNil(), "Failed to update record %v", mc5Path)
+	Context("When continuing on error", func() {
+		BeforeEach(func() {
+			setupFailingUpload(true)
+		})
 
-	By("Setting up last run date")
-	if testData.priorRun {
-		Expect(testutils.UpdateLastRunRecord(fakeArchiveRunDbFullPath, lastRun)).To(BeNil(), "Failed to update archive_run")
-	}
-	By("Setting up media file record paths")
-	testutils.UpdateMediaFilePathPrefix(fakeNavidromeDbFullPath, archiverDir, hueySongPath)
-	testutils.UpdateMediaFilePathPrefix(fakeNavidromeDbFullPath, archiverDir, mc5SongPath)
-	return &artistPathZips{hueyPathZip, mc5PathZip}
-}
+		It("Returns a report of the failed folders", func() {
+			var failedFolders *runner.FailedFoldersError
+			Expect(errors.As(err, &failedFolders)).To(BeTrue())
+			Expect(failedFolders.Report.Succeeded).To(HaveLen(1))
+			Expect(failedFolders.Report.Failed).To(HaveLen(1))
+			Expect(failedFolders.Report.Failed[0].Reason).To(ContainSubstring("bucket unavailable"))
+			Expect(failedFolders.Report.Summary()).To(HavePrefix("1 folders succeeded, 0 skipped, 1 failed"))
+		})
 
-var _ = Describe("BuildLedger", func() {
-	var runn *runner.Runner
-	const filePath = "/path/to/file.json"
-	var expectedBytes = []byte{
-		0x7b, 0xa, 0x20, 0x20, 0x20, 0x20, 0x22, 0x2f, 0x6c, 0x69, 0x62, 0x2f, 0x70, 0x61, 0x74, 0x68, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x73, 0x2f, 0x66, 0x69, 0x78, 0x74, 0x75, 0x72, 0x65, 0x73, 0x2f, 0x68, 0x75, 0x65, 0x79, 0x20, 0x6c, 0x65, 0x77, 0x69, 0x73, 0x20, 0x2d, 0x20, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x22, 0x3a, 0x20, 0x7b, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x54, 0x79, 0x70, 0x65, 0x22, 0x3a, 0x20, 0x30, 0x2c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x49, 0x64, 0x22, 0x3a, 0x20, 0x22, 0x35, 0x63, 0x32, 0x31, 0x34, 0x64, 0x65, 0x62, 0x35, 0x62, 0x32, 0x64, 0x62, 0x61, 0x37, 0x33, 0x39, 0x65, 0x30, 0x64, 0x36, 0x61, 0x66, 0x35, 0x36, 0x66, 0x36, 0x31, 0x64, 0x31, 0x63, 0x37, 0x22, 0x2c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x42, 0x61, 0x73, 0x65, 0x50, 0x61, 0x74, 0x68, 0x22, 0x3a, 0x20, 0x22, 0x68, 0x75, 0x65, 0x79, 0x20, 0x6c, 0x65, 0x77, 0x69, 0x73, 0x20, 0x2d, 0x20, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x22, 0xa, 0x20, 0x20, 0x20, 0x20, 0x7d, 0x2c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x22, 0x2f, 0x6c, 0x69, 0x62, 0x2f, 0x70, 0x61, 0x74, 0x68, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x73, 0x2f, 0x66, 0x69, 0x78, 0x74, 0x75, 0x72, 0x65, 0x73, 0x2f, 0x6d, 0x63, 0x35, 0x20, 0x2d, 0x20, 0x62, 0x61, 0x63, 0x6b, 0x20, 0x69, 0x6e, 0x20, 0x74, 0x68, 0x65, 0x20, 0x75, 0x73, 0x61, 0x22, 0x3a, 0x20, 0x7b, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x54, 0x79, 0x70, 0x65, 0x22, 0x3a, 0x20, 0x30, 0x2c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x49, 0x64, 0x22, 0x3a, 0x20, 0x22, 0x36, 0x65, 0x61, 0x35, 0x61, 0x32, 0x62, 0x61, 0x61, 0x33, 0x32, 0x38, 0x34, 0x32, 0x31, 0x30, 0x39, 0x39, 0x32, 0x35, 0x66, 0x36, 0x37, 0x62, 0x33, 0x31, 0x35, 0x31, 0x66, 0x62, 0x38, 0x30, 0x22, 0x2c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x42, 0x61, 0x73, 0x65, 0x50, 0x61, 0x74, 0x68, 0x22, 0x3a, 0x20, 0x22, 0x6d, 0x63, 0x35, 0x20, 0x2d, 0x20, 0x62, 0x61, 0x63, 0x6b, 0x20, 0x69, 0x6e, 0x20, 0x74, 0x68, 0x65, 0x20, 0x75, 0x73, 0x61, 0x22, 0xa, 0x20, 0x20, 0x20, 0x20, 0x7d, 0xa, 0x7d,
+		It("Writes the report file", func() {
+			data, err := os.ReadFile(reportFile)
+			Expect(err).To(BeNil())
+			var report runner.RunReport
+			Expect(json.Unmarshal(data, &report)).To(BeNil())
+			Expect(report.Succeeded[0].Folder).To(HaveSuffix("mc5 - back in the usa"))
+			Expect(report.Failed[0].Folder).To(HaveSuffix("huey lewis - sports"))
+		})
+
+		It("Does not leave behind a zip file", func() {
+			Expect(testutils.FileExists(artistPathZips.hueyPathZip)).To(BeFalse())
+			Expect(testutils.FileExists(artistPathZips.mc5PathZip)).To(BeFalse())
+		})
+
+		It("Does not update the last run, so the failed folder is retried", func() {
+			lastRun, err := archiveRunner.ArchiveRunRepository.LastRun()
+			Expect(err).To(BeNil())
+			Expect(lastRun.LastRun).To(Equal(time.Date(2024, time.January, 12, 13, 19, 51, 0, time.UTC)))
+		})
+	})
+
+	Context("When stopping on error", func() {
+		BeforeEach(func() {
+			setupFailingUpload(false)
+		})
+
+		It("Returns the folder error", func() {
+			var failedFolders *runner.FailedFoldersError
+			Expect(err).To(Not(BeNil()))
+			Expect(errors.As(err, &failedFolders)).To(BeFalse())
+			Expect(err.Error()).To(ContainSubstring("bucket unavailable"))
+		})
+
+		It("Does not leave behind a zip file", func() {
+			Expect(testutils.FileExists(artistPathZips.hueyPathZip)).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Runner when an archive cannot be replaced", func() {
+	var err error
+
+	BeforeEach(func() {
+		archiveRunner := &runner.Runner{}
+		artistPathZips := setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: -10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: -10, updatedDiff: -10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+		archiveRunner.ContinueOnError = true
+
+		By("Failing the replace for a reason other than the archive being missing")
+		mockStorageClient := storageMocks.NewIStorageClient(GinkgoT())
+		mockStorageClient.EXPECT().ReplaceFile(
+			artistPathZips.hueyPathZip, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip").
+			Return(fmt.Errorf("object changed since it was read")).Once()
+		mockStorageClient.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil).Once()
+		mockStorageClient.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil).Once()
+		archiveRunner.StorageClient = mockStorageClient
+
+		err = archiveRunner.RunScheduled()
+	})
+
+	It("Fails the folder with the replace error, without uploading it as new", func() {
+		var failedFolders *runner.FailedFoldersError
+		Expect(errors.As(err, &failedFolders)).To(BeTrue())
+		Expect(failedFolders.Report.Failed).To(HaveLen(1))
+		Expect(failedFolders.Report.Failed[0].Reason).To(ContainSubstring("object changed since it was read"))
+	})
+})
+
+var _ = Describe("Runner when retrying failed folders", func() {
+	const hueyObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip"
+	var archiveRunner *runner.Runner
+	var artistPathZips *artistPathZips
+	var err error
+
+	runFailingHuey := func() error {
+		mockStorageClient := storageMocks.NewIStorageClient(GinkgoT())
+		mockStorageClient.EXPECT().UploadNewFile(artistPathZips.hueyPathZip, hueyObject).
+			Return(fmt.Errorf("bucket unavailable")).Once()
+		mockStorageClient.EXPECT().UploadNewFile(
+			artistPathZips.mc5PathZip, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip").Return(nil).Maybe()
+		mockStorageClient.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil).Once()
+		mockStorageClient.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil).Once()
+		archiveRunner.StorageClient = mockStorageClient
+		return archiveRunner.RunScheduled()
 	}
 
 	BeforeEach(func() {
-		gt := GinkgoT()
-		By("Setting up FileSystemOperator")
-		mockFileSystemOperator := fsoMocks.NewIFileSystemOperator(gt)
-		mockFileSystemOperator.EXPECT().WriteNewFile(filePath, expectedBytes).Return(nil).Once()
+		archiveRunner = &runner.Runner{}
+		artistPathZips = setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+		archiveRunner.ContinueOnError = true
+		archiveRunner.FolderRetryRepository = &db.FolderRetryRepository{
+			SqliteHandler: archiveRunner.ArchivedFolderRepository.SqliteHandler}
 
-		By("Setting up Runner")
-		runn = &runner.Runner{FileSystemOperator: mockFileSystemOperator}
+		By("Failing to upload huey lewis")
+		err = runFailingHuey()
+	})
 
-		By("Setting up MusicFoldersRepository")
-		setupNavidromeRepositories(runn)
+	It("Returns a report of the failed folders", func() {
+		var failedFolders *runner.FailedFoldersError
+		Expect(errors.As(err, &failedFolders)).To(BeTrue())
+		Expect(failedFolders.Report.Failed).To(HaveLen(1))
 	})
 
-	It("Runs without error", func() {
-		Expect(runn.BuildLedger(filePath)).To(BeNil())
+	It("Updates the last run", func() {
+		lastRun, err := archiveRunner.ArchiveRunRepository.LastRun()
+		Expect(err).To(BeNil())
+		Expect(lastRun.LastRun).To(BeTemporally("~", time.Now(), time.Minute))
+	})
+
+	It("Records the failed folder to be retried", func() {
+		retries, err := archiveRunner.FolderRetryRepository.AllFolderRetries()
+		Expect(err).To(BeNil())
+		Expect(retries).To(HaveLen(1))
+		Expect(retries[0].Path).To(HaveSuffix("huey lewis - sports"))
+		Expect(retries[0].Attempts).To(Equal(1))
+		Expect(retries[0].Reason).To(ContainSubstring("bucket unavailable"))
+	})
+
+	Context("When the next run archives the folder", func() {
+		BeforeEach(func() {
+			mockStorageClient := storageMocks.NewIStorageClient(GinkgoT())
+			mockStorageClient.EXPECT().UploadNewFile(artistPathZips.hueyPathZip, hueyObject).Return(nil).Once()
+			mockStorageClient.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil).Once()
+			mockStorageClient.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil).Once()
+			archiveRunner.StorageClient = mockStorageClient
+			err = archiveRunner.RunScheduled()
+		})
+
+		It("Retries the folder, even though its media has not changed since the last run", func() {
+			Expect(err).To(BeNil())
+		})
+
+		It("Forgets the retry", func() {
+			Expect(archiveRunner.FolderRetryRepository.AllFolderRetries()).To(BeEmpty())
+		})
+	})
+
+	Context("When the folder keeps failing", func() {
+		BeforeEach(func() {
+			retries, retriesErr := archiveRunner.FolderRetryRepository.AllFolderRetries()
+			Expect(retriesErr).To(BeNil())
+			retry := retries[0]
+			retry.Attempts = 4
+			Expect(archiveRunner.FolderRetryRepository.SaveFolderRetry(retry)).To(BeNil())
+
+			err = runFailingHuey()
+		})
+
+		It("Gives up on the folder", func() {
+			var failedFolders *runner.FailedFoldersError
+			Expect(errors.As(err, &failedFolders)).To(BeTrue())
+			Expect(failedFolders.Report.Failed[0].Reason).To(HaveSuffix("giving up after 5 failed attempts"))
+			Expect(archiveRunner.FolderRetryRepository.AllFolderRetries()).To(BeEmpty())
+		})
 	})
 })
 
-var _ = Describe("RunBatch", func() {
-	const hueyPath = "tests/fixtures/huey lewis - sports"
-	const mc5Path = "tests/fixtures/mc5 - back in the usa"
+var _ = Describe("Runner when retrying a folder whose updated media failed to replace its archive", func() {
+	const hueyObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip"
+	var archiveRunner *runner.Runner
+	var artistPathZips *artistPathZips
+	var err error
 
-	var runn *runner.Runner
+	BeforeEach(func() {
+		archiveRunner = &runner.Runner{}
+		artistPathZips = setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: -10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: -10, updatedDiff: -10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+		archiveRunner.ContinueOnError = true
+		archiveRunner.FolderRetryRepository = &db.FolderRetryRepository{
+			SqliteHandler: archiveRunner.ArchivedFolderRepository.SqliteHandler}
 
-	var archiveDir = ""
+		By("Recording huey lewis as archived before its media was updated")
+		Expect(archiveRunner.ArchivedFolderRepository.CreateTable()).To(BeNil())
+		hueyFolder := strings.TrimSuffix(artistPathZips.hueyPathZip, ".zip")
+		archivedAt := time.Date(2024, time.January, 12, 13, 0, 0, 0, time.UTC)
+		Expect(archiveRunner.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+			Path:          hueyFolder,
+			FolderId:      "5c214deb5b2dba739e0d6af56f61d1c7",
+			Destination:   hueyObject,
+			NewestMediaAt: archivedAt,
+			UploadedAt:    archivedAt,
+		})).To(BeNil())
+
+		By("Failing to replace the archive of huey lewis")
+		mockStorageClient := storageMocks.NewIStorageClient(GinkgoT())
+		mockStorageClient.EXPECT().ReplaceFile(artistPathZips.hueyPathZip, hueyObject).
+			Return(fmt.Errorf("bucket unavailable")).Once()
+		mockStorageClient.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil).Once()
+		mockStorageClient.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil).Once()
+		archiveRunner.StorageClient = mockStorageClient
+		var failedFolders *runner.FailedFoldersError
+		Expect(errors.As(archiveRunner.RunScheduled(), &failedFolders)).To(BeTrue())
+
+		By("Retrying it on the next run")
+		mockStorageClient = storageMocks.NewIStorageClient(GinkgoT())
+		mockStorageClient.EXPECT().ReplaceFile(artistPathZips.hueyPathZip, hueyObject).Return(nil).Once()
+		expectOnlyObject(mockStorageClient, hueyObject)
+		mockStorageClient.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil).Once()
+		mockStorageClient.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil).Once()
+		archiveRunner.StorageClient = mockStorageClient
+		err = archiveRunner.RunScheduled()
+	})
+
+	It("Replaces the archive with the updated media", func() {
+		Expect(err).To(BeNil())
+		Expect(archiveRunner.FolderRetryRepository.AllFolderRetries()).To(BeEmpty())
+	})
+
+	It("Records the newest media time of the updated media", func() {
+		archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(
+			strings.TrimSuffix(artistPathZips.hueyPathZip, ".zip"))
+		Expect(err).To(BeNil())
+		Expect(archivedFolder.NewestMediaAt).To(Equal(time.Date(2024, time.January, 12, 13, 29, 51, 0, time.UTC)))
+	})
+})
+
+var _ = Describe("Runner when a secondary destination fails", func() {
+	const hueyObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip"
+	const mc5Object = "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip"
+	var archiveRunner *runner.Runner
+	var artistPathZips *artistPathZips
+	var storagePath string
+	var secondaryDown bool
+	var err error
 
 	BeforeEach(func() {
-		gt := GinkgoT()
-		By("Setting up FileSystemOperator")
-		fso := &fileutil.FileSystemOperator{}
+		archiveRunner = &runner.Runner{}
+		artistPathZips = setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+		archiveRunner.ContinueOnError = true
+		archiveRunner.FolderRetryRepository = &db.FolderRetryRepository{
+			SqliteHandler: archiveRunner.ArchivedFolderRepository.SqliteHandler}
 
-		By("Setting up Runner")
-		runn = &runner.Runner{FileSystemOperator: fso, Zipper: &zipper.Zipper{FileSystemOperator: fso}}
+		By("Storing to a local directory and a secondary that cannot store huey lewis")
+		storagePath = GinkgoT().TempDir()
+		GinkgoT().Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+		secondaryDown = true
+		secondary := storageMocks.NewIStorageClient(GinkgoT())
+		secondary.EXPECT().UploadNewFile(artistPathZips.hueyPathZip, hueyObject).RunAndReturn(
+			func(string, string) error {
+				if secondaryDown {
+					return fmt.Errorf("secondary unavailable")
+				}
+				return nil
+			})
+		secondary.EXPECT().UploadNewFile(artistPathZips.mc5PathZip, mc5Object).Return(nil).Once()
+		secondary.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil)
+		secondary.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil)
+		archiveRunner.StorageClient = storageclient.NewMulti(
+			storageclient.Destination{Name: "filesystem", Client: storageclient.NewFileSystem(), Required: true},
+			storageclient.Destination{Name: "secondary", Client: secondary},
+		)
 
-		By("Get working directory")
-		testDir, err := os.Getwd()
-		Expect(err).To(BeNil(), "Got an error getting working directory")
-		archiveDir = filepath.Join(testDir, "..")
+		err = archiveRunner.RunScheduled()
+	})
 
-		By("Deleting prior artifacts")
-		deletePriorArtifacts(hueyPath, archiveDir)
-		deletePriorArtifacts(mc5Path, archiveDir)
+	It("Reports the folder with the destination it missed", func() {
+		var failedFolders *runner.FailedFoldersError
+		Expect(errors.As(err, &failedFolders)).To(BeTrue())
+		Expect(failedFolders.Report.Succeeded).To(HaveLen(1))
+		Expect(failedFolders.Report.Failed).To(HaveLen(1))
+		Expect(failedFolders.Report.Failed[0].Folder).To(HaveSuffix("huey lewis - sports"))
+		Expect(failedFolders.Report.Failed[0].MissedDestinations).To(Equal([]string{"secondary"}))
+		Expect(failedFolders.Report.Summary()).To(ContainSubstring("secondary unavailable"))
+	})
 
-		By("Setting up Storage")
-		storage := storageMocks.NewIStorageClient(gt)
-		storage.EXPECT().UploadNewFile(
-			"../tests/fixtures/huey lewis - sports.zip", "huey lewis - sports37141ae2932c8e06cc3716c3b9c55a48.zip").Return(nil).Once()
-		storage.EXPECT().UploadNewFile(
-			"../tests/fixtures/mc5 - back in the usa.zip", "mc5 - back in the usa07bb5aec148d087b0192c538721d0627.zip").Return(nil).Once()
-		runn.StorageClient = storage
+	It("Stores the folder in the required destination without recording it as archived", func() {
+		Expect(filepath.Join(storagePath, hueyObject)).To(BeAnExistingFile())
+		archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(
+			filepath.Join(filepath.Dir(artistPathZips.hueyPathZip), "huey lewis - sports"))
+		Expect(err).To(BeNil())
+		Expect(archivedFolder).To(BeNil())
+
+		archivedFolder, err = archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(
+			filepath.Join(filepath.Dir(artistPathZips.mc5PathZip), "mc5 - back in the usa"))
+		Expect(err).To(BeNil())
+		Expect(archivedFolder).To(Not(BeNil()))
 	})
 
-	It("Runs without error", func() {
-		Expect(runn.RunBatch(filepath.Join(archiveDir, "tests/fixtures/testledger.json"))).To(BeNil())
+	It("Records the folder to be retried", func() {
+		retries, err := archiveRunner.FolderRetryRepository.AllFolderRetries()
+		Expect(err).To(BeNil())
+		Expect(retries).To(HaveLen(1))
+		Expect(retries[0].Path).To(HaveSuffix("huey lewis - sports"))
+	})
+
+	Context("When the secondary is back on the next run", func() {
+		BeforeEach(func() {
+			secondaryDown = false
+			err = archiveRunner.RunScheduled()
+		})
+
+		It("Stores the folder in the secondary, keeping the identical object in the required destination", func() {
+			Expect(err).To(BeNil())
+			Expect(archiveRunner.FolderRetryRepository.AllFolderRetries()).To(BeEmpty())
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(
+				filepath.Join(filepath.Dir(artistPathZips.hueyPathZip), "huey lewis - sports"))
+			Expect(err).To(BeNil())
+			Expect(archivedFolder).To(Not(BeNil()))
+		})
+	})
+})
+
+var _ = Describe("Runner when streaming uploads", func() {
+	var archiveRunner = &runner.Runner{}
+	var err error
+	var artistPathZips *artistPathZips
+	var storagePath string
+
+	BeforeEach(func() {
+		artistPathZips = setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+
+		By("Storing to a local directory")
+		storagePath = GinkgoT().TempDir()
+		GinkgoT().Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+		archiveRunner.StorageClient = storageclient.NewFileSystem()
+		archiveRunner.StreamUploads = true
+
+		By("Putting the library root above the fixtures")
+		testDir, err := os.Getwd()
+		Expect(err).To(BeNil())
+		_, err = archiveRunner.LibraryRepository.ReplacePathRoot("/lib/path", filepath.Dir(testDir))
+		Expect(err).To(BeNil())
+
+		err = archiveRunner.RunScheduled()
+	})
+
+	AfterEach(func() {
+		archiveRunner.StreamUploads = false
+	})
+
+	It("Runs without error", func() {
+		Expect(err).To(BeNil())
+	})
+
+	It("Does not write a zip next to the folders", func() {
+		Expect(testutils.FileExists(artistPathZips.hueyPathZip)).To(BeFalse())
+		Expect(testutils.FileExists(artistPathZips.mc5PathZip)).To(BeFalse())
+	})
+
+	It("Stores the zips and records their checksums", func() {
+		archivedFolders, err := archiveRunner.ArchivedFolderRepository.AllArchivedFolders()
+		Expect(err).To(BeNil())
+		Expect(archivedFolders).To(HaveLen(2))
+
+		fso := &fileutil.FileSystemOperator{}
+		for _, archivedFolder := range archivedFolders {
+			storedZip := filepath.Join(storagePath, archivedFolder.Destination)
+			Expect(fso.FileChecksum(storedZip)).To(Equal(archivedFolder.Checksum))
+			Expect(fso.FileCRC32C(storedZip)).To(Equal(archivedFolder.CRC32C))
+			info, err := os.Stat(storedZip)
+			Expect(err).To(BeNil())
+			Expect(info.Size()).To(Equal(archivedFolder.Size))
+		}
+	})
+
+	It("Embeds a manifest listing the media files of each folder", func() {
+		manifest, err := archiveRunner.Zipper.VerifyArchive(
+			filepath.Join(storagePath, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip"))
+		Expect(err).To(BeNil())
+		Expect(manifest.MediaFileIds).To(Equal([]string{"5c214deb5b2dba739e0d6af56f61d1c7"}))
+		Expect(manifest.Path).To(Equal("tests/fixtures/huey lewis - sports"))
+	})
+})
+
+var _ = DescribeTableSubtree("Runner when splitting folders into parts",
+	func(streamUploads bool) {
+		const hueyObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7"
+
+		var archiveRunner = &runner.Runner{}
+		var err error
+		var storagePath string
+		var hueyFolder string
+
+		BeforeEach(func() {
+			artistPathZips := setup(archiveRunner, runTestData{
+				hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+				mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+				runTypeTest:  NoOp,
+				priorRun:     true,
+			})
+
+			By("Storing to a local directory")
+			storagePath = GinkgoT().TempDir()
+			GinkgoT().Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+			archiveRunner.StorageClient = storageclient.NewFileSystem()
+			archiveRunner.StreamUploads = streamUploads
+
+			By("Allowing one file per part")
+			archiveRunner.Zipper.SetPartLimits(0, 1)
+
+			err = archiveRunner.RunScheduled()
+			hueyFolder = strings.TrimSuffix(artistPathZips.hueyPathZip, ".zip")
+		})
+
+		AfterEach(func() {
+			archiveRunner.StreamUploads = false
+		})
+
+		It("Runs without error", func() {
+			Expect(err).To(BeNil())
+		})
+
+		It("Stores the parts followed by a manifest", func() {
+			Expect(filepath.Join(storagePath, hueyObject+"-part1.zip")).To(BeAnExistingFile())
+			Expect(filepath.Join(storagePath, hueyObject+"-part2.zip")).To(BeAnExistingFile())
+			Expect(filepath.Join(storagePath, hueyObject+".zip")).To(Not(BeAnExistingFile()))
+
+			data, err := os.ReadFile(filepath.Join(storagePath, hueyObject+".parts.json"))
+			Expect(err).To(BeNil())
+			var manifest runner.PartsManifest
+			Expect(json.Unmarshal(data, &manifest)).To(BeNil())
+			Expect(manifest.Folder).To(Equal("huey lewis - sports"))
+			Expect(manifest.Parts).To(HaveLen(2))
+
+			fso := &fileutil.FileSystemOperator{}
+			for _, part := range manifest.Parts {
+				Expect(fso.FileChecksum(filepath.Join(storagePath, part.Object))).To(Equal(part.Checksum))
+			}
+		})
+
+		It("Records the folders under their whole destinations", func() {
+			archivedFolders, err := archiveRunner.ArchivedFolderRepository.AllArchivedFolders()
+			Expect(err).To(BeNil())
+			fso := &fileutil.FileSystemOperator{}
+			var destinations []string
+			for _, archivedFolder := range archivedFolders {
+				destinations = append(destinations, archivedFolder.Destination)
+				if archivedFolder.Destination == hueyObject+".zip" {
+					By("Recording the CRC32C of the manifest, which covers every part")
+					Expect(fso.FileCRC32C(filepath.Join(storagePath, hueyObject+".parts.json"))).To(
+						Equal(archivedFolder.CRC32C))
+				}
+			}
+			Expect(destinations).To(ConsistOf(
+				hueyObject+".zip", "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip"))
+		})
+
+		It("Restores every part of the folder", func() {
+			targetDir := GinkgoT().TempDir()
+			Expect(archiveRunner.RunRestore(targetDir, hueyObject+"-part2*")).To(BeNil())
+			Expect(filepath.Join(targetDir, "huey lewis - sports", "hue lou.mp3")).To(BeAnExistingFile())
+			Expect(filepath.Join(targetDir, "huey lewis - sports", "cover.jpg")).To(BeAnExistingFile())
+		})
+
+		It("Fails to restore a folder with a missing part", func() {
+			Expect(os.Remove(filepath.Join(storagePath, hueyObject+"-part1.zip"))).To(BeNil())
+			restoreErr := archiveRunner.RunRestore(GinkgoT().TempDir(), hueyObject+"*")
+			Expect(restoreErr).To(MatchError(ContainSubstring("missing 1 of 2 parts")))
+		})
+
+		Context("When the folder is archived again as updated media", func() {
+			storedHueyObjects := func() []string {
+				entries, err := os.ReadDir(storagePath)
+				Expect(err).To(BeNil())
+				var objects []string
+				for _, entry := range entries {
+					if strings.HasPrefix(entry.Name(), hueyObject) {
+						objects = append(objects, entry.Name())
+					}
+				}
+				return objects
+			}
+
+			archiveHueyAgain := func() {
+				GinkgoHelper()
+				Expect(archiveRunner.ArchivedFolderRepository.DeleteArchivedFolder(hueyFolder)).To(BeNil())
+				batch, marshalErr := json.Marshal(filter.IdentifiedPaths{hueyFolder: filter.PathIdentifier{
+					UploadType: filter.UpdatedMedia,
+					Id:         "5c214deb5b2dba739e0d6af56f61d1c7",
+					BasePath:   "huey lewis - sports",
+				}})
+				Expect(marshalErr).To(BeNil())
+				batchFile := filepath.Join(GinkgoT().TempDir(), "batch.json")
+				Expect(os.WriteFile(batchFile, batch, 0644)).To(BeNil())
+				Expect(archiveRunner.RunBatch(batchFile)).To(BeNil())
+			}
+
+			BeforeEach(func() {
+				By("Leaving a whole archive and a third part from earlier archives")
+				for _, object := range []string{hueyObject + ".zip", hueyObject + "-part3.zip"} {
+					Expect(os.WriteFile(filepath.Join(storagePath, object), []byte("stale"), 0644)).To(BeNil())
+				}
+				archiveHueyAgain()
+			})
+
+			It("Deletes the whole archive and the parts beyond the new count", func() {
+				Expect(storedHueyObjects()).To(ConsistOf(
+					hueyObject+"-part1.zip", hueyObject+"-part2.zip", hueyObject+".parts.json"))
+			})
+
+			Context("When the folder is no longer split", func() {
+				BeforeEach(func() {
+					archiveRunner.Zipper.SetPartLimits(0, 0)
+					archiveHueyAgain()
+				})
+
+				It("Deletes the parts and their manifest", func() {
+					Expect(storedHueyObjects()).To(ConsistOf(hueyObject + ".zip"))
+				})
+			})
+		})
+	},
+	Entry("Zipping to disk", false),
+	Entry("Streaming uploads", true),
+)
+
+var _ = Describe("Runner when folders are deleted from the library", func() {
+	const goneFolder = "/lib/path/music/gone - album"
+	const goneObject = "gone - album0123456789abcdef0123456789abcdef"
+
+	var archiveRunner *runner.Runner
+	var storagePath string
+	var hueyFolder string
+
+	storeGoneFolder := func() {
+		GinkgoHelper()
+		for _, object := range []string{goneObject + "-part1.zip", goneObject + "-part2.zip", goneObject + ".parts.json"} {
+			Expect(os.WriteFile(filepath.Join(storagePath, object), []byte(object), 0644)).To(BeNil())
+		}
+		Expect(archiveRunner.ArchivedFolderRepository.CreateTable()).To(BeNil())
+		Expect(archiveRunner.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+			Path:        goneFolder,
+			Destination: goneObject + ".zip",
+			UploadedAt:  time.Now().UTC().Add(-time.Hour),
+		})).To(BeNil())
+	}
+
+	tombstones := func() []db.Tombstone {
+		GinkgoHelper()
+		tombstones, err := archiveRunner.TombstoneRepository.AllTombstones()
+		Expect(err).To(BeNil())
+		return tombstones
+	}
+
+	storedObjects := func() []string {
+		GinkgoHelper()
+		backupFiles, err := archiveRunner.StorageClient.ListFiles("")
+		Expect(err).To(BeNil())
+		var objects []string
+		for _, backupFile := range backupFiles {
+			objects = append(objects, backupFile.Name)
+		}
+		return objects
+	}
+
+	BeforeEach(func() {
+		archiveRunner = &runner.Runner{}
+		setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+		testDir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		hueyFolder = filepath.Join(testDir, "..", "tests", "fixtures", "huey lewis - sports")
+
+		By("Storing to a local directory")
+		storagePath = GinkgoT().TempDir()
+		GinkgoT().Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+		archiveRunner.StorageClient = storageclient.NewFileSystem()
+		archiveRunner.TombstoneRepository = &db.TombstoneRepository{
+			SqliteHandler: archiveRunner.ArchiveRunRepository.SqliteHandler}
+
+		By("Storing a split folder that is no longer in the library")
+		storeGoneFolder()
+	})
+
+	It("Leaves deleted folders alone by default", func() {
+		Expect(archiveRunner.RunScheduled()).To(BeNil())
+		Expect(archiveRunner.TombstoneRepository.CreateTable()).To(BeNil())
+		Expect(tombstones()).To(BeEmpty())
+		Expect(storedObjects()).To(ContainElement(goneObject + ".parts.json"))
+	})
+
+	It("Records a tombstone, leaving the objects in place", func() {
+		archiveRunner.DeletedMediaPolicy = runner.DeletedMediaTombstone
+		Expect(archiveRunner.RunScheduled()).To(BeNil())
+
+		Expect(tombstones()).To(HaveLen(1))
+		Expect(tombstones()[0].Path).To(Equal(goneFolder))
+		Expect(tombstones()[0].Destination).To(Equal(goneObject + ".zip"))
+		Expect(tombstones()[0].State).To(Equal(db.TombstoneRecorded))
+		Expect(storedObjects()).To(ContainElements(
+			goneObject+"-part1.zip", goneObject+"-part2.zip", goneObject+".parts.json"))
+		Expect(archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(goneFolder)).To(Not(BeNil()))
+	})
+
+	It("Moves the objects under the deleted prefix", func() {
+		archiveRunner.DeletedMediaPolicy = runner.DeletedMediaMove
+		Expect(archiveRunner.RunScheduled()).To(BeNil())
+
+		Expect(tombstones()[0].State).To(Equal(db.TombstoneMoved))
+		objects := storedObjects()
+		Expect(objects).To(ContainElements(
+			"deleted/"+goneObject+"-part1.zip", "deleted/"+goneObject+"-part2.zip", "deleted/"+goneObject+".parts.json"))
+		Expect(objects).To(Not(ContainElement(goneObject + ".parts.json")))
+		Expect(archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(goneFolder)).To(BeNil())
+	})
+
+	It("Keeps the objects during the grace period", func() {
+		archiveRunner.DeletedMediaPolicy = runner.DeletedMediaDelete
+		archiveRunner.DeleteGracePeriod = time.Hour
+		Expect(archiveRunner.RunScheduled()).To(BeNil())
+
+		Expect(tombstones()[0].State).To(Equal(db.TombstoneRecorded))
+		Expect(storedObjects()).To(ContainElement(goneObject + ".parts.json"))
+	})
+
+	It("Deletes the objects after the grace period", func() {
+		archiveRunner.DeletedMediaPolicy = runner.DeletedMediaDelete
+		Expect(archiveRunner.RunScheduled()).To(BeNil())
+
+		Expect(tombstones()[0].State).To(Equal(db.TombstonePurged))
+		Expect(storedObjects()).To(ConsistOf(
+			"huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip",
+			"mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip",
+			navidromeBackupObject))
+		Expect(archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(goneFolder)).To(BeNil())
+	})
+
+	It("Removes the tombstone of a folder that is back in the library", func() {
+		archiveRunner.DeletedMediaPolicy = runner.DeletedMediaTombstone
+		Expect(archiveRunner.TombstoneRepository.CreateTable()).To(BeNil())
+		Expect(archiveRunner.TombstoneRepository.SaveTombstone(db.Tombstone{
+			Path:      hueyFolder,
+			DeletedAt: time.Now().UTC().Add(-time.Hour),
+			State:     db.TombstoneMoved,
+		})).To(BeNil())
+
+		Expect(archiveRunner.RunScheduled()).To(BeNil())
+		Expect(tombstones()).To(HaveLen(1))
+		Expect(tombstones()[0].Path).To(Equal(goneFolder))
+	})
+
+	It("Does not tombstone anything when the library is empty", func() {
+		archiveRunner.DeletedMediaPolicy = runner.DeletedMediaMove
+		_, err := archiveRunner.MusicFoldersRepository.SqliteHandler.Db().Exec("DELETE FROM media_file")
+		Expect(err).To(BeNil())
+
+		Expect(archiveRunner.RunScheduled()).To(BeNil())
+		Expect(tombstones()).To(BeEmpty())
+		Expect(storedObjects()).To(ContainElement(goneObject + ".parts.json"))
+	})
+})
+
+var _ = Describe("Runner when folders have a stable identity", func() {
+	const hueyStableObject = "huey lewis - sports0000000000000000000000000000aaaa.zip"
+	const mc5Object = "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip"
+
+	var archiveRunner *runner.Runner
+	var storagePath string
+	var hueyFolder string
+	var mc5Folder string
+
+	BeforeEach(func() {
+		archiveRunner = &runner.Runner{}
+		artistPathZips := setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+		hueyFolder = strings.TrimSuffix(artistPathZips.hueyPathZip, ".zip")
+		mc5Folder = strings.TrimSuffix(artistPathZips.mc5PathZip, ".zip")
+
+		By("Storing to a local directory")
+		storagePath = GinkgoT().TempDir()
+		GinkgoT().Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+		archiveRunner.StorageClient = storageclient.NewFileSystem()
+		Expect(archiveRunner.ArchivedFolderRepository.CreateTable()).To(BeNil())
+	})
+
+	Context("When the media file a folder was named after is gone", func() {
+		BeforeEach(func() {
+			By("Recording huey lewis as archived under an ID no longer in the library")
+			Expect(os.WriteFile(filepath.Join(storagePath, hueyStableObject), []byte("old zip"), 0644)).To(BeNil())
+			Expect(archiveRunner.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+				Path:        hueyFolder,
+				FolderId:    "0000000000000000000000000000aaaa",
+				Destination: hueyStableObject,
+				UploadedAt:  time.Now().UTC().Add(-time.Hour),
+			})).To(BeNil())
+
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Replaces the object under the stable identity", func() {
+			backupFiles, err := archiveRunner.StorageClient.ListFiles("huey")
+			Expect(err).To(BeNil())
+			Expect(backupFiles).To(HaveLen(1))
+			Expect(backupFiles[0].Name).To(Equal(hueyStableObject))
+			Expect(archiveRunner.Zipper.VerifyArchive(filepath.Join(storagePath, hueyStableObject))).To(Not(BeNil()))
+		})
+
+		It("Keeps the identity in the archive DB", func() {
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(hueyFolder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.FolderId).To(Equal("0000000000000000000000000000aaaa"))
+			Expect(archivedFolder.Destination).To(Equal(hueyStableObject))
+		})
+
+		It("Records the identity of newly archived folders", func() {
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(mc5Folder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.FolderId).To(Equal("6ea5a2baa32842109925f67b3151fb80"))
+		})
+	})
+
+	Context("When a folder was archived before identities were recorded", func() {
+		BeforeEach(func() {
+			Expect(archiveRunner.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+				Path:          hueyFolder,
+				Destination:   hueyStableObject,
+				NewestMediaAt: time.Now().UTC(),
+				UploadedAt:    time.Now().UTC(),
+			})).To(BeNil())
+
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Takes the identity from its destination", func() {
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(hueyFolder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.FolderId).To(Equal("0000000000000000000000000000aaaa"))
+		})
+	})
+
+	Context("When migrating objects stored without an archive DB", func() {
+		BeforeEach(func() {
+			for _, object := range []string{
+				hueyStableObject,
+				"mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80-part1.zip",
+				"mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80-part2.zip",
+				"mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.parts.json",
+				"mc5 - back in the usa - live1111.zip",
+				"huey lewis - sports20000000000000000000000000000bbbb.zip",
+			} {
+				Expect(os.WriteFile(filepath.Join(storagePath, object), []byte(object), 0644)).To(BeNil())
+			}
+
+			Expect(archiveRunner.RunMigrateIds()).To(BeNil())
+		})
+
+		It("Records each folder under the object it was stored as", func() {
+			archivedFolders, err := archiveRunner.ArchivedFolderRepository.AllArchivedFolders()
+			Expect(err).To(BeNil())
+			Expect(archivedFolders).To(HaveLen(2))
+
+			Expect(archivedFolders[0].Path).To(Equal(hueyFolder))
+			Expect(archivedFolders[0].FolderId).To(Equal("0000000000000000000000000000aaaa"))
+			Expect(archivedFolders[0].Destination).To(Equal(hueyStableObject))
+
+			Expect(archivedFolders[1].Path).To(Equal(mc5Folder))
+			Expect(archivedFolders[1].FolderId).To(Equal("6ea5a2baa32842109925f67b3151fb80"))
+			Expect(archivedFolders[1].Destination).To(Equal(mc5Object))
+			Expect(archivedFolders[1].Size).To(BeNumerically(">", 0))
+		})
+
+		It("Leaves the folders to be fingerprinted when they are next archived", func() {
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(hueyFolder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.Fingerprint).To(BeEmpty())
+		})
+
+		It("Archives the folders under their migrated identities", func() {
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+			Expect(filepath.Join(storagePath, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip")).To(
+				Not(BeAnExistingFile()))
+			Expect(archiveRunner.Zipper.VerifyArchive(filepath.Join(storagePath, hueyStableObject))).To(Not(BeNil()))
+		})
+	})
+
+	Context("When migrating folders archived before fingerprints were recorded", func() {
+		saveArchivedFolder := func(folderPath string, destination string, uploadedAt time.Time) {
+			GinkgoHelper()
+			Expect(archiveRunner.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+				Path:          folderPath,
+				Destination:   destination,
+				NewestMediaAt: uploadedAt,
+				UploadedAt:    uploadedAt,
+			})).To(BeNil())
+		}
+
+		BeforeEach(func() {
+			saveArchivedFolder(hueyFolder, hueyStableObject, time.Now().UTC())
+			saveArchivedFolder(mc5Folder, mc5Object, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+
+			Expect(archiveRunner.RunMigrateIds()).To(BeNil())
+		})
+
+		It("Fingerprints the folders not modified since they were uploaded", func() {
+			fingerprint, err := archiveRunner.Zipper.FolderFingerprint(hueyFolder)
+			Expect(err).To(BeNil())
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(hueyFolder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.Fingerprint).To(Equal(fingerprint))
+
+			archivedFolder, err = archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(mc5Folder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.Fingerprint).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Runner when folders are renamed or moved", func() {
+	const folderId = "0000000000000000000000000000aaaa"
+	const oldObject = "huey lewis - old name" + folderId + ".zip"
+	const hueyMovedObject = "huey lewis - sports" + folderId + ".zip"
+	const hueyObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip"
+
+	var archiveRunner *runner.Runner
+	var storagePath string
+	var hueyFolder string
+	var mc5Folder string
+	var oldFolder string
+
+	expectMovedMetadata := func(object string) {
+		GinkgoHelper()
+		data, err := os.ReadFile(filepath.Join(storagePath, ".navarchiver-metadata-"+object+".json"))
+		Expect(err).To(BeNil())
+		var metadata map[string]string
+		Expect(json.Unmarshal(data, &metadata)).To(BeNil())
+		Expect(metadata).To(Equal(map[string]string{"folder-path": hueyFolder, "folder-id": folderId}))
+	}
+
+	recordOldFolder := func(destination string, crc32c string) {
+		GinkgoHelper()
+		fingerprint, err := archiveRunner.Zipper.FolderFingerprint(hueyFolder)
+		Expect(err).To(BeNil())
+		Expect(archiveRunner.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+			Path:        oldFolder,
+			FolderId:    folderId,
+			Destination: destination,
+			Size:        7,
+			CRC32C:      crc32c,
+			Fingerprint: fingerprint,
+			UploadedAt:  time.Now().UTC().Add(-time.Hour),
+		})).To(BeNil())
+	}
+
+	BeforeEach(func() {
+		archiveRunner = &runner.Runner{}
+		artistPathZips := setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+		hueyFolder = strings.TrimSuffix(artistPathZips.hueyPathZip, ".zip")
+		mc5Folder = strings.TrimSuffix(artistPathZips.mc5PathZip, ".zip")
+		oldFolder = filepath.Join(GinkgoT().TempDir(), "huey lewis - old name")
+
+		By("Storing to a local directory")
+		storagePath = GinkgoT().TempDir()
+		GinkgoT().Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+		archiveRunner.StorageClient = storageclient.NewFileSystem()
+		Expect(archiveRunner.ArchivedFolderRepository.CreateTable()).To(BeNil())
+	})
+
+	Context("When the old folder is gone from disk", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(storagePath, oldObject), []byte("old zip"), 0644)).To(BeNil())
+			recordOldFolder(oldObject, "e3069283")
+
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Moves the archive instead of uploading it again", func() {
+			Expect(filepath.Join(storagePath, oldObject)).To(Not(BeAnExistingFile()))
+			Expect(os.ReadFile(filepath.Join(storagePath, hueyMovedObject))).To(Equal([]byte("old zip")))
+		})
+
+		It("Records the new folder in the metadata of the archive", func() {
+			expectMovedMetadata(hueyMovedObject)
+		})
+
+		It("Records the archive under the new path", func() {
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(hueyFolder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.FolderId).To(Equal(folderId))
+			Expect(archivedFolder.Destination).To(Equal(hueyMovedObject))
+			Expect(archivedFolder.CRC32C).To(Equal("e3069283"))
+
+			Expect(archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(oldFolder)).To(BeNil())
+		})
+	})
+
+	Context("When the old folder was split into parts", func() {
+		BeforeEach(func() {
+			oldParts := []string{zipper.PartName(oldObject, 1), zipper.PartName(oldObject, 2)}
+			manifest := runner.PartsManifest{Folder: "huey lewis - old name"}
+			for _, part := range oldParts {
+				Expect(os.WriteFile(filepath.Join(storagePath, part), []byte(part), 0644)).To(BeNil())
+				manifest.Parts = append(manifest.Parts, runner.ManifestPart{Object: part, Size: int64(len(part))})
+			}
+			data, err := json.Marshal(manifest)
+			Expect(err).To(BeNil())
+			Expect(os.WriteFile(filepath.Join(storagePath, "huey lewis - old name"+folderId+".parts.json"),
+				data, 0644)).To(BeNil())
+			recordOldFolder(oldObject, "00000000")
+
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Moves the parts and stores a manifest listing them", func() {
+			backupFiles, err := archiveRunner.StorageClient.ListFiles("huey lewis - ")
+			Expect(err).To(BeNil())
+			var names []string
+			for _, backupFile := range backupFiles {
+				names = append(names, backupFile.Name)
+			}
+			Expect(names).To(ConsistOf(
+				zipper.PartName(hueyMovedObject, 1),
+				zipper.PartName(hueyMovedObject, 2),
+				"huey lewis - sports"+folderId+".parts.json"))
+
+			data, err := os.ReadFile(filepath.Join(storagePath, "huey lewis - sports"+folderId+".parts.json"))
+			Expect(err).To(BeNil())
+			var manifest runner.PartsManifest
+			Expect(json.Unmarshal(data, &manifest)).To(BeNil())
+			Expect(manifest.Parts).To(HaveLen(2))
+			Expect(manifest.Parts[1].Object).To(Equal(zipper.PartName(hueyMovedObject, 2)))
+		})
+
+		It("Records the new folder in the metadata of each part", func() {
+			expectMovedMetadata(zipper.PartName(hueyMovedObject, 1))
+			expectMovedMetadata(zipper.PartName(hueyMovedObject, 2))
+		})
+
+		It("Records the checksum of the new manifest", func() {
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(hueyFolder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.CRC32C).To(Not(Equal("00000000")))
+			Expect(archivedFolder.Size).To(Equal(int64(2 * len(zipper.PartName(oldObject, 1)))))
+		})
+	})
+
+	Context("When the old folder is still on disk", func() {
+		BeforeEach(func() {
+			oldFolder = mc5Folder
+			Expect(os.WriteFile(filepath.Join(storagePath, oldObject), []byte("old zip"), 0644)).To(BeNil())
+			recordOldFolder(oldObject, "e3069283")
+
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Archives the folder as new", func() {
+			Expect(os.ReadFile(filepath.Join(storagePath, oldObject))).To(Equal([]byte("old zip")))
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(hueyFolder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.Destination).To(Equal(hueyObject))
+			Expect(archiveRunner.Zipper.VerifyArchive(filepath.Join(storagePath, hueyObject))).To(Not(BeNil()))
+		})
+	})
+})
+
+var _ = Describe("Runner when updated folders have the same files", func() {
+	const hueyObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip"
+
+	var archiveRunner *runner.Runner
+	var storagePath string
+	var hueyFolder string
+	var reportFile string
+	var archivedAt = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	recordHuey := func(fingerprint string) {
+		GinkgoHelper()
+		Expect(os.WriteFile(filepath.Join(storagePath, hueyObject), []byte("old zip"), 0644)).To(BeNil())
+		Expect(archiveRunner.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+			Path:          hueyFolder,
+			FolderId:      "5c214deb5b2dba739e0d6af56f61d1c7",
+			Destination:   hueyObject,
+			Fingerprint:   fingerprint,
+			NewestMediaAt: archivedAt,
+			UploadedAt:    archivedAt,
+		})).To(BeNil())
+	}
+
+	BeforeEach(func() {
+		archiveRunner = &runner.Runner{}
+		artistPathZips := setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+		hueyFolder = strings.TrimSuffix(artistPathZips.hueyPathZip, ".zip")
+		reportFile = filepath.Join(GinkgoT().TempDir(), "report.json")
+		archiveRunner.ReportFile = reportFile
+
+		By("Storing to a local directory")
+		storagePath = GinkgoT().TempDir()
+		GinkgoT().Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+		archiveRunner.StorageClient = storageclient.NewFileSystem()
+		Expect(archiveRunner.ArchivedFolderRepository.CreateTable()).To(BeNil())
+
+		By("Caching file hashes in the archive DB")
+		archiveRunner.FileHashRepository = &db.FileHashRepository{
+			SqliteHandler: archiveRunner.ArchivedFolderRepository.SqliteHandler}
+		Expect(archiveRunner.FileHashRepository.CreateTable()).To(BeNil())
+	})
+
+	Context("When the fingerprint matches the archive", func() {
+		BeforeEach(func() {
+			fingerprint, err := archiveRunner.Zipper.FolderFingerprint(hueyFolder)
+			Expect(err).To(BeNil())
+			recordHuey(fingerprint)
+			Expect(archiveRunner.FileHashRepository.CacheHash(
+				filepath.Join(hueyFolder, "removed.mp3"), 1024, archivedAt, "stale")).To(BeNil())
+			Expect(archiveRunner.FileHashRepository.CacheHash(
+				"/not/in/the/library/song.mp3", 1024, archivedAt, "stale")).To(BeNil())
+
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Skips the folder, leaving its archive alone", func() {
+			Expect(os.ReadFile(filepath.Join(storagePath, hueyObject))).To(Equal([]byte("old zip")))
+		})
+
+		It("Lists why the folder was skipped in the report", func() {
+			data, err := os.ReadFile(reportFile)
+			Expect(err).To(BeNil())
+			var report runner.RunReport
+			Expect(json.Unmarshal(data, &report)).To(BeNil())
+			Expect(report.Skipped).To(HaveLen(1))
+			Expect(report.Skipped[0].Folder).To(Equal(hueyFolder))
+			Expect(report.Skipped[0].Reason).To(Equal("its files have not changed since it was archived"))
+			Expect(report.Summary()).To(ContainSubstring(
+				"\n- 1 skipped: its files have not changed since it was archived"))
+		})
+
+		It("Moves the newest media time on, so the folder is not fingerprinted again", func() {
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(hueyFolder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.NewestMediaAt).To(BeTemporally(">", archivedAt))
+			Expect(archivedFolder.UploadedAt).To(BeTemporally("~", archivedAt, time.Second))
+		})
+
+		It("Caches the hash of every file", func() {
+			info, err := os.Stat(filepath.Join(hueyFolder, "cover.jpg"))
+			Expect(err).To(BeNil())
+			Expect(archiveRunner.FileHashRepository.CachedHash(
+				filepath.Join(hueyFolder, "cover.jpg"), info.Size(), info.ModTime())).To(HaveLen(64))
+		})
+
+		It("Prunes the hashes of files no longer in the library", func() {
+			cachedPaths, err := archiveRunner.FileHashRepository.CachedPaths()
+			Expect(err).To(BeNil())
+			Expect(cachedPaths).To(ContainElement(filepath.Join(hueyFolder, "cover.jpg")))
+			Expect(cachedPaths).To(Not(ContainElement(filepath.Join(hueyFolder, "removed.mp3"))))
+			Expect(cachedPaths).To(Not(ContainElement("/not/in/the/library/song.mp3")))
+		})
+	})
+
+	Context("When the fingerprint does not match the archive", func() {
+		BeforeEach(func() {
+			recordHuey("0000")
+
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Replaces the archive", func() {
+			Expect(archiveRunner.Zipper.VerifyArchive(filepath.Join(storagePath, hueyObject))).To(Not(BeNil()))
+			archivedFolder, err := archiveRunner.ArchivedFolderRepository.ArchivedFolderByPath(hueyFolder)
+			Expect(err).To(BeNil())
+			Expect(archivedFolder.Fingerprint).To(HaveLen(64))
+		})
+	})
+})
+
+var _ = Describe("Runner when archives are replaced", func() {
+	const hueyObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip"
+
+	var archiveRunner *runner.Runner
+	var storagePath string
+	var hueyFolder string
+
+	// saveVersion records a version kept by an earlier run, with its object.
+	saveVersion := func(version string, replacedAt time.Time) {
+		GinkgoHelper()
+		versionPath := filepath.Join(storagePath, "versions", version)
+		Expect(os.MkdirAll(versionPath, 0755)).To(BeNil())
+		Expect(os.WriteFile(filepath.Join(versionPath, hueyObject), []byte(version), 0644)).To(BeNil())
+		Expect(archiveRunner.ArchiveVersionRepository.SaveArchiveVersion(db.ArchiveVersion{
+			Path:        hueyFolder,
+			Version:     version,
+			Destination: hueyObject,
+			Checksum:    version,
+			UploadedAt:  replacedAt.Add(-time.Hour),
+			ReplacedAt:  replacedAt,
+		})).To(BeNil())
+	}
+
+	keptVersions := func() []string {
+		GinkgoHelper()
+		versions, err := archiveRunner.ArchiveVersionRepository.ArchiveVersionsByPath(hueyFolder)
+		Expect(err).To(BeNil())
+		var names []string
+		for _, version := range versions {
+			names = append(names, version.Version)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		archiveRunner = &runner.Runner{}
+		artistPathZips := setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+		hueyFolder = strings.TrimSuffix(artistPathZips.hueyPathZip, ".zip")
+
+		By("Storing to a local directory")
+		storagePath = GinkgoT().TempDir()
+		GinkgoT().Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+		archiveRunner.StorageClient = storageclient.NewFileSystem()
+		Expect(archiveRunner.ArchivedFolderRepository.CreateTable()).To(BeNil())
+
+		By("Keeping two versions of each folder")
+		archiveRunner.KeepVersions = 2
+		archiveRunner.ArchiveVersionRepository = &db.ArchiveVersionRepository{
+			SqliteHandler: archiveRunner.ArchivedFolderRepository.SqliteHandler}
+		Expect(archiveRunner.ArchiveVersionRepository.CreateTable()).To(BeNil())
+
+		By("Storing an archive of huey lewis holding only its cover")
+		archiveFile, err := os.Create(filepath.Join(storagePath, hueyObject))
+		Expect(err).To(BeNil())
+		Expect(archiveRunner.Zipper.ZipFilesToWriter(hueyFolder, []string{"cover.jpg"}, archiveFile)).To(BeNil())
+		Expect(archiveFile.Close()).To(BeNil())
+		Expect(archiveRunner.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+			Path:          hueyFolder,
+			FolderId:      "5c214deb5b2dba739e0d6af56f61d1c7",
+			Destination:   hueyObject,
+			Checksum:      "current",
+			NewestMediaAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			UploadedAt:    time.Now().UTC().Add(-time.Hour),
+		})).To(BeNil())
+	})
+
+	Context("When the folder is archived again", func() {
+		BeforeEach(func() {
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Keeps the replaced archive as a version", func() {
+			versions, err := archiveRunner.ArchiveVersionRepository.ArchiveVersionsByPath(hueyFolder)
+			Expect(err).To(BeNil())
+			Expect(versions).To(HaveLen(1))
+			Expect(versions[0].Checksum).To(Equal("current"))
+			Expect(versions[0].Destination).To(Equal(hueyObject))
+
+			keptPath := filepath.Join(storagePath, "versions", versions[0].Version, hueyObject)
+			Expect(archiveRunner.Zipper.CheckArchive(keptPath)).To(Equal([]string{"huey lewis - sports/cover.jpg"}))
+		})
+
+		It("Replaces the current archive", func() {
+			Expect(archiveRunner.Zipper.VerifyArchive(filepath.Join(storagePath, hueyObject))).To(Not(BeNil()))
+		})
+
+		It("Restores the kept version", func() {
+			targetDir := GinkgoT().TempDir()
+			archiveRunner.RestoreVersion = keptVersions()[0]
+			Expect(archiveRunner.RunRestore(targetDir, "huey*")).To(BeNil())
+
+			entries, err := os.ReadDir(filepath.Join(targetDir, "huey lewis - sports"))
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Name()).To(Equal("cover.jpg"))
+		})
+
+		It("Leaves kept versions out of the archives restored by default", func() {
+			targetDir := GinkgoT().TempDir()
+			Expect(archiveRunner.RunRestore(targetDir, "")).To(BeNil())
+			Expect(filepath.Join(targetDir, "huey lewis - sports", "hue lou.mp3")).To(BeAnExistingFile())
+		})
+	})
+
+	Context("When the folder already has the versions to keep", func() {
+		BeforeEach(func() {
+			saveVersion("20240101T000000Z", time.Now().UTC().Add(-48*time.Hour))
+			saveVersion("20240102T000000Z", time.Now().UTC().Add(-24*time.Hour))
+
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Prunes the oldest version", func() {
+			Expect(keptVersions()).To(HaveLen(2))
+			Expect(keptVersions()[1]).To(Equal("20240102T000000Z"))
+			Expect(filepath.Join(storagePath, "versions", "20240101T000000Z", hueyObject)).To(Not(BeAnExistingFile()))
+			Expect(filepath.Join(storagePath, "versions", "20240102T000000Z", hueyObject)).To(BeAnExistingFile())
+		})
+	})
+
+	Context("When versions are older than the maximum age", func() {
+		BeforeEach(func() {
+			archiveRunner.VersionMaxAge = 36 * time.Hour
+			saveVersion("20240101T000000Z", time.Now().UTC().Add(-48*time.Hour))
+			saveVersion("20240102T000000Z", time.Now().UTC().Add(-24*time.Hour))
+
+			By("Leaving huey lewis unchanged since its last archive")
+			Expect(archiveRunner.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+				Path:          hueyFolder,
+				FolderId:      "5c214deb5b2dba739e0d6af56f61d1c7",
+				Destination:   hueyObject,
+				NewestMediaAt: time.Now().UTC(),
+				UploadedAt:    time.Now().UTC(),
+			})).To(BeNil())
+
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Prunes them even when the folder is not archived again", func() {
+			Expect(keptVersions()).To(Equal([]string{"20240102T000000Z"}))
+			Expect(filepath.Join(storagePath, "versions", "20240101T000000Z", hueyObject)).To(Not(BeAnExistingFile()))
+		})
+	})
+
+	Context("When the current archive was already kept by a failed run", func() {
+		BeforeEach(func() {
+			saveVersion("current", time.Now().UTC().Add(-time.Minute))
+
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Does not keep it again", func() {
+			Expect(keptVersions()).To(Equal([]string{"current"}))
+		})
+	})
+})
+
+// expectOnlyObject has storage list object as the only one stored under its
+// name, when a replaced folder is checked for stale objects.
+func expectOnlyObject(mockStorageClient *storageMocks.IStorageClient, object string) {
+	stem := strings.TrimSuffix(object, ".zip")
+	mockStorageClient.EXPECT().ListFiles(stem).Return([]storageclient.BackupFile{{Name: object}}, nil).Once()
+}
+
+func setup(runner *runner.Runner, testData runTestData) *artistPathZips {
+	var lastRun = time.Date(2024, time.January, 12, 13, 19, 51, 0, time.UTC)
+	const hueyPath = "tests/fixtures/huey lewis - sports"
+	const mc5Path = "tests/fixtures/mc5 - back in the usa"
+
+	GinkgoHelper()
+	gt := GinkgoT()
+
+	By("Setting up FileSystemOperator")
+	fso := &fileutil.FileSystemOperator{}
+
+	runner.FileSystemOperator = fso
+	By("Get working directory")
+	testDir, err := os.Getwd()
+	Expect(err).To(BeNil(), "Got an error getting working directory")
+	archiverDir := filepath.Join(testDir, "..")
+
+	By("Deleting prior artifacts")
+	hueyPathZip := deletePriorArtifacts(hueyPath, archiverDir)
+	mc5PathZip := deletePriorArtifacts(mc5Path, archiverDir)
+
+	By("Delete prior backup")
+	err = testutils.RemoveFileIfExists(navidromeBackup)
+	Expect(err).To(BeNil(), "Got an error trying to remove backup file")
+
+	By("Setting up MusicFoldersRepository")
+	fakeNavidromeDbFullPath := setupNavidromeRepositories(runner)
+
+	By("Setting up ArchiveRunRepository")
+	var fakeArchiveRunDbFullPath = ""
+	if testData.priorRun {
+		fakeArchiveRunDbFullPath, err = testutils.SetupTestDb(fakeArchiveRunDb)
+		Expect(err).To(BeNil(), "Error trying to setup fakenavidrome DB")
+
+	} else {
+		dir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		// If we want to parallelize these, we can use a random string when creating the DB
+		// and delete it after the test run. As of now, they operate on the same file - could collide on
+		// a parallel run
+		fixturesPath := "tests/fixtures"
+		testDbPath := fmt.Sprintf("%v/%v.db", fixturesPath, fakeArchiveRunDb)
+		testDbFullPath := filepath.Join(dir, "..", testDbPath)
+
+		Expect(testutils.RemoveFileIfExists(testDbFullPath)).To(BeNil(), "Got an error trying to remove %v", testDbFullPath)
+	}
+	runner.ArchiveRunRepository = &db.ArchiveRunRepository{SqliteHandler: &db.SQLiteHandler{}}
+	Expect(runner.ArchiveRunRepository.SqliteHandler.ConnectSQLite(fakeArchiveRunDbFullPath)).To(
+		BeNil(), "Failed to connect to sqlite for ArchiveRunRepository")
+	runner.ArchivedFolderRepository = &db.ArchivedFolderRepository{SqliteHandler: runner.ArchiveRunRepository.SqliteHandler}
+
+	By("Setting up Zipper")
+	runner.Zipper = &zipper.Zipper{FileSystemOperator: fso}
+
+	By("Setting up FilterService")
+	runner.FilterService = &filter.FilterService{}
+
+	By("Setting up StorageClient")
+	mockStorageClient := storageMocks.NewIStorageClient(gt)
+
+	if testData.priorRun {
+		switch td := testData.runTypeTest; td {
+		case Upload:
+			By("Expecting to upload 2 new files")
+			mockStorageClient.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil).Once()
+			mockStorageClient.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil).Once()
+			mockStorageClient.EXPECT().UploadNewFile(
+				hueyPathZip, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip").Return(nil).Once()
+			mockStorageClient.EXPECT().UploadNewFile(
+				mc5PathZip, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip").Return(nil).Once()
+		case Replace:
+			By("Expecting to replace 2 files")
+			mockStorageClient.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil).Once()
+			mockStorageClient.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil).Once()
+			mockStorageClient.EXPECT().ReplaceFile(
+				hueyPathZip, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip").Return(nil).Once()
+			mockStorageClient.EXPECT().ReplaceFile(
+				mc5PathZip, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip").Return(nil).Once()
+			expectOnlyObject(mockStorageClient, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip")
+			expectOnlyObject(mockStorageClient, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip")
+		case Both:
+			By("Expecting to upload 1 new file and replace 1 file")
+			mockStorageClient.EXPECT().ReplaceFile(navidromeBackupObject, navidromeBackupObject).Return(nil).Once()
+			mockStorageClient.EXPECT().SetMetadata(navidromeBackupObject, schemaMetadata).Return(nil).Once()
+			mockStorageClient.EXPECT().UploadNewFile(
+				hueyPathZip, "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip").Return(nil).Once()
+			mockStorageClient.EXPECT().ReplaceFile(
+				mc5PathZip, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip").Return(nil).Once()
+			expectOnlyObject(mockStorageClient, "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip")
+		case NoOp:
+			By("Expecting to do nothing with storage")
+		}
+		runner.StorageClient = mockStorageClient
+	}
+
+	By("Setting up media file record create and update dates")
+	hueySongPath := "tests/fixtures/huey lewis - sports/hue lou.mp3"
+	Expect(
+		testutils.UpdateMediaFileRecordCreatedAndUpdatedAt(
+			fakeNavidromeDbFullPath, testData.hueyTimeDiff.createdDiff, testData.hueyTimeDiff.updatedDiff, hueySongPath, lastRun)).
+		To(BeNil(), "Failed to update record %v", hueyPath)
+	mc5SongPath := "tests/fixtures/mc5 - back in the usa/tutti fruitti.mp3"
+	Expect(
+		testutils.UpdateMediaFileRecordCreatedAndUpdatedAt(
+			fakeNavidromeDbFullPath, testData.mc5TimeDiff.createdDiff, testData.mc5TimeDiff.updatedDiff, mc5SongPath, lastRun)).
+		To(BeNil(), "Failed to update record %v", mc5Path)
+
+	By("Setting up last run date")
+	if testData.priorRun {
+		Expect(testutils.UpdateLastRunRecord(fakeArchiveRunDbFullPath, lastRun)).To(BeNil(), "Failed to update archive_run")
+	}
+	By("Setting up media file record paths")
+	testutils.UpdateMediaFilePathPrefix(fakeNavidromeDbFullPath, archiverDir, hueySongPath)
+	testutils.UpdateMediaFilePathPrefix(fakeNavidromeDbFullPath, archiverDir, mc5SongPath)
+	return &artistPathZips{hueyPathZip, mc5PathZip}
+}
+
+var _ = Describe("BuildLedger", func() {
+	var runn *runner.Runner
+	const filePath = "/path/to/file.json"
+	var expectedBytes = []byte{
+		0x7b, 0xa, 0x20, 0x20, 0x20, 0x20, 0x22, 0x2f, 0x6c, 0x69, 0x62, 0x2f, 0x70, 0x61, 0x74, 0x68, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x73, 0x2f, 0x66, 0x69, 0x78, 0x74, 0x75, 0x72, 0x65, 0x73, 0x2f, 0x68, 0x75, 0x65, 0x79, 0x20, 0x6c, 0x65, 0x77, 0x69, 0x73, 0x20, 0x2d, 0x20, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x22, 0x3a, 0x20, 0x7b, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x54, 0x79, 0x70, 0x65, 0x22, 0x3a, 0x20, 0x30, 0x2c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x49, 0x64, 0x22, 0x3a, 0x20, 0x22, 0x35, 0x63, 0x32, 0x31, 0x34, 0x64, 0x65, 0x62, 0x35, 0x62, 0x32, 0x64, 0x62, 0x61, 0x37, 0x33, 0x39, 0x65, 0x30, 0x64, 0x36, 0x61, 0x66, 0x35, 0x36, 0x66, 0x36, 0x31, 0x64, 0x31, 0x63, 0x37, 0x22, 0x2c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x42, 0x61, 0x73, 0x65, 0x50, 0x61, 0x74, 0x68, 0x22, 0x3a, 0x20, 0x22, 0x68, 0x75, 0x65, 0x79, 0x20, 0x6c, 0x65, 0x77, 0x69, 0x73, 0x20, 0x2d, 0x20, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x22, 0xa, 0x20, 0x20, 0x20, 0x20, 0x7d, 0x2c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x22, 0x2f, 0x6c, 0x69, 0x62, 0x2f, 0x70, 0x61, 0x74, 0x68, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x73, 0x2f, 0x66, 0x69, 0x78, 0x74, 0x75, 0x72, 0x65, 0x73, 0x2f, 0x6d, 0x63, 0x35, 0x20, 0x2d, 0x20, 0x62, 0x61, 0x63, 0x6b, 0x20, 0x69, 0x6e, 0x20, 0x74, 0x68, 0x65, 0x20, 0x75, 0x73, 0x61, 0x22, 0x3a, 0x20, 0x7b, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x54, 0x79, 0x70, 0x65, 0x22, 0x3a, 0x20, 0x30, 0x2c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x49, 0x64, 0x22, 0x3a, 0x20, 0x22, 0x36, 0x65, 0x61, 0x35, 0x61, 0x32, 0x62, 0x61, 0x61, 0x33, 0x32, 0x38, 0x34, 0x32, 0x31, 0x30, 0x39, 0x39, 0x32, 0x35, 0x66, 0x36, 0x37, 0x62, 0x33, 0x31, 0x35, 0x31, 0x66, 0x62, 0x38, 0x30, 0x22, 0x2c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x22, 0x42, 0x61, 0x73, 0x65, 0x50, 0x61, 0x74, 0x68, 0x22, 0x3a, 0x20, 0x22, 0x6d, 0x63, 0x35, 0x20, 0x2d, 0x20, 0x62, 0x61, 0x63, 0x6b, 0x20, 0x69, 0x6e, 0x20, 0x74, 0x68, 0x65, 0x20, 0x75, 0x73, 0x61, 0x22, 0xa, 0x20, 0x20, 0x20, 0x20, 0x7d, 0xa, 0x7d,
+	}
+
+	BeforeEach(func() {
+		gt := GinkgoT()
+		By("Setting up FileSystemOperator")
+		mockFileSystemOperator := fsoMocks.NewIFileSystemOperator(gt)
+		mockFileSystemOperator.EXPECT().WriteNewFile(filePath, expectedBytes).Return(nil).Once()
+
+		By("Setting up Runner")
+		runn = &runner.Runner{FileSystemOperator: mockFileSystemOperator, FilterService: &filter.FilterService{}}
+
+		By("Setting up MusicFoldersRepository")
+		setupNavidromeRepositories(runn)
+	})
+
+	It("Runs without error", func() {
+		Expect(runn.BuildLedger(filePath)).To(BeNil())
+	})
+})
+
+var _ = Describe("RunBatch", func() {
+	const hueyPath = "tests/fixtures/huey lewis - sports"
+	const mc5Path = "tests/fixtures/mc5 - back in the usa"
+
+	var runn *runner.Runner
+
+	var archiveDir = ""
+
+	BeforeEach(func() {
+		gt := GinkgoT()
+		By("Setting up FileSystemOperator")
+		fso := &fileutil.FileSystemOperator{}
+
+		By("Setting up Runner")
+		runn = &runner.Runner{FileSystemOperator: fso, Zipper: &zipper.Zipper{FileSystemOperator: fso},
+			FilterService: &filter.FilterService{}}
+
+		By("Get working directory")
+		testDir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		archiveDir = filepath.Join(testDir, "..")
+
+		By("Deleting prior artifacts")
+		deletePriorArtifacts(hueyPath, archiveDir)
+		deletePriorArtifacts(mc5Path, archiveDir)
+
+		By("Setting up Storage")
+		storage := storageMocks.NewIStorageClient(gt)
+		storage.EXPECT().UploadNewFile(
+			"../tests/fixtures/huey lewis - sports.zip", "huey lewis - sports37141ae2932c8e06cc3716c3b9c55a48.zip").Return(nil).Once()
+		storage.EXPECT().UploadNewFile(
+			"../tests/fixtures/mc5 - back in the usa.zip", "mc5 - back in the usa07bb5aec148d087b0192c538721d0627.zip").Return(nil).Once()
+		runn.StorageClient = storage
+	})
+
+	It("Runs without error", func() {
+		Expect(runn.RunBatch(filepath.Join(archiveDir, "tests/fixtures/testledger.json"))).To(BeNil())
+	})
+})
+
+var _ = Describe("RunRestore", func() {
+	const hueyPath = "tests/fixtures/huey lewis - sports"
+	const hueyObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip"
+
+	var runn *runner.Runner
+	var storage *storageMocks.IStorageClient
+	var hueyZip string
+	var targetDir string
+
+	BeforeEach(func() {
+		gt := GinkgoT()
+		fso := &fileutil.FileSystemOperator{}
+		zipp := &zipper.Zipper{FileSystemOperator: fso}
+
+		By("Zipping a fixture to act as the stored object")
+		testDir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		deletePriorArtifacts(hueyPath, filepath.Join(testDir, ".."))
+		hueyZip, err = zipp.ZipFilesInFolder(filepath.Join(testDir, "..", hueyPath))
+		Expect(err).To(BeNil(), "Got an error zipping fixture")
+		DeferCleanup(testutils.RemoveFileIfExists, hueyZip)
+
+		By("Setting up Storage")
+		storage = storageMocks.NewIStorageClient(gt)
+
+		targetDir = gt.TempDir()
+		runn = &runner.Runner{FileSystemOperator: fso, Zipper: zipp, StorageClient: storage}
+	})
+
+	expectDownloadOf := func(object string) {
+		storage.EXPECT().DownloadFile(object, mock.AnythingOfType("string")).RunAndReturn(
+			func(_ string, path string) error {
+				data, err := os.ReadFile(hueyZip)
+				if err != nil {
+					return err
+				}
+				return os.WriteFile(path, data, 0644)
+			}).Once()
+	}
+	expectDownload := func() {
+		expectDownloadOf(hueyObject)
+	}
+
+	Context("When restoring everything", func() {
+		BeforeEach(func() {
+			storage.EXPECT().ListFiles("").Return([]storageclient.BackupFile{
+				{Name: hueyObject},
+				{Name: navidromeBackup},
+			}, nil).Once()
+			expectDownload()
+		})
+
+		It("Restores the archived folders", func() {
+			Expect(runn.RunRestore(targetDir, "")).To(BeNil())
+			Expect(filepath.Join(targetDir, "huey lewis - sports", "hue lou.mp3")).To(BeAnExistingFile())
+			Expect(filepath.Join(targetDir, "huey lewis - sports", "cover.jpg")).To(BeAnExistingFile())
+		})
+
+		It("Does not leave the downloaded zip behind", func() {
+			Expect(runn.RunRestore(targetDir, "")).To(BeNil())
+			entries, err := os.ReadDir(targetDir)
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+		})
+	})
+
+	Context("When restoring a glob of folders", func() {
+		BeforeEach(func() {
+			storage.EXPECT().ListFiles("huey lewis - s").Return([]storageclient.BackupFile{
+				{Name: hueyObject},
+				{Name: "huey lewis - fore!37141ae2932c8e06cc3716c3b9c55a48.zip"},
+			}, nil).Once()
+			expectDownload()
+		})
+
+		It("Only restores matching folders", func() {
+			Expect(runn.RunRestore(targetDir, "huey lewis - s*")).To(BeNil())
+		})
+	})
+
+	Context("When nothing matches", func() {
+		BeforeEach(func() {
+			storage.EXPECT().ListFiles("nothing").Return(nil, nil).Once()
+		})
+
+		It("Returns an error", func() {
+			Expect(runn.RunRestore(targetDir, "nothing*")).To(Not(BeNil()))
+		})
+	})
+
+	Context("When the archive records the path of its folder in the library", func() {
+		BeforeEach(func() {
+			By("Zipping the fixture again with its library path")
+			Expect(os.Remove(hueyZip)).To(BeNil())
+			runn.Zipper.SetRelativePath("huey lewis/huey lewis - sports")
+			var err error
+			hueyZip, err = runn.Zipper.ZipFilesInFolder(strings.TrimSuffix(hueyZip, ".zip"))
+			Expect(err).To(BeNil())
+
+			storage.EXPECT().ListFiles("").Return([]storageclient.BackupFile{{Name: hueyObject}}, nil).Once()
+			expectDownload()
+		})
+
+		It("Restores the folder to that path", func() {
+			Expect(runn.RunRestore(targetDir, "")).To(BeNil())
+			Expect(filepath.Join(targetDir, "huey lewis", "huey lewis - sports", "hue lou.mp3")).To(BeAnExistingFile())
+		})
+	})
+
+	Context("When restoring from beneath a prefix", func() {
+		BeforeEach(func() {
+			runn.RestorePrefix = "deleted/"
+			storage.EXPECT().ListFiles("deleted/huey lewis - s").Return([]storageclient.BackupFile{
+				{Name: "deleted/" + hueyObject},
+			}, nil).Once()
+			expectDownloadOf("deleted/" + hueyObject)
+		})
+
+		It("Restores the folders beneath the prefix", func() {
+			Expect(runn.RunRestore(targetDir, "huey lewis - s*")).To(BeNil())
+			Expect(filepath.Join(targetDir, "huey lewis - sports", "hue lou.mp3")).To(BeAnExistingFile())
+		})
+	})
+})
+
+var _ = Describe("Runner when keeping dated DB snapshots", func() {
+	var archiveRunner *runner.Runner
+	var snapshotsPath string
+
+	BeforeEach(func() {
+		archiveRunner = &runner.Runner{}
+		setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: 10, updatedDiff: 10},
+			mc5TimeDiff:  timeDiff{createdDiff: 10, updatedDiff: 10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+
+		By("Storing to a local directory")
+		storagePath := GinkgoT().TempDir()
+		GinkgoT().Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+		archiveRunner.StorageClient = storageclient.NewFileSystem()
+		snapshotsPath = filepath.Join(storagePath, "db-backups")
+
+		By("Keeping 2 daily, 3 weekly and 4 monthly snapshots")
+		archiveRunner.DbBackupRetention = runner.DbBackupRetention{Daily: 2, Weekly: 3, Monthly: 4}
+
+		By("Storing snapshots taken by earlier runs")
+		Expect(os.MkdirAll(snapshotsPath, 0755)).To(BeNil())
+		for _, taken := range []string{
+			"20240301T100000Z", "20240301T090000Z", "20240229T100000Z",
+			"20240220T100000Z", "20240115T100000Z", "20231210T100000Z",
+		} {
+			snapshot := filepath.Join(snapshotsPath, "navidrome-backup-"+taken+".sqlite")
+			Expect(os.WriteFile(snapshot, []byte(taken), 0644)).To(BeNil())
+		}
+		Expect(os.WriteFile(filepath.Join(snapshotsPath, "notes.txt"), []byte("notes"), 0644)).To(BeNil())
+
+		Expect(archiveRunner.RunScheduled()).To(BeNil())
+	})
+
+	It("Uploads the DB backup as a dated snapshot", func() {
+		snapshots, err := filepath.Glob(filepath.Join(snapshotsPath, "navidrome-backup-2*"))
+		Expect(err).To(BeNil())
+		Expect(snapshots).To(HaveLen(5))
+
+		latest, err := os.ReadFile(filepath.Join(snapshotsPath, "latest"))
+		Expect(err).To(BeNil())
+		Expect(string(latest)).To(HavePrefix("db-backups/navidrome-backup-"))
+		Expect(string(latest)).To(HaveSuffix(".sqlite.zst"))
+		Expect(filepath.Join(filepath.Dir(snapshotsPath), string(latest))).To(BeAnExistingFile())
+		Expect(filepath.Join(snapshotsPath, "..", navidromeBackupObject)).To(Not(BeAnExistingFile()))
+	})
+
+	It("Keeps the newest snapshot in each period", func() {
+		for _, taken := range []string{"20240301T100000Z", "20240229T100000Z", "20240220T100000Z", "20240115T100000Z"} {
+			Expect(filepath.Join(snapshotsPath, "navidrome-backup-"+taken+".sqlite")).To(BeAnExistingFile())
+		}
+	})
+
+	It("Prunes the snapshots outside the retention periods", func() {
+		for _, taken := range []string{"20240301T090000Z", "20231210T100000Z"} {
+			Expect(filepath.Join(snapshotsPath, "navidrome-backup-"+taken+".sqlite")).To(Not(BeAnExistingFile()))
+		}
+		Expect(filepath.Join(snapshotsPath, "notes.txt")).To(BeAnExistingFile())
+	})
+})
+
+var _ = Describe("Runner when only Navidrome metadata changes", func() {
+	var archiveRunner *runner.Runner
+	var backupPath string
+
+	BeforeEach(func() {
+		archiveRunner = &runner.Runner{}
+		setup(archiveRunner, runTestData{
+			hueyTimeDiff: timeDiff{createdDiff: -10, updatedDiff: -10},
+			mc5TimeDiff:  timeDiff{createdDiff: -10, updatedDiff: -10},
+			runTypeTest:  NoOp,
+			priorRun:     true,
+		})
+
+		By("Storing to a local directory")
+		storagePath := GinkgoT().TempDir()
+		GinkgoT().Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+		archiveRunner.StorageClient = storageclient.NewFileSystem()
+		backupPath = filepath.Join(storagePath, navidromeBackupObject)
+
+		By("Recording the DB backups uploaded")
+		archiveRunner.DbBackupRepository = &db.DbBackupRepository{
+			SqliteHandler: archiveRunner.ArchivedFolderRepository.SqliteHandler}
+
+		Expect(archiveRunner.RunScheduled()).To(BeNil())
+	})
+
+	It("Backs up the DB without any media changing", func() {
+		Expect(backupPath).To(BeAnExistingFile())
+		lastBackup, err := archiveRunner.DbBackupRepository.LastDbBackup()
+		Expect(err).To(BeNil())
+		Expect(lastBackup.Object).To(Equal(navidromeBackupObject))
+		Expect(lastBackup.Checksum).To(HaveLen(64))
+	})
+
+	It("Stores the backup compressed, with the Navidrome schema version", func() {
+		compressed, err := os.Open(backupPath)
+		Expect(err).To(BeNil())
+		defer compressed.Close()
+		decoder, err := zstd.NewReader(compressed)
+		Expect(err).To(BeNil())
+		defer decoder.Close()
+		restoredPath := filepath.Join(GinkgoT().TempDir(), "restored.db")
+		restored, err := os.Create(restoredPath)
+		Expect(err).To(BeNil())
+		_, err = io.Copy(restored, decoder)
+		Expect(err).To(BeNil())
+		Expect(restored.Close()).To(BeNil())
+		Expect(testutils.LibraryPathRecord(restoredPath, 1)).To(Equal("/lib/path"))
+
+		metadata, err := os.ReadFile(filepath.Join(filepath.Dir(backupPath), ".navarchiver-metadata-"+navidromeBackupObject+".json"))
+		Expect(err).To(BeNil())
+		Expect(metadata).To(MatchJSON(`{"navidrome-schema-version": "20240122223340"}`))
+	})
+
+	It("Does not leave the compressed backup behind", func() {
+		Expect(testutils.FileExists(navidromeBackupObject)).To(BeFalse())
+	})
+
+	Context("When nothing has changed since the last backup", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(backupPath)).To(BeNil())
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Does not upload the backup again", func() {
+			Expect(backupPath).To(Not(BeAnExistingFile()))
+		})
+	})
+
+	Context("When play counts have changed since the last backup", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(backupPath)).To(BeNil())
+			_, err := archiveRunner.AdminRepository.SqliteHandler.Db().Exec(
+				"CREATE TABLE annotation (item_id TEXT, play_count INTEGER);" +
+					"INSERT INTO annotation VALUES ('1', 3);")
+			Expect(err).To(BeNil())
+			Expect(archiveRunner.RunScheduled()).To(BeNil())
+		})
+
+		It("Uploads the backup again", func() {
+			Expect(backupPath).To(BeAnExistingFile())
+		})
+	})
+})
+
+var _ = Describe("RunRestoreDb", func() {
+	var runn *runner.Runner
+	var storage *storageMocks.IStorageClient
+	var destination string
+	var fakeNavidromeDbFullPath string
+	var pointers []storageclient.BackupFile
+	var backups []storageclient.BackupFile
+
+	BeforeEach(func() {
+		gt := GinkgoT()
+		var err error
+		fakeNavidromeDbFullPath, err = testutils.SetupTestDb(fakeNavidromeDb)
+		Expect(err).To(BeNil(), "Error trying to setup fakenavidrome DB")
+
+		By("Setting up Storage")
+		storage = storageMocks.NewIStorageClient(gt)
+		pointers = nil
+		storage.EXPECT().ListFiles("db-backups/latest").RunAndReturn(
+			func(string) ([]storageclient.BackupFile, error) {
+				return pointers, nil
+			}).Maybe()
+		backups = []storageclient.BackupFile{{Name: navidromeBackup}}
+		storage.EXPECT().ListFiles(navidromeBackup).RunAndReturn(
+			func(string) ([]storageclient.BackupFile, error) {
+				return backups, nil
+			}).Maybe()
+		storage.EXPECT().DownloadFile(navidromeBackup, mock.AnythingOfType("string")).RunAndReturn(
+			func(_ string, path string) error {
+				data, err := os.ReadFile(fakeNavidromeDbFullPath)
+				if err != nil {
+					return err
+				}
+				return os.WriteFile(path, data, 0644)
+			}).Maybe()
+
+		destination = filepath.Join(gt.TempDir(), "navidrome.db")
+		runn = &runner.Runner{FileSystemOperator: &fileutil.FileSystemOperator{}, StorageClient: storage}
+	})
+
+	Context("When the library root has moved", func() {
+		BeforeEach(func() {
+			Expect(runn.RunRestoreDb(destination, "/lib/path", "/mnt/music")).To(BeNil())
+		})
+
+		It("Writes the restored DB with the new library root", func() {
+			Expect(testutils.LibraryPathRecord(destination, 1)).To(Equal("/mnt/music"))
+		})
+
+		It("Does not leave the download behind", func() {
+			entries, err := os.ReadDir(filepath.Dir(destination))
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+		})
+	})
+
+	Context("When the library root has not moved", func() {
+		It("Writes the restored DB as it was", func() {
+			Expect(runn.RunRestoreDb(destination, "", "")).To(BeNil())
+			Expect(testutils.LibraryPathRecord(destination, 1)).To(Equal("/lib/path"))
+		})
+	})
+
+	Context("When the backup is compressed", func() {
+		BeforeEach(func() {
+			backups = append(backups, storageclient.BackupFile{Name: navidromeBackupObject})
+			storage.EXPECT().DownloadFile(navidromeBackupObject, mock.AnythingOfType("string")).RunAndReturn(
+				func(_ string, path string) error {
+					data, err := os.ReadFile(fakeNavidromeDbFullPath)
+					if err != nil {
+						return err
+					}
+					encoder, err := zstd.NewWriter(nil)
+					if err != nil {
+						return err
+					}
+					return os.WriteFile(path, encoder.EncodeAll(data, nil), 0644)
+				})
+
+			Expect(runn.RunRestoreDb(destination, "", "")).To(BeNil())
+		})
+
+		It("Restores the decompressed DB", func() {
+			Expect(testutils.LibraryPathRecord(destination, 1)).To(Equal("/lib/path"))
+		})
+
+		It("Does not leave the downloads behind", func() {
+			entries, err := os.ReadDir(filepath.Dir(destination))
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+		})
+	})
+
+	Context("When dated snapshots have been taken", func() {
+		const snapshot = "db-backups/navidrome-backup-20240301T100000Z.sqlite"
+
+		BeforeEach(func() {
+			pointers = []storageclient.BackupFile{{Name: "db-backups/latest"}}
+			storage.EXPECT().DownloadFile("db-backups/latest", mock.AnythingOfType("string")).RunAndReturn(
+				func(_ string, path string) error {
+					return os.WriteFile(path, []byte(snapshot), 0644)
+				})
+			storage.EXPECT().DownloadFile(snapshot, mock.AnythingOfType("string")).RunAndReturn(
+				func(_ string, path string) error {
+					data, err := os.ReadFile(fakeNavidromeDbFullPath)
+					if err != nil {
+						return err
+					}
+					return os.WriteFile(path, data, 0644)
+				})
+
+			Expect(runn.RunRestoreDb(destination, "", "")).To(BeNil())
+		})
+
+		It("Restores the snapshot the latest pointer names", func() {
+			Expect(testutils.LibraryPathRecord(destination, 1)).To(Equal("/lib/path"))
+		})
+
+		It("Does not leave the downloads behind", func() {
+			entries, err := os.ReadDir(filepath.Dir(destination))
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+		})
+	})
+
+	Context("When the destination already exists", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(destination, []byte("live db"), 0644)).To(BeNil())
+		})
+
+		It("Refuses to overwrite it", func() {
+			Expect(runn.RunRestoreDb(destination, "", "")).To(Not(BeNil()))
+			Expect(os.ReadFile(destination)).To(Equal([]byte("live db")))
+		})
+	})
+})
+
+var _ = Describe("RunVerify", func() {
+	const hueyFolder = "/lib/path/tests/fixtures/huey lewis - sports"
+	const hueyObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7"
+	const mc5Folder = "/lib/path/tests/fixtures/mc5 - back in the usa"
+	const mc5Object = "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip"
+
+	var runn *runner.Runner
+	var storage *storageMocks.IStorageClient
+	var storedAt = time.Now().UTC()
+
+	BeforeEach(func() {
+		storage = storageMocks.NewIStorageClient(GinkgoT())
+		runn = &runner.Runner{
+			FileSystemOperator: &fileutil.FileSystemOperator{},
+			FilterService:      &filter.FilterService{},
+			StorageClient:      storage,
+		}
+		setupNavidromeRepositories(runn)
+	})
+
+	Context("When every folder is stored and up to date", func() {
+		BeforeEach(func() {
+			storage.EXPECT().ListFiles("").Return([]storageclient.BackupFile{
+				{Name: navidromeBackup, Updated: storedAt},
+				{Name: hueyObject + "-part1.zip", Updated: storedAt},
+				{Name: hueyObject + "-part2.zip", Updated: storedAt},
+				{Name: hueyObject + ".parts.json", Updated: storedAt},
+				{Name: mc5Object, Updated: storedAt},
+			}, nil).Once()
+		})
+
+		It("Reports no problems", func() {
+			report, err := runn.RunVerify()
+			Expect(err).To(BeNil())
+			Expect(report.OK()).To(BeTrue(), report.Summary())
+			Expect(report.Folders).To(Equal(2))
+			Expect(report.Objects).To(Equal(5))
+		})
+	})
+
+	Context("When a folder is stored under its stable identity", func() {
+		BeforeEach(func() {
+			archiveDbFullPath, err := testutils.SetupTestDb(fakeArchiveRunDb)
+			Expect(err).To(BeNil(), "Error trying to setup archive DB")
+			sqliteHandler := &db.SQLiteHandler{}
+			Expect(sqliteHandler.ConnectSQLite(archiveDbFullPath)).To(BeNil())
+			runn.ArchivedFolderRepository = &db.ArchivedFolderRepository{SqliteHandler: sqliteHandler}
+			Expect(runn.ArchivedFolderRepository.CreateTable()).To(BeNil())
+			Expect(runn.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+				Path:        mc5Folder,
+				FolderId:    "0000",
+				Destination: "mc5 - back in the usa0000.zip",
+				UploadedAt:  storedAt,
+			})).To(BeNil())
+
+			storage.EXPECT().ListFiles("").Return([]storageclient.BackupFile{
+				{Name: hueyObject + ".zip", Updated: storedAt},
+				{Name: "mc5 - back in the usa0000.zip", Updated: storedAt},
+			}, nil).Once()
+		})
+
+		It("Expects the folder under that identity", func() {
+			report, err := runn.RunVerify()
+			Expect(err).To(BeNil())
+			Expect(report.OK()).To(BeTrue(), report.Summary())
+		})
+	})
+
+	Context("When storage does not match the library", func() {
+		var reportFile string
+
+		BeforeEach(func() {
+			storage.EXPECT().ListFiles("").Return([]storageclient.BackupFile{
+				{Name: hueyObject + ".zip", Updated: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)},
+				{Name: "mc5 - back in the usa0000.zip", Updated: storedAt, Size: 10},
+				{Name: "mc5 - back in the usa0000-part2.zip", Updated: storedAt},
+				{Name: "deleted/gone - album0000.zip", Updated: storedAt},
+			}, nil).Once()
+			reportFile = filepath.Join(GinkgoT().TempDir(), "verify.json")
+			runn.ReportFile = reportFile
+		})
+
+		It("Reports missing, stale and orphaned objects", func() {
+			report, err := runn.RunVerify()
+			Expect(err).To(BeNil())
+			Expect(report.OK()).To(BeFalse())
+
+			Expect(report.Missing).To(HaveLen(1))
+			Expect(report.Missing[0].Folder).To(Equal(mc5Folder))
+			Expect(report.Missing[0].Object).To(Equal(mc5Object))
+
+			Expect(report.Stale).To(HaveLen(1))
+			Expect(report.Stale[0].Folder).To(Equal(hueyFolder))
+			Expect(*report.Stale[0].StoredAt).To(BeTemporally("<", report.Stale[0].NewestMediaAt))
+
+			Expect(report.Orphaned).To(HaveLen(2))
+			Expect(report.Orphaned[0].Object).To(Equal("mc5 - back in the usa0000-part2.zip"))
+			Expect(report.Orphaned[1].Object).To(Equal("mc5 - back in the usa0000.zip"))
+			Expect(report.Orphaned[1].Size).To(Equal(int64(10)))
+
+			Expect(report.Summary()).To(ContainSubstring("1 missing, 1 stale, 2 orphaned"))
+		})
+
+		It("Writes the report as JSON", func() {
+			report, err := runn.RunVerify()
+			Expect(err).To(BeNil())
+
+			data, err := os.ReadFile(reportFile)
+			Expect(err).To(BeNil())
+			var written runner.VerifyReport
+			Expect(json.Unmarshal(data, &written)).To(BeNil())
+			Expect(written.Missing).To(HaveLen(len(report.Missing)))
+			Expect(written.Stale).To(HaveLen(len(report.Stale)))
+			Expect(written.Orphaned).To(HaveLen(len(report.Orphaned)))
+		})
+	})
+
+	It("Fails when storage cannot be listed", func() {
+		storage.EXPECT().ListFiles("").Return(nil, errors.New("bucket unavailable")).Once()
+		_, err := runn.RunVerify()
+		Expect(err).To(MatchError(ContainSubstring("bucket unavailable")))
+	})
+})
+
+var _ = Describe("RunScrub", func() {
+	const hueyObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip"
+	const mc5Object = "mc5 - back in the usa6ea5a2baa32842109925f67b3151fb80.zip"
+
+	var runn *runner.Runner
+	var storagePath string
+	var hueyPath string
+	var mc5Path string
+
+	storeArchive := func(folderPath string, object string) {
+		GinkgoHelper()
+		fileNames, err := runn.Zipper.FilesToZip(folderPath)
+		Expect(err).To(BeNil())
+		archiveFile, err := os.Create(filepath.Join(storagePath, object))
+		Expect(err).To(BeNil())
+		defer archiveFile.Close()
+		Expect(runn.Zipper.ZipFilesToWriter(folderPath, fileNames, archiveFile)).To(BeNil())
+	}
+
+	scrubbedObjects := func(results []runner.ScrubbedFolder) []string {
+		var objects []string
+		for _, result := range results {
+			objects = append(objects, result.Object)
+		}
+		return objects
+	}
+
+	BeforeEach(func() {
+		gt := GinkgoT()
+		fso := &fileutil.FileSystemOperator{}
+		testDir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		hueyPath = filepath.Join(testDir, "..", "tests", "fixtures", "huey lewis - sports")
+		mc5Path = filepath.Join(testDir, "..", "tests", "fixtures", "mc5 - back in the usa")
+
+		By("Storing to a local directory")
+		storagePath = gt.TempDir()
+		gt.Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+
+		By("Setting up the archive DB")
+		archiveDbFullPath, err := testutils.SetupTestDb(fakeArchiveRunDb)
+		Expect(err).To(BeNil(), "Error trying to setup archive DB")
+		sqliteHandler := &db.SQLiteHandler{}
+		Expect(sqliteHandler.ConnectSQLite(archiveDbFullPath)).To(BeNil())
+
+		runn = &runner.Runner{
+			FileSystemOperator:       fso,
+			Zipper:                   &zipper.Zipper{FileSystemOperator: fso},
+			StorageClient:            storageclient.NewFileSystem(),
+			ArchivedFolderRepository: &db.ArchivedFolderRepository{SqliteHandler: sqliteHandler},
+			ScrubResultRepository:    &db.ScrubResultRepository{SqliteHandler: sqliteHandler},
+			ScrubDir:                 gt.TempDir(),
+		}
+
+		By("Recording both fixtures as archived")
+		Expect(runn.ArchivedFolderRepository.CreateTable()).To(BeNil())
+		for folderPath, object := range map[string]string{hueyPath: hueyObject, mc5Path: mc5Object} {
+			Expect(runn.ArchivedFolderRepository.SaveArchivedFolder(db.ArchivedFolder{
+				Path:        folderPath,
+				Destination: object,
+				UploadedAt:  time.Now().UTC().Add(-time.Hour),
+			})).To(BeNil())
+		}
+	})
+
+	Context("When the archives are intact", func() {
+		BeforeEach(func() {
+			storeArchive(hueyPath, hueyObject)
+			storeArchive(mc5Path, mc5Object)
+		})
+
+		It("Passes every archive and records the outcome", func() {
+			report, err := runn.RunScrub()
+			Expect(err).To(BeNil())
+			Expect(scrubbedObjects(report.Passed)).To(ConsistOf(hueyObject, mc5Object))
+			Expect(report.Failed).To(BeEmpty())
+
+			scrubResults, err := runn.ScrubResultRepository.AllScrubResults()
+			Expect(err).To(BeNil())
+			Expect(scrubResults).To(HaveLen(2))
+			for _, scrubResult := range scrubResults {
+				Expect(scrubResult.Error).To(BeEmpty())
+			}
+		})
+
+		It("Works through the folders scrubbed least recently", func() {
+			runn.ScrubCount = 1
+
+			report, err := runn.RunScrub()
+			Expect(err).To(BeNil())
+			Expect(scrubbedObjects(report.Passed)).To(Equal([]string{hueyObject}))
+
+			report, err = runn.RunScrub()
+			Expect(err).To(BeNil())
+			Expect(scrubbedObjects(report.Passed)).To(Equal([]string{mc5Object}))
+		})
+
+		It("Leaves nothing behind in the scrub directory", func() {
+			_, err := runn.RunScrub()
+			Expect(err).To(BeNil())
+			Expect(os.ReadDir(runn.ScrubDir)).To(BeEmpty())
+		})
+	})
+
+	Context("When an archive is damaged", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(storagePath, hueyObject), []byte("not a zip"), 0644)).To(BeNil())
+			storeArchive(mc5Path, mc5Object)
+		})
+
+		It("Fails the archive and records why", func() {
+			report, err := runn.RunScrub()
+			var failedScrub *runner.FailedScrubError
+			Expect(errors.As(err, &failedScrub)).To(BeTrue())
+			Expect(scrubbedObjects(report.Failed)).To(Equal([]string{hueyObject}))
+			Expect(scrubbedObjects(report.Passed)).To(Equal([]string{mc5Object}))
+
+			scrubResults, err := runn.ScrubResultRepository.AllScrubResults()
+			Expect(err).To(BeNil())
+			Expect(scrubResults[0].Path).To(Equal(hueyPath))
+			Expect(scrubResults[0].Error).To(ContainSubstring("failed to open zip"))
+		})
+	})
+
+	Context("When an archive does not hold the files on disk", func() {
+		BeforeEach(func() {
+			storeArchive(mc5Path, hueyObject)
+			storeArchive(mc5Path, mc5Object)
+		})
+
+		It("Fails the archive, listing the differences", func() {
+			report, err := runn.RunScrub()
+			Expect(err).To(Not(BeNil()))
+			Expect(report.Failed).To(HaveLen(1))
+			// Files are compared below the folder at the root of the archive.
+			Expect(report.Failed[0].Reason).To(ContainSubstring(
+				"missing from the archive: huey lewis - sports/hue lou.mp3"))
+			Expect(report.Failed[0].Reason).To(ContainSubstring(
+				"no longer on disk: huey lewis - sports/tutti fruitti.mp3"))
+		})
 	})
 })
 
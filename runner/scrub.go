@@ -0,0 +1,230 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+)
+
+const (
+	defaultScrubCount = 10
+	scrubDirName      = ".navarchiver-scrub"
+)
+
+// ScrubReport holds the outcome for every archived folder a scrub checked.
+type ScrubReport struct {
+	Passed []ScrubbedFolder `json:"passed"`
+	Failed []ScrubbedFolder `json:"failed"`
+}
+
+type ScrubbedFolder struct {
+	Folder string `json:"folder"`
+	Object string `json:"object"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Summary is a human readable version of the report, listing every failure.
+func (sr *ScrubReport) Summary() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v archives passed, %v failed", len(sr.Passed), len(sr.Failed))
+	for _, result := range sr.Failed {
+		fmt.Fprintf(&sb, "\n- %v: %v", result.Object, result.Reason)
+	}
+	return sb.String()
+}
+
+// FailedScrubError is returned when any archive a scrub checked failed.
+type FailedScrubError struct {
+	Report *ScrubReport
+}
+
+func (fse *FailedScrubError) Error() string {
+	return fmt.Sprintf("failed to scrub %v archives - %v", len(fse.Report.Failed), fse.Report.Summary())
+}
+
+// RunScrub downloads a sample of the archived folders and checks that each one
+// can be read, matches its manifest and holds the files now in the folder.
+// The outcome is recorded for every folder, and without ScrubRandom the folders
+// scrubbed least recently are picked, so the whole archive is covered over time.
+func (r *Runner) RunScrub() (*ScrubReport, error) {
+	if err := r.ArchivedFolderRepository.CreateTable(); err != nil {
+		return nil, fmt.Errorf("failed to CreateTable archived folder: %v", err)
+	}
+	if err := r.ScrubResultRepository.CreateTable(); err != nil {
+		return nil, fmt.Errorf("failed to CreateTable scrub result: %v", err)
+	}
+
+	archivedFolders, err := r.ArchivedFolderRepository.AllArchivedFolders()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived folders: %v", err)
+	}
+	scrubResults, err := r.ScrubResultRepository.AllScrubResults()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scrub results: %v", err)
+	}
+
+	scrubDir := filepath.Join(r.ScrubDir, scrubDirName)
+	if err := r.FileSystemOperator.CreateDirectory(scrubDir); err != nil {
+		return nil, fmt.Errorf("failed to create %v: %v", scrubDir, err)
+	}
+
+	report := &ScrubReport{}
+	for _, archivedFolder := range r.foldersToScrub(archivedFolders, scrubResults) {
+		log.Printf("Scrubbing %v", archivedFolder.Destination)
+		result := ScrubbedFolder{Folder: archivedFolder.Path, Object: archivedFolder.Destination}
+		scrubErr := r.scrubFolder(archivedFolder, scrubDir)
+		if scrubErr != nil {
+			log.Printf("ERROR: Scrub of %v failed: %v", archivedFolder.Destination, scrubErr)
+			result.Reason = scrubErr.Error()
+			report.Failed = append(report.Failed, result)
+		} else {
+			report.Passed = append(report.Passed, result)
+		}
+
+		err := r.ScrubResultRepository.SaveScrubResult(db.ScrubResult{
+			Path:        archivedFolder.Path,
+			Destination: archivedFolder.Destination,
+			ScrubbedAt:  time.Now().UTC(),
+			Error:       result.Reason,
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to record scrub of %v: %v", archivedFolder.Path, err)
+		}
+	}
+
+	if err := r.FileSystemOperator.DeleteFile(scrubDir); err != nil {
+		log.Printf("failed to delete %v: %v", scrubDir, err)
+	}
+
+	log.Printf("Scrub report: %v", report.Summary())
+	r.writeReport(report)
+	if len(report.Failed) > 0 {
+		return report, &FailedScrubError{Report: report}
+	}
+	return report, nil
+}
+
+// foldersToScrub picks ScrubCount folders, either at random or the ones whose
+// current upload has gone unscrubbed the longest.
+func (r *Runner) foldersToScrub(
+	archivedFolders []db.ArchivedFolder, scrubResults []db.ScrubResult) []db.ArchivedFolder {
+	count := r.ScrubCount
+	if count <= 0 {
+		count = defaultScrubCount
+	}
+
+	if r.ScrubRandom {
+		rand.Shuffle(len(archivedFolders), func(i, j int) {
+			archivedFolders[i], archivedFolders[j] = archivedFolders[j], archivedFolders[i]
+		})
+		return archivedFolders[:min(count, len(archivedFolders))]
+	}
+
+	// A scrub of an earlier upload of the folder does not count.
+	lastScrubbed := make(map[string]time.Time)
+	for _, scrubResult := range scrubResults {
+		lastScrubbed[scrubResult.Path+"\x00"+scrubResult.Destination] = scrubResult.ScrubbedAt
+	}
+	scrubbedAt := func(archivedFolder db.ArchivedFolder) time.Time {
+		at := lastScrubbed[archivedFolder.Path+"\x00"+archivedFolder.Destination]
+		if at.Before(archivedFolder.UploadedAt) {
+			return time.Time{}
+		}
+		return at
+	}
+	// AllArchivedFolders is sorted by path, which breaks ties.
+	slices.SortStableFunc(archivedFolders, func(a, b db.ArchivedFolder) int {
+		return scrubbedAt(a).Compare(scrubbedAt(b))
+	})
+	return archivedFolders[:min(count, len(archivedFolders))]
+}
+
+// scrubFolder downloads every object of the archived folder into scrubDir,
+// reads them through and compares their files with the folder on disk.
+func (r *Runner) scrubFolder(archivedFolder db.ArchivedFolder, scrubDir string) error {
+	objects := []string{archivedFolder.Destination}
+	parts, err := r.partsToRestore(archivedFolder.Destination, scrubDir)
+	if err != nil {
+		return err
+	}
+	if parts != nil {
+		objects = parts
+	}
+
+	var archivedFiles []string
+	for _, object := range objects {
+		files, err := r.scrubObject(object, scrubDir)
+		if err != nil {
+			return fmt.Errorf("%v: %v", object, err)
+		}
+		archivedFiles = append(archivedFiles, files...)
+	}
+	return r.compareWithDisk(archivedFolder.Path, archivedFiles)
+}
+
+func (r *Runner) scrubObject(object string, scrubDir string) ([]string, error) {
+	downloadPath := filepath.Join(scrubDir, restoreDownloadPrefix+path.Base(object))
+	if err := r.StorageClient.DownloadFile(object, downloadPath); err != nil {
+		return nil, fmt.Errorf("failed to download: %v", err)
+	}
+	defer func() {
+		if err := r.FileSystemOperator.DeleteFile(downloadPath); err != nil {
+			log.Printf("failed to delete %v: %v", downloadPath, err)
+		}
+	}()
+
+	return r.Zipper.CheckArchive(downloadPath)
+}
+
+// compareWithDisk checks that the archive holds the files that are in the
+// folder now. A folder that is gone from disk has nothing to compare against.
+// Files are compared below the folder at the root of the archive, which keeps
+// its old name when a renamed folder has its archive moved.
+func (r *Runner) compareWithDisk(folderPath string, archivedFiles []string) error {
+	if _, err := r.FileSystemOperator.GetInfo(folderPath); errors.Is(err, fs.ErrNotExist) {
+		log.Printf("%v is no longer on disk, not comparing its files", folderPath)
+		return nil
+	}
+	fileNames, err := r.Zipper.FilesToZip(folderPath)
+	if err != nil {
+		return fmt.Errorf("failed to list %v: %v", folderPath, err)
+	}
+
+	basePath := filepath.Base(folderPath)
+	archived := make(map[string]bool)
+	for _, file := range archivedFiles {
+		_, fileName, _ := strings.Cut(file, "/")
+		archived[path.Join(basePath, fileName)] = true
+	}
+	var missing []string
+	for _, fileName := range fileNames {
+		file := filepath.ToSlash(filepath.Join(basePath, fileName))
+		if !archived[file] {
+			missing = append(missing, file)
+		}
+		delete(archived, file)
+	}
+
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("missing from the archive: %v", strings.Join(missing, ", ")))
+	}
+	if len(archived) > 0 {
+		problems = append(problems, fmt.Sprintf("no longer on disk: %v",
+			strings.Join(slices.Sorted(maps.Keys(archived)), ", ")))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("archive does not match %v - %v", folderPath, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+
+	"github.com/apkatsikas/archiver/filter"
+)
+
+// useFileHashCache fingerprints folders with the hashes cached in the archive
+// DB, so only files that were added or changed since are read. It is a no-op
+// when running without a file hash cache.
+func (r *Runner) useFileHashCache() error {
+	if r.FileHashRepository == nil {
+		return nil
+	}
+	if err := r.FileHashRepository.CreateTable(); err != nil {
+		return fmt.Errorf("failed to CreateTable file hash: %v", err)
+	}
+	r.Zipper.SetFileHashCache(r.FileHashRepository)
+	return nil
+}
+
+// pruneFileHashCache forgets the cached hashes of files that are no longer in
+// the library - those outside every folder Navidrome has media in, and those
+// gone from disk, such as deleted, renamed or re-encoded files. It is a no-op
+// when running without a file hash cache.
+func (r *Runner) pruneFileHashCache() error {
+	if r.FileHashRepository == nil {
+		return nil
+	}
+
+	mediaFiles, err := r.MusicFoldersRepository.PresentMediaFiles()
+	if err != nil {
+		return fmt.Errorf("failed to get present media files: %v", err)
+	}
+	// As with deleted folders, an empty library is more likely a broken mount
+	// or scan than every file being gone.
+	if len(mediaFiles) == 0 {
+		return nil
+	}
+	absoluteMediaFiles, err := r.absoluteMediaFiles(mediaFiles)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute media files: %v", err)
+	}
+	presentFolders := r.FilterService.MediaFileIdsByFolder(absoluteMediaFiles)
+
+	cachedPaths, err := r.FileHashRepository.CachedPaths()
+	if err != nil {
+		return fmt.Errorf("failed to get cached file hashes: %v", err)
+	}
+	pruned := 0
+	for _, cachedPath := range cachedPaths {
+		if inPresentFolder(cachedPath, presentFolders) {
+			_, err := r.FileSystemOperator.GetInfo(cachedPath)
+			if !errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+		}
+		if err := r.FileHashRepository.DeleteCachedHash(cachedPath); err != nil {
+			return fmt.Errorf("failed to delete cached hash of %v: %v", cachedPath, err)
+		}
+		pruned++
+	}
+	log.Printf("Pruned %v cached file hashes", pruned)
+	return nil
+}
+
+// inPresentFolder reports whether the file is beneath one of the folders, at
+// any depth for folders archived recursively.
+func inPresentFolder(filePath string, presentFolders map[string][]string) bool {
+	for dir := filepath.Dir(filePath); ; dir = filepath.Dir(dir) {
+		if _, present := presentFolders[dir]; present {
+			return true
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return false
+		}
+	}
+}
+
+// isUnchanged checks whether the folder holds the same files as when it was
+// archived, as Navidrome marks media updated on rescans and tag edits that
+// may not change the files. An unchanged folder has its newest media time
+// moved on, so it is skipped without being fingerprinted next time.
+func (r *Runner) isUnchanged(pathId filter.PathIdentifier) (bool, error) {
+	if r.ArchivedFolderRepository == nil || pathId.Fingerprint == "" {
+		return false, nil
+	}
+
+	archivedFolder, err := r.ArchivedFolderRepository.ArchivedFolderByPath(pathId.FolderPath)
+	if err != nil || archivedFolder == nil {
+		return false, err
+	}
+	if archivedFolder.Fingerprint != pathId.Fingerprint ||
+		archivedFolder.Destination != r.FilterService.UploadDestination(pathId) {
+		return false, nil
+	}
+
+	archivedFolder.NewestMediaAt = pathId.NewestMediaAt
+	return true, r.ArchivedFolderRepository.SaveArchivedFolder(*archivedFolder)
+}
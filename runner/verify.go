@@ -0,0 +1,170 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/apkatsikas/archiver/filter"
+	storageclient "github.com/apkatsikas/archiver/storage-client"
+	"github.com/apkatsikas/archiver/zipper"
+)
+
+// VerifyReport compares what is in storage with what the library says
+// should be there.
+type VerifyReport struct {
+	// Missing folders have no object in storage.
+	Missing []VerifiedFolder `json:"missing"`
+	// Stale folders have media newer than their object in storage.
+	Stale []VerifiedFolder `json:"stale"`
+	// Orphaned objects do not belong to any folder in the library, and are
+	// not under the deleted prefix.
+	Orphaned []OrphanedObject `json:"orphaned"`
+	Folders  int              `json:"folders"`
+	Objects  int              `json:"objects"`
+}
+
+type VerifiedFolder struct {
+	Folder        string     `json:"folder"`
+	Object        string     `json:"object"`
+	NewestMediaAt time.Time  `json:"newestMediaAt"`
+	StoredAt      *time.Time `json:"storedAt,omitempty"`
+}
+
+type OrphanedObject struct {
+	Object   string    `json:"object"`
+	Size     int64     `json:"size"`
+	StoredAt time.Time `json:"storedAt"`
+}
+
+// OK reports whether storage holds an up to date object for every folder and
+// nothing else.
+func (vr *VerifyReport) OK() bool {
+	return len(vr.Missing) == 0 && len(vr.Stale) == 0 && len(vr.Orphaned) == 0
+}
+
+// Summary is a human readable version of the report, listing every problem.
+func (vr *VerifyReport) Summary() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v folders checked against %v objects - %v missing, %v stale, %v orphaned",
+		vr.Folders, vr.Objects, len(vr.Missing), len(vr.Stale), len(vr.Orphaned))
+	for _, folder := range vr.Missing {
+		fmt.Fprintf(&sb, "\n- missing: %v (expected %v)", folder.Folder, folder.Object)
+	}
+	for _, folder := range vr.Stale {
+		fmt.Fprintf(&sb, "\n- stale: %v (%v stored %v, media updated %v)", folder.Folder, folder.Object,
+			folder.StoredAt.Format(time.RFC3339), folder.NewestMediaAt.Format(time.RFC3339))
+	}
+	for _, object := range vr.Orphaned {
+		fmt.Fprintf(&sb, "\n- orphaned: %v", object.Object)
+	}
+	return sb.String()
+}
+
+// RunVerify lists storage and reconciles it with every folder in the
+// Navidrome DB, writing the report to ReportFile if one is set. With an
+// archive DB, folders are expected under their stable identities.
+func (r *Runner) RunVerify() (*VerifyReport, error) {
+	mediaFiles, err := r.MusicFoldersRepository.AllMediaFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed get media files from repository: %v", err)
+	}
+	absoluteMediaFiles, err := r.absoluteMediaFiles(mediaFiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute media files: %v", err)
+	}
+	identifiedPaths := r.FilterService.IdentifiedPaths(absoluteMediaFiles, filter.NewMedia)
+	if err := r.applyFolderIdentities(identifiedPaths); err != nil {
+		return nil, fmt.Errorf("failed to get folder identities: %v", err)
+	}
+
+	backupFiles, err := r.StorageClient.ListFiles("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage: %v", err)
+	}
+
+	report := r.verify(identifiedPaths, backupFiles)
+	log.Printf("Verify report: %v", report.Summary())
+	r.writeReport(report)
+	return report, nil
+}
+
+// applyFolderIdentities names the folders after their stable identities, when
+// there is an archive DB to read them from.
+func (r *Runner) applyFolderIdentities(identifiedPaths filter.IdentifiedPaths) error {
+	if r.ArchivedFolderRepository == nil {
+		return nil
+	}
+	if err := r.ArchivedFolderRepository.CreateTable(); err != nil {
+		return err
+	}
+	for folderPath, pathId := range identifiedPaths {
+		pathId, err := r.applyFolderIdentity(folderPath, pathId)
+		if err != nil {
+			return err
+		}
+		identifiedPaths[folderPath] = pathId
+	}
+	return nil
+}
+
+func (r *Runner) verify(identifiedPaths filter.IdentifiedPaths, backupFiles []storageclient.BackupFile) *VerifyReport {
+	report := &VerifyReport{Folders: len(identifiedPaths), Objects: len(backupFiles)}
+
+	stored := make(map[string]storageclient.BackupFile)
+	for _, backupFile := range backupFiles {
+		stored[backupFile.Name] = backupFile
+	}
+	// The Navidrome DB backup is the only object that is not a folder, and was
+	// stored uncompressed by earlier releases.
+	claimed := map[string]bool{navidromeBackupObject: true, navidromeBackupDB: true}
+	// Destinations of split folders, whose parts belong to them.
+	splitDestinations := make(map[string]bool)
+
+	for _, folderPath := range slices.Sorted(maps.Keys(identifiedPaths)) {
+		pathId := identifiedPaths[folderPath]
+		destination := r.FilterService.UploadDestination(pathId)
+		folder := VerifiedFolder{Folder: folderPath, Object: destination, NewestMediaAt: pathId.NewestMediaAt}
+		claimed[destination] = true
+
+		// A split folder is stored once its parts manifest is, which is
+		// uploaded after every part.
+		backupFile, found := stored[destination]
+		if manifest, isSplit := stored[partsManifestName(destination)]; isSplit {
+			claimed[manifest.Name] = true
+			splitDestinations[destination] = true
+			if !found {
+				backupFile, found = manifest, true
+			}
+		}
+
+		switch {
+		case !found:
+			report.Missing = append(report.Missing, folder)
+		case backupFile.Updated.Before(pathId.NewestMediaAt):
+			folder.StoredAt = &backupFile.Updated
+			report.Stale = append(report.Stale, folder)
+		}
+	}
+
+	for _, backupFile := range backupFiles {
+		// Objects of deleted folders, kept versions and DB snapshots are under
+		// their own prefixes.
+		if claimed[backupFile.Name] || strings.HasPrefix(backupFile.Name, deletedPrefix) ||
+			strings.HasPrefix(backupFile.Name, versionsPrefix) || strings.HasPrefix(backupFile.Name, dbBackupsPrefix) {
+			continue
+		}
+		if wholeName, _, isPart := zipper.ParsePartName(backupFile.Name); isPart && splitDestinations[wholeName] {
+			continue
+		}
+		report.Orphaned = append(report.Orphaned, OrphanedObject{
+			Object: backupFile.Name, Size: backupFile.Size, StoredAt: backupFile.Updated})
+	}
+	slices.SortFunc(report.Orphaned, func(a, b OrphanedObject) int {
+		return strings.Compare(a.Object, b.Object)
+	})
+	return report
+}
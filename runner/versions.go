@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/apkatsikas/archiver/db"
+	"github.com/apkatsikas/archiver/filter"
+)
+
+const (
+	versionsPrefix = "versions/"
+	versionFormat  = "20060102T150405Z"
+)
+
+// versionPrefix is where the objects of a kept version are copied to, under
+// the names they had when the version was current.
+func versionPrefix(version string) string {
+	return versionsPrefix + version + "/"
+}
+
+// keepVersion copies the current archive of the folder under its version
+// prefix before it is replaced, then prunes the folder's versions. It is a
+// no-op unless KeepVersions is set.
+func (r *Runner) keepVersion(pathId filter.PathIdentifier) error {
+	if r.KeepVersions <= 0 || r.ArchiveVersionRepository == nil || r.ArchivedFolderRepository == nil {
+		return nil
+	}
+
+	archivedFolder, err := r.ArchivedFolderRepository.ArchivedFolderByPath(pathId.FolderPath)
+	if err != nil || archivedFolder == nil {
+		return err
+	}
+	// An archive under another destination is not replaced, so is left where it is.
+	if archivedFolder.Destination != r.FilterService.UploadDestination(pathId) {
+		return nil
+	}
+
+	versions, err := r.ArchiveVersionRepository.ArchiveVersionsByPath(pathId.FolderPath)
+	if err != nil {
+		return err
+	}
+	// A retry of a failed upload has already kept the archive it replaces.
+	if len(versions) > 0 && versions[0].Checksum == archivedFolder.Checksum &&
+		versions[0].CRC32C == archivedFolder.CRC32C {
+		return nil
+	}
+
+	objects, err := r.destinationObjects(archivedFolder.Destination, "")
+	if err != nil || len(objects) == 0 {
+		return err
+	}
+
+	replacedAt := time.Now().UTC()
+	version := replacedAt.Format(versionFormat)
+	for _, object := range objects {
+		log.Printf("Keeping %v as %v", object, versionPrefix(version)+object)
+		if err := r.StorageClient.CopyFile(object, versionPrefix(version)+object); err != nil {
+			return err
+		}
+	}
+	err = r.ArchiveVersionRepository.SaveArchiveVersion(db.ArchiveVersion{
+		Path:        archivedFolder.Path,
+		Version:     version,
+		Destination: archivedFolder.Destination,
+		Size:        archivedFolder.Size,
+		Checksum:    archivedFolder.Checksum,
+		CRC32C:      archivedFolder.CRC32C,
+		UploadedAt:  archivedFolder.UploadedAt,
+		ReplacedAt:  replacedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record version %v: %v", version, err)
+	}
+	return r.pruneVersions(pathId.FolderPath)
+}
+
+// pruneAllVersions prunes the versions of every folder, so versions past
+// VersionMaxAge go even when their folder is not archived again.
+func (r *Runner) pruneAllVersions() error {
+	if r.KeepVersions <= 0 || r.ArchiveVersionRepository == nil {
+		return nil
+	}
+
+	versions, err := r.ArchiveVersionRepository.AllArchiveVersions()
+	if err != nil {
+		return fmt.Errorf("failed to get archive versions: %v", err)
+	}
+	pruned := make(map[string]bool)
+	for _, version := range versions {
+		if pruned[version.Path] {
+			continue
+		}
+		pruned[version.Path] = true
+		if err := r.pruneVersions(version.Path); err != nil {
+			return fmt.Errorf("failed to prune versions of %v: %v", version.Path, err)
+		}
+	}
+	return nil
+}
+
+// pruneVersions deletes the versions of a folder beyond the newest
+// KeepVersions, and those replaced longer ago than VersionMaxAge.
+func (r *Runner) pruneVersions(folderPath string) error {
+	versions, err := r.ArchiveVersionRepository.ArchiveVersionsByPath(folderPath)
+	if err != nil {
+		return err
+	}
+
+	for i, version := range versions {
+		expired := r.VersionMaxAge > 0 && time.Since(version.ReplacedAt) > r.VersionMaxAge
+		if i < r.KeepVersions && !expired {
+			continue
+		}
+		objects, err := r.destinationObjects(version.Destination, versionPrefix(version.Version))
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			log.Printf("Pruning %v", object)
+			if err := r.StorageClient.DeleteFile(object); err != nil {
+				return err
+			}
+		}
+		if err := r.ArchiveVersionRepository.DeleteArchiveVersion(version.Path, version.Version); err != nil {
+			return fmt.Errorf("failed to forget version %v: %v", version.Version, err)
+		}
+	}
+	return nil
+}
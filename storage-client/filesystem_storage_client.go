@@ -0,0 +1,411 @@
+package storageclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	tempFilePrefix  = ".navarchiver-"
+	tempFilePattern = tempFilePrefix + "*.tmp"
+	// Metadata is kept in a hidden file next to its object, which is left out
+	// of listings along with temporary files.
+	metadataFilePrefix = tempFilePrefix + "metadata-"
+	claimFilePrefix    = tempFilePrefix + "claim-"
+	// A claim is only held while finished content is renamed into place, so
+	// one this old was left behind by a crash.
+	staleClaimAge = time.Minute
+)
+
+// FileSystemStorageClient stores objects as files beneath a directory,
+// such as a mounted USB disk or NAS share.
+type FileSystemStorageClient struct {
+	rootPath string
+}
+
+func NewFileSystem() *FileSystemStorageClient {
+	rootPath := os.Getenv("FILESYSTEM_STORAGE_PATH")
+	if rootPath == "" {
+		panic("FILESYSTEM_STORAGE_PATH must be set")
+	}
+	info, err := os.Stat(rootPath)
+	if err != nil {
+		panic(fmt.Sprintf("could not stat FILESYSTEM_STORAGE_PATH %v: %v", rootPath, err))
+	}
+	if !info.IsDir() {
+		panic(fmt.Sprintf("FILESYSTEM_STORAGE_PATH %v is not a directory", rootPath))
+	}
+
+	return &FileSystemStorageClient{rootPath: rootPath}
+}
+
+func (sc *FileSystemStorageClient) ReplaceFile(path string, destObject string) error {
+	return sc.ReplaceStream(fileStreamWriter(path), destObject)
+}
+
+func (sc *FileSystemStorageClient) UploadNewFile(path string, destObject string) error {
+	return sc.UploadNewStream(fileStreamWriter(path), destObject)
+}
+
+// ReplaceStream writes the content to a temporary file next to the object and
+// renames it into place.
+func (sc *FileSystemStorageClient) ReplaceStream(write StreamWriter, destObject string) error {
+	destPath, err := sc.objectPath(destObject)
+	if err != nil {
+		return err
+	}
+
+	// Same semantics as the GCS generation-match precondition - only an
+	// existing object can be replaced.
+	_, err = os.Stat(destPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot replace %v: %w", destObject, ErrObjectNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting object attributes: %v", err)
+	}
+
+	tempPath, _, err := sc.writeTempFile(write, destPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempPath, destPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error on rename of %v to %v: %v", tempPath, destPath, err)
+	}
+	// A replaced object has none of the metadata of the one before, as in GCS.
+	return removeMetadataFile(destPath)
+}
+
+// UploadNewStream writes the content to a temporary file next to the object and
+// moves it into place, unless the object already exists.
+func (sc *FileSystemStorageClient) UploadNewStream(write StreamWriter, destObject string) error {
+	destPath, err := sc.objectPath(destObject)
+	if err != nil {
+		return err
+	}
+
+	tempPath, sums, err := sc.writeTempFile(write, destPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+
+	// Linking fails if the destination exists, which gives us the GCS
+	// DoesNotExist precondition atomically.
+	err = os.Link(tempPath, destPath)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, os.ErrExist) {
+		return checkExistingFile(sums, destPath, destObject)
+	}
+
+	// Some filesystems, such as exFAT or SMB shares, do not support hard links.
+	// The name is claimed with a hidden file next to it instead, so nothing is
+	// at the destination until the content is renamed into place.
+	release, err := claimName(destPath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = os.Stat(destPath)
+	if err == nil {
+		return checkExistingFile(sums, destPath, destObject)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error getting object attributes: %v", err)
+	}
+	if err := os.Rename(tempPath, destPath); err != nil {
+		return fmt.Errorf("error on rename of %v to %v: %v", tempPath, destPath, err)
+	}
+	return nil
+}
+
+// claimName creates the claim file of destPath exclusively, taking over a
+// stale claim left by a crash. The returned function releases the claim.
+func claimName(destPath string) (func(), error) {
+	claimPath := filepath.Join(filepath.Dir(destPath), claimFilePrefix+filepath.Base(destPath))
+	claim, err := os.OpenFile(claimPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		info, statErr := os.Stat(claimPath)
+		if statErr != nil || time.Since(info.ModTime()) < staleClaimAge {
+			return nil, fmt.Errorf("%v is being uploaded by another run", destPath)
+		}
+		if err := os.Remove(claimPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("error removing stale claim %v: %v", claimPath, err)
+		}
+		claim, err = os.OpenFile(claimPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error claiming %v: %v", destPath, err)
+	}
+	if err := claim.Close(); err != nil {
+		os.Remove(claimPath)
+		return nil, fmt.Errorf("error claiming %v: %v", destPath, err)
+	}
+	return func() { os.Remove(claimPath) }, nil
+}
+
+// checkExistingFile treats an existing object holding exactly the uploaded
+// content as a finished upload from an earlier, interrupted run.
+func checkExistingFile(sums *checksums, destPath string, destObject string) error {
+	existing, err := fileChecksums(destPath)
+	if err != nil {
+		return fmt.Errorf("object %v already exists and could not be read: %v", destObject, err)
+	}
+	if existing.size != sums.size || !bytes.Equal(existing.md5.Sum(nil), sums.md5.Sum(nil)) {
+		return fmt.Errorf("object %v already exists with different content", destObject)
+	}
+	logIdenticalObject(destObject)
+	return nil
+}
+
+func (sc *FileSystemStorageClient) DownloadFile(srcObject string, path string) error {
+	srcPath, err := sc.objectPath(srcObject)
+	if err != nil {
+		return err
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("error opening object %v: %v", srcObject, err)
+	}
+	defer srcFile.Close()
+
+	return writeToFile(srcFile, path)
+}
+
+// ListFiles walks the directory of the prefix, rather than the whole storage
+// path, as it is called for every folder archived.
+func (sc *FileSystemStorageClient) ListFiles(prefix string) ([]BackupFile, error) {
+	walkRoot := sc.rootPath
+	if prefixDir := path.Dir(prefix); prefixDir != "." {
+		var err error
+		if walkRoot, err = sc.objectPath(prefixDir); err != nil {
+			return nil, err
+		}
+	}
+
+	var backupFiles []BackupFile
+	err := filepath.WalkDir(walkRoot, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == walkRoot {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || isTempFile(entry.Name()) {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(sc.rootPath, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relativePath)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		backupFiles = append(backupFiles, BackupFile{
+			Name:    name,
+			Updated: info.ModTime(),
+			Size:    info.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %v: %v", sc.rootPath, err)
+	}
+	return backupFiles, nil
+}
+
+// CopyFile writes the copy to a temporary file next to the destination and
+// renames it into place, replacing any object already there.
+func (sc *FileSystemStorageClient) CopyFile(srcObject string, destObject string) error {
+	srcPath, err := sc.objectPath(srcObject)
+	if err != nil {
+		return err
+	}
+	destPath, err := sc.objectPath(destObject)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(srcPath); err != nil {
+		return fmt.Errorf("error copying %v to %v: %v", srcObject, destObject, err)
+	}
+
+	tempPath, _, err := sc.writeTempFile(fileStreamWriter(srcPath), destPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, destPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error on rename of %v to %v: %v", tempPath, destPath, err)
+	}
+
+	metadata, err := os.ReadFile(metadataPath(srcPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return removeMetadataFile(destPath)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metadataPath(destPath), metadata, 0644)
+}
+
+func (sc *FileSystemStorageClient) MoveFile(srcObject string, destObject string) error {
+	srcPath, err := sc.objectPath(srcObject)
+	if err != nil {
+		return err
+	}
+	destPath, err := sc.objectPath(destObject)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(srcPath, destPath); err != nil {
+		return fmt.Errorf("error moving %v to %v: %v", srcObject, destObject, err)
+	}
+
+	err = os.Rename(metadataPath(srcPath), metadataPath(destPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return removeMetadataFile(destPath)
+	}
+	return err
+}
+
+func (sc *FileSystemStorageClient) DeleteFile(object string) error {
+	objectPath, err := sc.objectPath(object)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(objectPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot delete %v: %w", object, ErrObjectNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting %v: %v", object, err)
+	}
+	return removeMetadataFile(objectPath)
+}
+
+// SetMetadata replaces the metadata of the object, which is written as JSON
+// to a hidden file next to it.
+func (sc *FileSystemStorageClient) SetMetadata(object string, metadata map[string]string) error {
+	objectPath, err := sc.objectPath(object)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(objectPath); err != nil {
+		return fmt.Errorf("error setting metadata of %v: %v", object, err)
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	write := func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+	tempPath, _, err := sc.writeTempFile(write, objectPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, metadataPath(objectPath)); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error setting metadata of %v: %v", object, err)
+	}
+	return nil
+}
+
+func metadataPath(objectPath string) string {
+	return filepath.Join(filepath.Dir(objectPath), metadataFilePrefix+filepath.Base(objectPath)+".json")
+}
+
+func removeMetadataFile(objectPath string) error {
+	if err := os.Remove(metadataPath(objectPath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (sc *FileSystemStorageClient) objectPath(destObject string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(destObject)) {
+		return "", fmt.Errorf("invalid object name %v", destObject)
+	}
+	return filepath.Join(sc.rootPath, filepath.FromSlash(destObject)), nil
+}
+
+// writeTempFile writes the content next to destPath, so it can be renamed into
+// place. The file is read back and checked against the checksums of what was
+// written, which are returned with its path.
+func (sc *FileSystemStorageClient) writeTempFile(write StreamWriter, destPath string) (string, *checksums, error) {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return "", nil, err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(destPath), tempFilePattern)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sums := newChecksums()
+	if err := write(io.MultiWriter(tempFile, sums)); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return "", nil, fmt.Errorf("error on writing %v: %w", tempFile.Name(), err)
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return "", nil, fmt.Errorf("error on Sync of %v: %v", tempFile.Name(), err)
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return "", nil, fmt.Errorf("error on Close of %v: %v", tempFile.Name(), err)
+	}
+
+	if err := verifyFile(tempFile.Name(), sums); err != nil {
+		os.Remove(tempFile.Name())
+		return "", nil, err
+	}
+	return tempFile.Name(), sums, nil
+}
+
+// verifyFile reads back the file at path and compares its CRC32C with what
+// was written to it.
+func verifyFile(path string, sums *checksums) error {
+	written, err := fileChecksums(path)
+	if err != nil {
+		return err
+	}
+	if written.size != sums.size || written.crc32c.Sum32() != sums.crc32c.Sum32() {
+		return fmt.Errorf("%v was stored with CRC32C %08x, expected %08x",
+			path, written.crc32c.Sum32(), sums.crc32c.Sum32())
+	}
+	return nil
+}
+
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix)
+}
@@ -0,0 +1,232 @@
+package storageclient_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	storageclient "github.com/apkatsikas/archiver/storage-client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FileSystemStorageClient", func() {
+	const destObject = "huey lewis - sports5c214deb5b2dba739e0d6af56f61d1c7.zip"
+
+	var client *storageclient.FileSystemStorageClient
+	var storagePath string
+	var destPath string
+	var localFile string
+
+	BeforeEach(func() {
+		gt := GinkgoT()
+		storagePath = gt.TempDir()
+		destPath = filepath.Join(storagePath, destObject)
+		gt.Setenv("FILESYSTEM_STORAGE_PATH", storagePath)
+
+		localFile = filepath.Join(gt.TempDir(), "upload.zip")
+		Expect(os.WriteFile(localFile, []byte("new zip"), 0644)).To(BeNil())
+
+		client = storageclient.NewFileSystem()
+	})
+
+	Context("When the object does not exist", func() {
+		It("Uploads a new file", func() {
+			Expect(client.UploadNewFile(localFile, destObject)).To(BeNil())
+			Expect(os.ReadFile(destPath)).To(Equal([]byte("new zip")))
+		})
+
+		It("Uploads a new file beneath a prefix", func() {
+			Expect(client.UploadNewFile(localFile, "prefix/"+destObject)).To(BeNil())
+			Expect(os.ReadFile(filepath.Join(storagePath, "prefix", destObject))).To(Equal([]byte("new zip")))
+		})
+
+		It("Refuses to replace the file", func() {
+			Expect(client.ReplaceFile(localFile, destObject)).To(MatchError(storageclient.ErrObjectNotFound))
+			Expect(destPath).To(Not(BeAnExistingFile()))
+		})
+
+		It("Refuses an object name outside of the storage path", func() {
+			Expect(client.UploadNewFile(localFile, "../"+destObject)).To(Not(BeNil()))
+		})
+	})
+
+	Context("When the object already exists", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(destPath, []byte("old zip"), 0644)).To(BeNil())
+		})
+
+		It("Refuses to upload a new file", func() {
+			Expect(client.UploadNewFile(localFile, destObject)).To(Not(BeNil()))
+			Expect(os.ReadFile(destPath)).To(Equal([]byte("old zip")))
+		})
+
+		It("Replaces the file", func() {
+			Expect(client.ReplaceFile(localFile, destObject)).To(BeNil())
+			Expect(os.ReadFile(destPath)).To(Equal([]byte("new zip")))
+		})
+	})
+
+	Context("When the object already exists with identical content", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(destPath, []byte("new zip"), 0644)).To(BeNil())
+		})
+
+		It("Treats the new file as uploaded", func() {
+			Expect(client.UploadNewFile(localFile, destObject)).To(BeNil())
+			Expect(os.ReadFile(destPath)).To(Equal([]byte("new zip")))
+		})
+	})
+
+	Context("When listing and downloading", func() {
+		BeforeEach(func() {
+			Expect(client.UploadNewFile(localFile, destObject)).To(BeNil())
+			Expect(client.UploadNewFile(localFile, "deleted/"+destObject)).To(BeNil())
+		})
+
+		It("Lists objects beneath a prefix", func() {
+			backupFiles, err := client.ListFiles("deleted/")
+			Expect(err).To(BeNil())
+			Expect(backupFiles).To(HaveLen(1))
+			Expect(backupFiles[0].Name).To(Equal("deleted/" + destObject))
+			Expect(backupFiles[0].Size).To(Equal(int64(len("new zip"))))
+		})
+
+		It("Lists every object", func() {
+			Expect(client.ListFiles("")).To(HaveLen(2))
+		})
+
+		It("Lists objects whose names start with a prefix ending part way through a name", func() {
+			Expect(client.ListFiles("del")).To(HaveLen(1))
+			Expect(client.ListFiles("deleted/" + destObject[:3])).To(HaveLen(1))
+		})
+
+		It("Lists nothing beneath a prefix with no objects", func() {
+			Expect(client.ListFiles("versions/")).To(BeEmpty())
+		})
+
+		It("Downloads an object", func() {
+			downloadPath := filepath.Join(GinkgoT().TempDir(), "download.zip")
+			Expect(client.DownloadFile(destObject, downloadPath)).To(BeNil())
+			Expect(os.ReadFile(downloadPath)).To(Equal([]byte("new zip")))
+		})
+
+		It("Fails to download a missing object", func() {
+			downloadPath := filepath.Join(GinkgoT().TempDir(), "download.zip")
+			Expect(client.DownloadFile("missing.zip", downloadPath)).To(Not(BeNil()))
+		})
+	})
+
+	Context("When moving and deleting", func() {
+		BeforeEach(func() {
+			Expect(client.UploadNewFile(localFile, destObject)).To(BeNil())
+		})
+
+		It("Moves an object beneath a prefix", func() {
+			Expect(client.MoveFile(destObject, "deleted/"+destObject)).To(BeNil())
+			Expect(destPath).To(Not(BeAnExistingFile()))
+			Expect(os.ReadFile(filepath.Join(storagePath, "deleted", destObject))).To(Equal([]byte("new zip")))
+		})
+
+		It("Copies an object beneath a prefix", func() {
+			Expect(client.CopyFile(destObject, "versions/1/"+destObject)).To(BeNil())
+			Expect(os.ReadFile(destPath)).To(Equal([]byte("new zip")))
+			Expect(os.ReadFile(filepath.Join(storagePath, "versions", "1", destObject))).To(Equal([]byte("new zip")))
+		})
+
+		It("Fails to copy a missing object", func() {
+			Expect(client.CopyFile("missing.zip", "versions/1/missing.zip")).To(Not(BeNil()))
+		})
+
+		It("Deletes an object", func() {
+			Expect(client.DeleteFile(destObject)).To(BeNil())
+			Expect(destPath).To(Not(BeAnExistingFile()))
+		})
+
+		It("Fails to delete a missing object", func() {
+			Expect(client.DeleteFile("missing.zip")).To(MatchError(storageclient.ErrObjectNotFound))
+		})
+	})
+
+	Context("When the object has metadata", func() {
+		metadataFile := func(dir string, object string) string {
+			return filepath.Join(dir, ".navarchiver-metadata-"+object+".json")
+		}
+
+		BeforeEach(func() {
+			Expect(client.UploadNewFile(localFile, destObject)).To(BeNil())
+			Expect(client.SetMetadata(destObject, map[string]string{"navidrome-schema-version": "20240122"})).To(BeNil())
+		})
+
+		It("Stores the metadata next to the object", func() {
+			Expect(os.ReadFile(metadataFile(storagePath, destObject))).To(
+				MatchJSON(`{"navidrome-schema-version": "20240122"}`))
+		})
+
+		It("Leaves the metadata out of listings", func() {
+			backupFiles, err := client.ListFiles("")
+			Expect(err).To(BeNil())
+			Expect(backupFiles).To(HaveLen(1))
+			Expect(backupFiles[0].Name).To(Equal(destObject))
+		})
+
+		It("Keeps the metadata with a copy or a moved object", func() {
+			Expect(client.CopyFile(destObject, "versions/1/"+destObject)).To(BeNil())
+			Expect(metadataFile(filepath.Join(storagePath, "versions", "1"), destObject)).To(BeAnExistingFile())
+
+			Expect(client.MoveFile(destObject, "deleted/"+destObject)).To(BeNil())
+			Expect(metadataFile(storagePath, destObject)).To(Not(BeAnExistingFile()))
+			Expect(metadataFile(filepath.Join(storagePath, "deleted"), destObject)).To(BeAnExistingFile())
+		})
+
+		It("Drops the metadata when the object is replaced or deleted", func() {
+			Expect(client.ReplaceFile(localFile, destObject)).To(BeNil())
+			Expect(metadataFile(storagePath, destObject)).To(Not(BeAnExistingFile()))
+
+			Expect(client.SetMetadata(destObject, map[string]string{"navidrome-schema-version": "20240122"})).To(BeNil())
+			Expect(client.DeleteFile(destObject)).To(BeNil())
+			entries, err := os.ReadDir(storagePath)
+			Expect(err).To(BeNil())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("Fails to set metadata of a missing object", func() {
+			Expect(client.SetMetadata("missing.zip", map[string]string{})).To(Not(BeNil()))
+		})
+	})
+
+	Context("When streaming", func() {
+		It("Uploads a new stream", func() {
+			write := func(w io.Writer) error {
+				_, err := w.Write([]byte("streamed zip"))
+				return err
+			}
+			Expect(client.UploadNewStream(write, destObject)).To(BeNil())
+			Expect(os.ReadFile(destPath)).To(Equal([]byte("streamed zip")))
+		})
+
+		It("Does not store anything when the stream fails", func() {
+			write := func(w io.Writer) error {
+				w.Write([]byte("partial"))
+				return errors.New("file too large")
+			}
+			Expect(client.UploadNewStream(write, destObject)).To(Not(BeNil()))
+
+			entries, err := os.ReadDir(storagePath)
+			Expect(err).To(BeNil())
+			Expect(entries).To(BeEmpty())
+		})
+	})
+
+	It("Does not leave temporary files behind", func() {
+		Expect(client.UploadNewFile(localFile, destObject)).To(BeNil())
+		Expect(client.ReplaceFile(localFile, destObject)).To(BeNil())
+		Expect(client.UploadNewFile(localFile, destObject)).To(BeNil())
+
+		entries, err := os.ReadDir(storagePath)
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name()).To(Equal(destObject))
+	})
+})
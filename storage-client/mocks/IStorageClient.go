@@ -1,8 +1,11 @@
-// Code generated by mockery v2.23.4. DO NOT EDIT.
+// Code generated by mockery v2.53.7. DO NOT EDIT.
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	storageclient "github.com/apkatsikas/archiver/storage-client"
+	mock "github.com/stretchr/testify/mock"
+)
 
 // IStorageClient is an autogenerated mock type for the IStorageClient type
 type IStorageClient struct {
@@ -17,10 +20,259 @@ func (_m *IStorageClient) EXPECT() *IStorageClient_Expecter {
 	return &IStorageClient_Expecter{mock: &_m.Mock}
 }
 
+// CopyFile provides a mock function with given fields: srcObject, destObject
+func (_m *IStorageClient) CopyFile(srcObject string, destObject string) error {
+	ret := _m.Called(srcObject, destObject)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CopyFile")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(srcObject, destObject)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IStorageClient_CopyFile_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CopyFile'
+type IStorageClient_CopyFile_Call struct {
+	*mock.Call
+}
+
+// CopyFile is a helper method to define mock.On call
+//   - srcObject string
+//   - destObject string
+func (_e *IStorageClient_Expecter) CopyFile(srcObject interface{}, destObject interface{}) *IStorageClient_CopyFile_Call {
+	return &IStorageClient_CopyFile_Call{Call: _e.mock.On("CopyFile", srcObject, destObject)}
+}
+
+func (_c *IStorageClient_CopyFile_Call) Run(run func(srcObject string, destObject string)) *IStorageClient_CopyFile_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *IStorageClient_CopyFile_Call) Return(_a0 error) *IStorageClient_CopyFile_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *IStorageClient_CopyFile_Call) RunAndReturn(run func(string, string) error) *IStorageClient_CopyFile_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// DeleteFile provides a mock function with given fields: object
+func (_m *IStorageClient) DeleteFile(object string) error {
+	ret := _m.Called(object)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteFile")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(object)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IStorageClient_DeleteFile_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DeleteFile'
+type IStorageClient_DeleteFile_Call struct {
+	*mock.Call
+}
+
+// DeleteFile is a helper method to define mock.On call
+//   - object string
+func (_e *IStorageClient_Expecter) DeleteFile(object interface{}) *IStorageClient_DeleteFile_Call {
+	return &IStorageClient_DeleteFile_Call{Call: _e.mock.On("DeleteFile", object)}
+}
+
+func (_c *IStorageClient_DeleteFile_Call) Run(run func(object string)) *IStorageClient_DeleteFile_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *IStorageClient_DeleteFile_Call) Return(_a0 error) *IStorageClient_DeleteFile_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *IStorageClient_DeleteFile_Call) RunAndReturn(run func(string) error) *IStorageClient_DeleteFile_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// DownloadFile provides a mock function with given fields: srcObject, path
+func (_m *IStorageClient) DownloadFile(srcObject string, path string) error {
+	ret := _m.Called(srcObject, path)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DownloadFile")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(srcObject, path)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IStorageClient_DownloadFile_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DownloadFile'
+type IStorageClient_DownloadFile_Call struct {
+	*mock.Call
+}
+
+// DownloadFile is a helper method to define mock.On call
+//   - srcObject string
+//   - path string
+func (_e *IStorageClient_Expecter) DownloadFile(srcObject interface{}, path interface{}) *IStorageClient_DownloadFile_Call {
+	return &IStorageClient_DownloadFile_Call{Call: _e.mock.On("DownloadFile", srcObject, path)}
+}
+
+func (_c *IStorageClient_DownloadFile_Call) Run(run func(srcObject string, path string)) *IStorageClient_DownloadFile_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *IStorageClient_DownloadFile_Call) Return(_a0 error) *IStorageClient_DownloadFile_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *IStorageClient_DownloadFile_Call) RunAndReturn(run func(string, string) error) *IStorageClient_DownloadFile_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ListFiles provides a mock function with given fields: prefix
+func (_m *IStorageClient) ListFiles(prefix string) ([]storageclient.BackupFile, error) {
+	ret := _m.Called(prefix)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListFiles")
+	}
+
+	var r0 []storageclient.BackupFile
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) ([]storageclient.BackupFile, error)); ok {
+		return rf(prefix)
+	}
+	if rf, ok := ret.Get(0).(func(string) []storageclient.BackupFile); ok {
+		r0 = rf(prefix)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storageclient.BackupFile)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(prefix)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IStorageClient_ListFiles_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ListFiles'
+type IStorageClient_ListFiles_Call struct {
+	*mock.Call
+}
+
+// ListFiles is a helper method to define mock.On call
+//   - prefix string
+func (_e *IStorageClient_Expecter) ListFiles(prefix interface{}) *IStorageClient_ListFiles_Call {
+	return &IStorageClient_ListFiles_Call{Call: _e.mock.On("ListFiles", prefix)}
+}
+
+func (_c *IStorageClient_ListFiles_Call) Run(run func(prefix string)) *IStorageClient_ListFiles_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *IStorageClient_ListFiles_Call) Return(_a0 []storageclient.BackupFile, _a1 error) *IStorageClient_ListFiles_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *IStorageClient_ListFiles_Call) RunAndReturn(run func(string) ([]storageclient.BackupFile, error)) *IStorageClient_ListFiles_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// MoveFile provides a mock function with given fields: srcObject, destObject
+func (_m *IStorageClient) MoveFile(srcObject string, destObject string) error {
+	ret := _m.Called(srcObject, destObject)
+
+	if len(ret) == 0 {
+		panic("no return value specified for MoveFile")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(srcObject, destObject)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IStorageClient_MoveFile_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'MoveFile'
+type IStorageClient_MoveFile_Call struct {
+	*mock.Call
+}
+
+// MoveFile is a helper method to define mock.On call
+//   - srcObject string
+//   - destObject string
+func (_e *IStorageClient_Expecter) MoveFile(srcObject interface{}, destObject interface{}) *IStorageClient_MoveFile_Call {
+	return &IStorageClient_MoveFile_Call{Call: _e.mock.On("MoveFile", srcObject, destObject)}
+}
+
+func (_c *IStorageClient_MoveFile_Call) Run(run func(srcObject string, destObject string)) *IStorageClient_MoveFile_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *IStorageClient_MoveFile_Call) Return(_a0 error) *IStorageClient_MoveFile_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *IStorageClient_MoveFile_Call) RunAndReturn(run func(string, string) error) *IStorageClient_MoveFile_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // ReplaceFile provides a mock function with given fields: path, destObject
 func (_m *IStorageClient) ReplaceFile(path string, destObject string) error {
 	ret := _m.Called(path, destObject)
 
+	if len(ret) == 0 {
+		panic("no return value specified for ReplaceFile")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(string, string) error); ok {
 		r0 = rf(path, destObject)
@@ -60,10 +312,108 @@ func (_c *IStorageClient_ReplaceFile_Call) RunAndReturn(run func(string, string)
 	return _c
 }
 
+// ReplaceStream provides a mock function with given fields: write, destObject
+func (_m *IStorageClient) ReplaceStream(write storageclient.StreamWriter, destObject string) error {
+	ret := _m.Called(write, destObject)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReplaceStream")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(storageclient.StreamWriter, string) error); ok {
+		r0 = rf(write, destObject)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IStorageClient_ReplaceStream_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReplaceStream'
+type IStorageClient_ReplaceStream_Call struct {
+	*mock.Call
+}
+
+// ReplaceStream is a helper method to define mock.On call
+//   - write storageclient.StreamWriter
+//   - destObject string
+func (_e *IStorageClient_Expecter) ReplaceStream(write interface{}, destObject interface{}) *IStorageClient_ReplaceStream_Call {
+	return &IStorageClient_ReplaceStream_Call{Call: _e.mock.On("ReplaceStream", write, destObject)}
+}
+
+func (_c *IStorageClient_ReplaceStream_Call) Run(run func(write storageclient.StreamWriter, destObject string)) *IStorageClient_ReplaceStream_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(storageclient.StreamWriter), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *IStorageClient_ReplaceStream_Call) Return(_a0 error) *IStorageClient_ReplaceStream_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *IStorageClient_ReplaceStream_Call) RunAndReturn(run func(storageclient.StreamWriter, string) error) *IStorageClient_ReplaceStream_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SetMetadata provides a mock function with given fields: object, metadata
+func (_m *IStorageClient) SetMetadata(object string, metadata map[string]string) error {
+	ret := _m.Called(object, metadata)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetMetadata")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, map[string]string) error); ok {
+		r0 = rf(object, metadata)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IStorageClient_SetMetadata_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SetMetadata'
+type IStorageClient_SetMetadata_Call struct {
+	*mock.Call
+}
+
+// SetMetadata is a helper method to define mock.On call
+//   - object string
+//   - metadata map[string]string
+func (_e *IStorageClient_Expecter) SetMetadata(object interface{}, metadata interface{}) *IStorageClient_SetMetadata_Call {
+	return &IStorageClient_SetMetadata_Call{Call: _e.mock.On("SetMetadata", object, metadata)}
+}
+
+func (_c *IStorageClient_SetMetadata_Call) Run(run func(object string, metadata map[string]string)) *IStorageClient_SetMetadata_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(map[string]string))
+	})
+	return _c
+}
+
+func (_c *IStorageClient_SetMetadata_Call) Return(_a0 error) *IStorageClient_SetMetadata_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *IStorageClient_SetMetadata_Call) RunAndReturn(run func(string, map[string]string) error) *IStorageClient_SetMetadata_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // UploadNewFile provides a mock function with given fields: path, destObject
 func (_m *IStorageClient) UploadNewFile(path string, destObject string) error {
 	ret := _m.Called(path, destObject)
 
+	if len(ret) == 0 {
+		panic("no return value specified for UploadNewFile")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(string, string) error); ok {
 		r0 = rf(path, destObject)
@@ -103,6 +453,53 @@ func (_c *IStorageClient_UploadNewFile_Call) RunAndReturn(run func(string, strin
 	return _c
 }
 
+// UploadNewStream provides a mock function with given fields: write, destObject
+func (_m *IStorageClient) UploadNewStream(write storageclient.StreamWriter, destObject string) error {
+	ret := _m.Called(write, destObject)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UploadNewStream")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(storageclient.StreamWriter, string) error); ok {
+		r0 = rf(write, destObject)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IStorageClient_UploadNewStream_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UploadNewStream'
+type IStorageClient_UploadNewStream_Call struct {
+	*mock.Call
+}
+
+// UploadNewStream is a helper method to define mock.On call
+//   - write storageclient.StreamWriter
+//   - destObject string
+func (_e *IStorageClient_Expecter) UploadNewStream(write interface{}, destObject interface{}) *IStorageClient_UploadNewStream_Call {
+	return &IStorageClient_UploadNewStream_Call{Call: _e.mock.On("UploadNewStream", write, destObject)}
+}
+
+func (_c *IStorageClient_UploadNewStream_Call) Run(run func(write storageclient.StreamWriter, destObject string)) *IStorageClient_UploadNewStream_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(storageclient.StreamWriter), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *IStorageClient_UploadNewStream_Call) Return(_a0 error) *IStorageClient_UploadNewStream_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *IStorageClient_UploadNewStream_Call) RunAndReturn(run func(storageclient.StreamWriter, string) error) *IStorageClient_UploadNewStream_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewIStorageClient creates a new instance of IStorageClient. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewIStorageClient(t interface {
@@ -0,0 +1,201 @@
+package storageclient
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Destination is a named storage backend that a MultiStorageClient fans out to.
+// A failure on a Required destination fails the upload, a failure on any other
+// destination is recorded as a missed destination.
+type Destination struct {
+	Name     string
+	Client   IStorageClient
+	Required bool
+}
+
+type DestinationResult struct {
+	Destination string
+	Required    bool
+	Err         error
+}
+
+// DestinationError is returned when at least one required destination failed,
+// and holds the outcome for every destination.
+type DestinationError struct {
+	Results []DestinationResult
+}
+
+func (de *DestinationError) Error() string {
+	var failures []string
+	for _, result := range de.Results {
+		if result.Err != nil {
+			failures = append(failures, fmt.Sprintf("%v: %v", result.Destination, result.Err))
+		}
+	}
+	return fmt.Sprintf("failed to store to %v destination(s) - %v",
+		len(failures), strings.Join(failures, "; "))
+}
+
+// IMissedDestinations is implemented by storage clients that can store an
+// object in only some of their destinations without failing.
+type IMissedDestinations interface {
+	// MissedDestinations returns the destinations that failed since the last
+	// call to ClearMissedDestinations.
+	MissedDestinations() []DestinationResult
+	ClearMissedDestinations()
+}
+
+// MultiStorageClient uploads every object to each of its destinations in turn.
+type MultiStorageClient struct {
+	destinations []Destination
+	missed       []DestinationResult
+}
+
+func NewMulti(destinations ...Destination) *MultiStorageClient {
+	if len(destinations) == 0 {
+		panic("at least one storage destination must be configured")
+	}
+	return &MultiStorageClient{destinations: destinations}
+}
+
+// ReplaceFile replaces the object in every destination. A destination that
+// missed the earlier upload does not have the object, so it is created there.
+func (msc *MultiStorageClient) ReplaceFile(path string, destObject string) error {
+	return msc.fanOut(destObject, func(client IStorageClient) error {
+		err := client.ReplaceFile(path, destObject)
+		if errors.Is(err, ErrObjectNotFound) {
+			return client.UploadNewFile(path, destObject)
+		}
+		return err
+	})
+}
+
+func (msc *MultiStorageClient) UploadNewFile(path string, destObject string) error {
+	return msc.fanOut(destObject, func(client IStorageClient) error {
+		return client.UploadNewFile(path, destObject)
+	})
+}
+
+// ReplaceStream calls write once for every destination, creating the object
+// in any destination that does not have it, as ReplaceFile does.
+func (msc *MultiStorageClient) ReplaceStream(write StreamWriter, destObject string) error {
+	return msc.fanOut(destObject, func(client IStorageClient) error {
+		err := client.ReplaceStream(write, destObject)
+		if errors.Is(err, ErrObjectNotFound) {
+			return client.UploadNewStream(write, destObject)
+		}
+		return err
+	})
+}
+
+// UploadNewStream calls write once for every destination.
+func (msc *MultiStorageClient) UploadNewStream(write StreamWriter, destObject string) error {
+	return msc.fanOut(destObject, func(client IStorageClient) error {
+		return client.UploadNewStream(write, destObject)
+	})
+}
+
+// DownloadFile downloads from the first destination that has the object.
+func (msc *MultiStorageClient) DownloadFile(srcObject string, path string) error {
+	var errs []string
+	for _, destination := range msc.destinations {
+		err := destination.Client.DownloadFile(srcObject, path)
+		if err == nil {
+			return nil
+		}
+		log.Printf("WARNING: Failed to download %v from %v: %v", srcObject, destination.Name, err)
+		errs = append(errs, fmt.Sprintf("%v: %v", destination.Name, err))
+	}
+	return fmt.Errorf("failed to download %v from any destination - %v", srcObject, strings.Join(errs, "; "))
+}
+
+// ListFiles lists the first destination that can be listed, which is
+// normally the primary.
+func (msc *MultiStorageClient) ListFiles(prefix string) ([]BackupFile, error) {
+	var errs []string
+	for _, destination := range msc.destinations {
+		backupFiles, err := destination.Client.ListFiles(prefix)
+		if err == nil {
+			return backupFiles, nil
+		}
+		log.Printf("WARNING: Failed to list %v: %v", destination.Name, err)
+		errs = append(errs, fmt.Sprintf("%v: %v", destination.Name, err))
+	}
+	return nil, fmt.Errorf("failed to list any destination - %v", strings.Join(errs, "; "))
+}
+
+// CopyFile copies the object in every destination.
+func (msc *MultiStorageClient) CopyFile(srcObject string, destObject string) error {
+	return msc.fanOut(destObject, func(client IStorageClient) error {
+		return client.CopyFile(srcObject, destObject)
+	})
+}
+
+// MoveFile moves the object in every destination.
+func (msc *MultiStorageClient) MoveFile(srcObject string, destObject string) error {
+	return msc.fanOut(destObject, func(client IStorageClient) error {
+		return client.MoveFile(srcObject, destObject)
+	})
+}
+
+// DeleteFile deletes the object from every destination. A destination that
+// never had the object, such as one that missed its upload, is left as it is.
+func (msc *MultiStorageClient) DeleteFile(object string) error {
+	return msc.fanOut(object, func(client IStorageClient) error {
+		err := client.DeleteFile(object)
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+// SetMetadata sets the metadata of the object in every destination.
+func (msc *MultiStorageClient) SetMetadata(object string, metadata map[string]string) error {
+	return msc.fanOut(object, func(client IStorageClient) error {
+		return client.SetMetadata(object, metadata)
+	})
+}
+
+func (msc *MultiStorageClient) MissedDestinations() []DestinationResult {
+	return msc.missed
+}
+
+func (msc *MultiStorageClient) ClearMissedDestinations() {
+	msc.missed = nil
+}
+
+func (msc *MultiStorageClient) fanOut(destObject string, store func(client IStorageClient) error) error {
+	var results []DestinationResult
+	requiredFailed := false
+
+	for _, destination := range msc.destinations {
+		err := store(destination.Client)
+		results = append(results, DestinationResult{
+			Destination: destination.Name,
+			Required:    destination.Required,
+			Err:         err,
+		})
+
+		switch {
+		case err == nil:
+			log.Printf("Stored %v in %v", destObject, destination.Name)
+		case destination.Required:
+			requiredFailed = true
+			log.Printf("ERROR: Failed to store %v in required destination %v: %v",
+				destObject, destination.Name, err)
+		default:
+			msc.missed = append(msc.missed, results[len(results)-1])
+			log.Printf("WARNING: Failed to store %v in secondary destination %v: %v",
+				destObject, destination.Name, err)
+		}
+	}
+
+	if requiredFailed {
+		return &DestinationError{Results: results}
+	}
+	return nil
+}
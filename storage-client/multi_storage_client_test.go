@@ -0,0 +1,123 @@
+package storageclient_test
+
+import (
+	"errors"
+	"fmt"
+
+	storageclient "github.com/apkatsikas/archiver/storage-client"
+	"github.com/apkatsikas/archiver/storage-client/mocks"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MultiStorageClient", func() {
+	const path = "/path/to/album.zip"
+	const destObject = "album5c214deb5b2dba739e0d6af56f61d1c7.zip"
+
+	var primary *mocks.IStorageClient
+	var secondary *mocks.IStorageClient
+	var storageError = fmt.Errorf("oh no! storage is down")
+
+	BeforeEach(func() {
+		gt := GinkgoT()
+		primary = mocks.NewIStorageClient(gt)
+		secondary = mocks.NewIStorageClient(gt)
+	})
+
+	newClient := func(secondaryRequired bool) *storageclient.MultiStorageClient {
+		return storageclient.NewMulti(
+			storageclient.Destination{Name: "gcs", Client: primary, Required: true},
+			storageclient.Destination{Name: "filesystem", Client: secondary, Required: secondaryRequired},
+		)
+	}
+
+	Context("When every destination succeeds", func() {
+		BeforeEach(func() {
+			primary.EXPECT().UploadNewFile(path, destObject).Return(nil).Once()
+			secondary.EXPECT().UploadNewFile(path, destObject).Return(nil).Once()
+		})
+
+		It("Returns nil", func() {
+			Expect(newClient(false).UploadNewFile(path, destObject)).To(BeNil())
+		})
+	})
+
+	Context("When the primary destination fails", func() {
+		var err error
+
+		BeforeEach(func() {
+			primary.EXPECT().ReplaceFile(path, destObject).Return(storageError).Once()
+			secondary.EXPECT().ReplaceFile(path, destObject).Return(nil).Once()
+			err = newClient(false).ReplaceFile(path, destObject)
+		})
+
+		It("Returns an error", func() {
+			Expect(err).To(Not(BeNil()))
+		})
+
+		It("Reports the outcome for each destination", func() {
+			var destinationError *storageclient.DestinationError
+			Expect(errors.As(err, &destinationError)).To(BeTrue())
+			Expect(destinationError.Results).To(Equal([]storageclient.DestinationResult{
+				{Destination: "gcs", Required: true, Err: storageError},
+				{Destination: "filesystem", Required: false, Err: nil},
+			}))
+		})
+	})
+
+	Context("When a secondary destination fails", func() {
+		BeforeEach(func() {
+			primary.EXPECT().UploadNewFile(path, destObject).Return(nil).Once()
+			secondary.EXPECT().UploadNewFile(path, destObject).Return(storageError).Once()
+		})
+
+		It("Returns nil when the secondary is not required", func() {
+			Expect(newClient(false).UploadNewFile(path, destObject)).To(BeNil())
+		})
+
+		It("Returns an error when the secondary is required", func() {
+			Expect(newClient(true).UploadNewFile(path, destObject)).To(Not(BeNil()))
+		})
+
+		It("Records the secondary as missed until cleared", func() {
+			client := newClient(false)
+			Expect(client.UploadNewFile(path, destObject)).To(BeNil())
+			Expect(client.MissedDestinations()).To(Equal([]storageclient.DestinationResult{
+				{Destination: "filesystem", Required: false, Err: storageError},
+			}))
+			client.ClearMissedDestinations()
+			Expect(client.MissedDestinations()).To(BeEmpty())
+		})
+	})
+
+	Context("When a destination does not have the object being replaced", func() {
+		var client *storageclient.MultiStorageClient
+
+		BeforeEach(func() {
+			primary.EXPECT().ReplaceFile(path, destObject).Return(nil).Once()
+			secondary.EXPECT().ReplaceFile(path, destObject).Return(
+				fmt.Errorf("cannot replace %v: %w", destObject, storageclient.ErrObjectNotFound)).Once()
+			secondary.EXPECT().UploadNewFile(path, destObject).Return(nil).Once()
+			client = newClient(false)
+		})
+
+		It("Creates the object in that destination", func() {
+			Expect(client.ReplaceFile(path, destObject)).To(BeNil())
+			Expect(client.MissedDestinations()).To(BeEmpty())
+		})
+	})
+
+	Context("When a destination does not have the object being deleted", func() {
+		BeforeEach(func() {
+			primary.EXPECT().DeleteFile(destObject).Return(nil).Once()
+			secondary.EXPECT().DeleteFile(destObject).Return(
+				fmt.Errorf("cannot delete %v: %w", destObject, storageclient.ErrObjectNotFound)).Once()
+		})
+
+		It("Leaves that destination as it is", func() {
+			client := newClient(true)
+			Expect(client.DeleteFile(destObject)).To(BeNil())
+			Expect(client.MissedDestinations()).To(BeEmpty())
+		})
+	})
+})
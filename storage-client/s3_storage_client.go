@@ -0,0 +1,522 @@
+package storageclient
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// streamPartSize is the part size for streamed uploads, which also decides
+// the ETag S3 gives the object.
+const streamPartSize = 16 * 1024 * 1024
+
+var errStreamAborted = errors.New("upload stream aborted")
+
+// S3StorageClient stores objects in any S3-compatible service,
+// such as AWS S3, Backblaze B2, Wasabi or a self-hosted MinIO.
+type S3StorageClient struct {
+	client         *minio.Client
+	bucketName     string
+	timeoutSeconds int
+}
+
+func NewS3() *S3StorageClient {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		panic("S3_ENDPOINT must be set")
+	}
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		panic("S3_REGION must be set")
+	}
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+	if bucketName == "" {
+		panic("S3_BUCKET_NAME must be set")
+	}
+	accessKeyID := os.Getenv("S3_ACCESS_KEY_ID")
+	if accessKeyID == "" {
+		panic("S3_ACCESS_KEY_ID must be set")
+	}
+	secretAccessKey := os.Getenv("S3_SECRET_ACCESS_KEY")
+	if secretAccessKey == "" {
+		panic("S3_SECRET_ACCESS_KEY must be set")
+	}
+	s3TimeoutSeconds := os.Getenv("S3_TIMEOUT_SECONDS")
+	if s3TimeoutSeconds == "" {
+		panic("S3_TIMEOUT_SECONDS must be set")
+	}
+	s3TimeoutSecondsInt, err := strconv.Atoi(s3TimeoutSeconds)
+	if err != nil {
+		panic(fmt.Sprintf("could not convert %v to int", s3TimeoutSeconds))
+	}
+	usePathStyle := optionalBoolEnv("S3_USE_PATH_STYLE")
+	disableSSL := optionalBoolEnv("S3_DISABLE_SSL")
+
+	bucketLookup := minio.BucketLookupAuto
+	if usePathStyle {
+		bucketLookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
+		Secure:       !disableSSL,
+		Region:       region,
+		BucketLookup: bucketLookup,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &S3StorageClient{
+		client:         client,
+		bucketName:     bucketName,
+		timeoutSeconds: s3TimeoutSecondsInt,
+	}
+}
+
+func (sc *S3StorageClient) ReplaceFile(path string, destObject string) error {
+	blobFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer blobFile.Close()
+
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	// Mirror the GCS generation-match precondition with an ETag match, so the
+	// replace is aborted if the object is missing or changed since we looked at it.
+	objInfo, err := sc.client.StatObject(ctx, sc.bucketName, destObject, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("cannot replace %v: %w", destObject, ErrObjectNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting object attributes: %v", err)
+	}
+	sums, err := fileChecksums(path)
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{DisableMultipart: true, SendContentMd5: true}
+	opts.SetMatchETag(objInfo.ETag)
+
+	info, err := sc.putObject(ctx, blobFile, destObject, opts)
+	if err != nil {
+		return err
+	}
+	return verifyUpload(info, matchesMD5(info.ETag, sums), sums, destObject)
+}
+
+func (sc *S3StorageClient) UploadNewFile(path string, destObject string) error {
+	blobFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer blobFile.Close()
+
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	// Equivalent of the GCS DoesNotExist precondition - the upload is rejected
+	// if an object with this name already exists.
+	sums, err := fileChecksums(path)
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{DisableMultipart: true, SendContentMd5: true}
+	opts.SetMatchETagExcept("*")
+
+	info, err := sc.putObject(ctx, blobFile, destObject, opts)
+	var errResponse minio.ErrorResponse
+	if errors.As(err, &errResponse) && errResponse.Code == "PreconditionFailed" {
+		return sc.checkExistingObject(ctx, sums, destObject)
+	}
+	if err != nil {
+		return err
+	}
+	return verifyUpload(info, matchesMD5(info.ETag, sums), sums, destObject)
+}
+
+// checkExistingObject is called when the upload was rejected because the object
+// exists. An object holding exactly the uploaded content is left over from an
+// earlier, interrupted run, so the upload counts as done.
+func (sc *S3StorageClient) checkExistingObject(ctx context.Context, sums *checksums, destObject string) error {
+	objInfo, err := sc.client.StatObject(ctx, sc.bucketName, destObject, minio.StatObjectOptions{Checksum: true})
+	if err != nil {
+		return fmt.Errorf("object %v already exists and its attributes could not be read: %v", destObject, err)
+	}
+	if !sameContent(objInfo, matchesMD5(objInfo.ETag, sums), sums) {
+		return fmt.Errorf("object %v already exists with different content", destObject)
+	}
+	logIdenticalObject(destObject)
+	return nil
+}
+
+// sameContent reports whether the stored object holds the content the
+// checksums were taken of. Its CRC32C is compared when S3 has one of the whole
+// object, as the ETag is not an MD5 of the content with every kind of
+// encryption or on every S3-compatible service.
+func sameContent(objInfo minio.ObjectInfo, etagMatches bool, sums *checksums) bool {
+	if objInfo.Size != sums.size {
+		return false
+	}
+	if crc32c, ok := wholeCRC32C(objInfo.ChecksumCRC32C); ok {
+		return crc32c == sums.base64CRC32C()
+	}
+	return etagMatches
+}
+
+// putObject uploads in a single request - preconditions are not honoured
+// by every S3-compatible service for multipart uploads.
+func (sc *S3StorageClient) putObject(ctx context.Context,
+	blobFile *os.File, destObject string, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	stat, err := blobFile.Stat()
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	info, err := sc.client.PutObject(ctx, sc.bucketName, destObject, blobFile, stat.Size(), opts)
+	if err != nil {
+		return minio.UploadInfo{}, fmt.Errorf("error on PutObject to bucket: %w", err)
+	}
+	return info, nil
+}
+
+// ReplaceStream is ReplaceFile for content that is written straight into the
+// upload, without a local file.
+func (sc *S3StorageClient) ReplaceStream(write StreamWriter, destObject string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	objInfo, err := sc.client.StatObject(ctx, sc.bucketName, destObject, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("cannot replace %v: %w", destObject, ErrObjectNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting object attributes: %v", err)
+	}
+	opts := minio.PutObjectOptions{PartSize: streamPartSize, SendContentMd5: true}
+	opts.SetMatchETag(objInfo.ETag)
+
+	etag := newS3ETag(streamPartSize)
+	sums := newChecksums()
+	info, err := sc.putStream(ctx, write, io.MultiWriter(etag, sums), destObject, opts)
+	if err != nil {
+		return err
+	}
+	if err := verifyUpload(info, etag.matches(info.ETag), sums, destObject); err != nil {
+		return err
+	}
+	return sc.checkStoredStream(ctx, info, destObject)
+}
+
+// UploadNewStream is UploadNewFile for content that is written straight into
+// the upload, without a local file.
+//
+// A stream is uploaded in parts, and not every S3-compatible service honours
+// a precondition on the request that completes a multipart upload. So the
+// object is checked for before the upload, and again after it to make sure
+// the object stored is the one uploaded.
+func (sc *S3StorageClient) UploadNewStream(write StreamWriter, destObject string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	etag := newS3ETag(streamPartSize)
+	sums := newChecksums()
+	_, err := sc.client.StatObject(ctx, sc.bucketName, destObject, minio.StatObjectOptions{})
+	if err == nil {
+		if err := write(io.MultiWriter(etag, sums)); err != nil {
+			return err
+		}
+		return sc.checkExistingStream(ctx, etag, sums, destObject)
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return fmt.Errorf("error getting object attributes: %v", err)
+	}
+
+	opts := minio.PutObjectOptions{PartSize: streamPartSize, SendContentMd5: true}
+	opts.SetMatchETagExcept("*")
+
+	info, err := sc.putStream(ctx, write, io.MultiWriter(etag, sums), destObject, opts)
+	var errResponse minio.ErrorResponse
+	if errors.As(err, &errResponse) && errResponse.Code == "PreconditionFailed" {
+		return sc.checkExistingStream(ctx, etag, sums, destObject)
+	}
+	if err != nil {
+		return err
+	}
+	if err := verifyUpload(info, etag.matches(info.ETag), sums, destObject); err != nil {
+		return err
+	}
+	return sc.checkStoredStream(ctx, info, destObject)
+}
+
+// checkExistingStream is checkExistingObject for a stream, whose ETag depends
+// on the part size it was uploaded with.
+func (sc *S3StorageClient) checkExistingStream(
+	ctx context.Context, etag *s3ETag, sums *checksums, destObject string) error {
+	objInfo, err := sc.client.StatObject(ctx, sc.bucketName, destObject, minio.StatObjectOptions{Checksum: true})
+	if err != nil {
+		return fmt.Errorf("object %v already exists and its attributes could not be read: %v", destObject, err)
+	}
+	if !sameContent(objInfo, etag.matches(objInfo.ETag), sums) {
+		return fmt.Errorf("object %v already exists with different content", destObject)
+	}
+	logIdenticalObject(destObject)
+	return nil
+}
+
+// checkStoredStream fails a stream upload if the object now stored is not the
+// one uploaded, as when another upload to the same name completed meanwhile
+// on a service that ignored the precondition.
+func (sc *S3StorageClient) checkStoredStream(ctx context.Context, info minio.UploadInfo, destObject string) error {
+	objInfo, err := sc.client.StatObject(ctx, sc.bucketName, destObject, minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting attributes of uploaded object %v: %v", destObject, err)
+	}
+	if objInfo.ETag != info.ETag {
+		return fmt.Errorf("object %v was changed while it was uploaded, it has ETag %v instead of %v",
+			destObject, objInfo.ETag, info.ETag)
+	}
+	return nil
+}
+
+// putStream uploads whatever write produces through a pipe. The size is not
+// known up front, so the upload is multipart - the preconditions are sent with
+// the request that completes it, where only some services honour them.
+func (sc *S3StorageClient) putStream(ctx context.Context, write StreamWriter,
+	tee io.Writer, destObject string, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writer.CloseWithError(write(io.MultiWriter(writer, tee)))
+	}()
+
+	info, err := sc.client.PutObject(ctx, sc.bucketName, destObject, reader, -1, opts)
+	// Unblocks the writer if the upload gave up before reading everything.
+	reader.CloseWithError(errStreamAborted)
+	<-done
+	if err != nil {
+		return minio.UploadInfo{}, fmt.Errorf("error on PutObject to bucket: %w", err)
+	}
+	return info, nil
+}
+
+// verifyUpload fails the upload if S3 returned a CRC32C of the whole object
+// that does not match the content sent. Content-MD5 is sent with every
+// request, so S3 already rejects a request whose body was damaged on the way.
+// The ETag is not an MD5 of the content with SSE-KMS or SSE-C, or on some
+// S3-compatible services, so an ETag that does not match is only logged.
+func verifyUpload(info minio.UploadInfo, etagMatches bool, sums *checksums, destObject string) error {
+	if crc32c, ok := wholeCRC32C(info.ChecksumCRC32C); ok {
+		if expected := sums.base64CRC32C(); crc32c != expected {
+			return fmt.Errorf("object %v was stored with CRC32C %v, expected %v",
+				destObject, crc32c, expected)
+		}
+		return nil
+	}
+	if !etagMatches {
+		log.Printf("WARNING: Object %v was stored with ETag %v, which is not an MD5 of the content sent, "+
+			"so only the Content-MD5 of each request was checked", destObject, info.ETag)
+	}
+	return nil
+}
+
+// wholeCRC32C returns the CRC32C S3 gave an object, when it is a checksum of
+// the whole object - one ending in -N is a checksum of the part checksums.
+func wholeCRC32C(crc32c string) (string, bool) {
+	return crc32c, crc32c != "" && !strings.Contains(crc32c, "-")
+}
+
+// matchesMD5 reports whether etag is the MD5 of the content, as it is for a
+// single part upload.
+func matchesMD5(etag string, sums *checksums) bool {
+	return strings.EqualFold(strings.Trim(etag, `"`), hex.EncodeToString(sums.md5.Sum(nil)))
+}
+
+func (sc *S3StorageClient) DownloadFile(srcObject string, path string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	object, err := sc.client.GetObject(ctx, sc.bucketName, srcObject, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("error opening object %v: %v", srcObject, err)
+	}
+	defer object.Close()
+
+	return writeToFile(object, path)
+}
+
+func (sc *S3StorageClient) ListFiles(prefix string) ([]BackupFile, error) {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	var backupFiles []BackupFile
+	objects := sc.client.ListObjects(ctx, sc.bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	for objInfo := range objects {
+		if objInfo.Err != nil {
+			return nil, fmt.Errorf("error listing bucket: %v", objInfo.Err)
+		}
+		backupFiles = append(backupFiles, BackupFile{
+			Name:    objInfo.Key,
+			Updated: objInfo.LastModified,
+			Size:    objInfo.Size,
+		})
+	}
+	return backupFiles, nil
+}
+
+// CopyFile composes the copy server side, which unlike a plain copy allows
+// objects larger than 5 GiB.
+func (sc *S3StorageClient) CopyFile(srcObject string, destObject string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	_, err := sc.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: sc.bucketName, Object: destObject},
+		minio.CopySrcOptions{Bucket: sc.bucketName, Object: srcObject})
+	if err != nil {
+		return fmt.Errorf("error copying %v to %v: %v", srcObject, destObject, err)
+	}
+	return nil
+}
+
+// MoveFile copies the object to its new name and deletes the original.
+func (sc *S3StorageClient) MoveFile(srcObject string, destObject string) error {
+	if err := sc.CopyFile(srcObject, destObject); err != nil {
+		return err
+	}
+	return sc.DeleteFile(srcObject)
+}
+
+// SetMetadata replaces the user metadata of the object, by copying it onto
+// itself, as S3 metadata cannot be changed in place.
+func (sc *S3StorageClient) SetMetadata(object string, metadata map[string]string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	_, err := sc.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: sc.bucketName, Object: object, UserMetadata: metadata, ReplaceMetadata: true},
+		minio.CopySrcOptions{Bucket: sc.bucketName, Object: object})
+	if err != nil {
+		return fmt.Errorf("error setting metadata of %v: %v", object, err)
+	}
+	return nil
+}
+
+func (sc *S3StorageClient) DeleteFile(object string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	// RemoveObject succeeds on a missing key, so the object is looked up first
+	// to report it as not found like the other backends.
+	_, err := sc.client.StatObject(ctx, sc.bucketName, object, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("cannot delete %v: %w", object, ErrObjectNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting object attributes: %v", err)
+	}
+	if err := sc.client.RemoveObject(ctx, sc.bucketName, object, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("error deleting %v: %v", object, err)
+	}
+	return nil
+}
+
+func optionalBoolEnv(name string) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return false
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		panic(fmt.Sprintf("could not convert %v=%v to bool", name, value))
+	}
+	return parsed
+}
+
+// s3ETag computes both ETags S3 can give an object - the MD5 of the content for
+// a single part upload, or the MD5 of the part MD5s followed by the part count
+// for a multipart upload.
+type s3ETag struct {
+	partSize    int64
+	whole       hash.Hash
+	part        hash.Hash
+	partWritten int64
+	partSums    []byte
+	parts       int
+}
+
+func newS3ETag(partSize int64) *s3ETag {
+	return &s3ETag{partSize: partSize, whole: md5.New(), part: md5.New()}
+}
+
+func (e *s3ETag) Write(p []byte) (int, error) {
+	e.whole.Write(p)
+	written := len(p)
+	for len(p) > 0 {
+		n := min(int64(len(p)), e.partSize-e.partWritten)
+		e.part.Write(p[:n])
+		e.partWritten += n
+		p = p[n:]
+		if e.partWritten == e.partSize {
+			e.finishPart()
+		}
+	}
+	return written, nil
+}
+
+func (e *s3ETag) finishPart() {
+	e.partSums = e.part.Sum(e.partSums)
+	e.part.Reset()
+	e.partWritten = 0
+	e.parts++
+}
+
+func (e *s3ETag) matches(etag string) bool {
+	etag = strings.ToLower(strings.Trim(etag, `"`))
+	if etag == hex.EncodeToString(e.whole.Sum(nil)) {
+		return true
+	}
+	// The part being written is summed without finishing it, so matches can be
+	// called again.
+	partSums, parts := slices.Clip(e.partSums), e.parts
+	if e.partWritten > 0 {
+		partSums = e.part.Sum(partSums)
+		parts++
+	}
+	multipart := md5.Sum(partSums)
+	return etag == fmt.Sprintf("%v-%v", hex.EncodeToString(multipart[:]), parts)
+}
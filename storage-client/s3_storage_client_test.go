@@ -0,0 +1,123 @@
+package storageclient_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	storageclient "github.com/apkatsikas/archiver/storage-client"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// These specs run against a real S3-compatible server and are skipped unless
+// S3_ENDPOINT is set - see the minio target in the Makefile.
+var _ = Describe("S3StorageClient - integrated", func() {
+	const destObject = "s3-storage-client-test.zip"
+
+	var client *storageclient.S3StorageClient
+	var localFile string
+
+	BeforeEach(func() {
+		if os.Getenv("S3_ENDPOINT") == "" {
+			Skip("S3_ENDPOINT is not set")
+		}
+
+		By("Resetting the bucket")
+		resetBucket(destObject)
+
+		By("Creating a local file to upload")
+		localFile = filepath.Join(GinkgoT().TempDir(), "upload.zip")
+		Expect(os.WriteFile(localFile, []byte("some zip bytes"), 0644)).To(BeNil())
+
+		client = storageclient.NewS3()
+	})
+
+	Context("When the object does not exist", func() {
+		It("Uploads a new file", func() {
+			Expect(client.UploadNewFile(localFile, destObject)).To(BeNil())
+		})
+
+		It("Refuses to replace the file", func() {
+			Expect(client.ReplaceFile(localFile, destObject)).To(MatchError(storageclient.ErrObjectNotFound))
+		})
+
+		It("Uploads a new stream", func() {
+			Expect(client.UploadNewStream(writeString("some zip bytes"), destObject)).To(BeNil())
+		})
+
+		It("Fails to delete the object", func() {
+			Expect(client.DeleteFile(destObject)).To(MatchError(storageclient.ErrObjectNotFound))
+		})
+	})
+
+	Context("When the object already exists", func() {
+		BeforeEach(func() {
+			Expect(client.UploadNewFile(localFile, destObject)).To(BeNil())
+		})
+
+		It("Treats an identical new file as uploaded", func() {
+			Expect(client.UploadNewFile(localFile, destObject)).To(BeNil())
+		})
+
+		It("Refuses to upload a new file with different content", func() {
+			Expect(os.WriteFile(localFile, []byte("other zip bytes"), 0644)).To(BeNil())
+			Expect(client.UploadNewFile(localFile, destObject)).To(Not(BeNil()))
+		})
+
+		It("Replaces the file", func() {
+			Expect(client.ReplaceFile(localFile, destObject)).To(BeNil())
+		})
+
+		It("Treats an identical new stream as uploaded", func() {
+			Expect(client.UploadNewStream(writeString("some zip bytes"), destObject)).To(BeNil())
+		})
+
+		It("Refuses to upload a new stream with different content", func() {
+			Expect(client.UploadNewStream(writeString("other zip bytes"), destObject)).To(Not(BeNil()))
+		})
+
+		It("Deletes the object", func() {
+			Expect(client.DeleteFile(destObject)).To(BeNil())
+		})
+
+		It("Replaces the stream", func() {
+			Expect(client.ReplaceStream(writeString("other zip bytes"), destObject)).To(BeNil())
+		})
+	})
+})
+
+func writeString(content string) storageclient.StreamWriter {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	}
+}
+
+func resetBucket(objects ...string) {
+	GinkgoHelper()
+	ctx := context.Background()
+	bucketName := os.Getenv("S3_BUCKET_NAME")
+
+	client, err := minio.New(os.Getenv("S3_ENDPOINT"), &minio.Options{
+		Creds: credentials.NewStaticV4(
+			os.Getenv("S3_ACCESS_KEY_ID"), os.Getenv("S3_SECRET_ACCESS_KEY"), ""),
+		Secure: os.Getenv("S3_DISABLE_SSL") != "true",
+		Region: os.Getenv("S3_REGION"),
+	})
+	Expect(err).To(BeNil(), "Failed to create minio client")
+
+	exists, err := client.BucketExists(ctx, bucketName)
+	Expect(err).To(BeNil(), "Failed to check for bucket")
+	if !exists {
+		Expect(client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: os.Getenv("S3_REGION")})).
+			To(BeNil(), "Failed to create bucket")
+	}
+	for _, object := range objects {
+		Expect(client.RemoveObject(ctx, bucketName, object, minio.RemoveObjectOptions{})).
+			To(BeNil(), "Failed to remove %v", object)
+	}
+}
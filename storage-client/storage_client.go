@@ -1,18 +1,34 @@
 package storageclient
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"log"
+	"net/http"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/apkatsikas/archiver/fileutil"
+
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 )
 
 const credsEnvVar = "GOOGLE_APPLICATION_CREDENTIALS"
 
+// ErrObjectNotFound is returned by ReplaceFile and ReplaceStream when there
+// is no object to replace, and by DeleteFile when there is none to delete.
+var ErrObjectNotFound = errors.New("object not found")
+
 type StorageClient struct {
 	client         *storage.Client
 	projectID      string
@@ -20,15 +36,28 @@ type StorageClient struct {
 	timeoutSeconds int
 }
 
+// StreamWriter writes the content of an object to w. It can be called more than
+// once for a single upload, and must write the same bytes every time.
+type StreamWriter func(w io.Writer) error
+
 //go:generate mockery --name IStorageClient
 type IStorageClient interface {
 	ReplaceFile(path string, destObject string) error
 	UploadNewFile(path string, destObject string) error
+	ReplaceStream(write StreamWriter, destObject string) error
+	UploadNewStream(write StreamWriter, destObject string) error
+	DownloadFile(srcObject string, path string) error
+	ListFiles(prefix string) ([]BackupFile, error)
+	CopyFile(srcObject string, destObject string) error
+	MoveFile(srcObject string, destObject string) error
+	DeleteFile(object string) error
+	SetMetadata(object string, metadata map[string]string) error
 }
 
 type BackupFile struct {
 	Name    string
 	Updated time.Time
+	Size    int64
 }
 
 func New() *StorageClient {
@@ -86,12 +115,20 @@ func (sc *StorageClient) ReplaceFile(path string, destObject string) error {
 	// conditions and data corruptions. The request to replace the file is aborted
 	// if the object's generation number does not match your precondition.
 	attrs, err := obj.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("cannot replace %v: %w", destObject, ErrObjectNotFound)
+	}
 	if err != nil {
 		return fmt.Errorf("error getting object attributes: %v", err)
 	}
 	obj = obj.If(storage.Conditions{GenerationMatch: attrs.Generation})
 
+	sums, err := fileChecksums(path)
+	if err != nil {
+		return err
+	}
 	wc := obj.NewWriter(ctx)
+	sendChecksums(wc, sums)
 
 	if _, err := io.Copy(wc, blobFile); err != nil {
 		return fmt.Errorf("error on Copy to bucket %v", err)
@@ -100,7 +137,7 @@ func (sc *StorageClient) ReplaceFile(path string, destObject string) error {
 		return fmt.Errorf("error on Close during bucket upload: %v", err)
 	}
 
-	return nil
+	return verifyObject(wc.Attrs(), sums, destObject)
 }
 
 func (sc *StorageClient) UploadNewFile(path string, destObject string) error {
@@ -121,16 +158,300 @@ func (sc *StorageClient) UploadNewFile(path string, destObject string) error {
 	// conditions and data corruptions. The request to upload is aborted if the
 	// object's generation number does not match your precondition.
 	// For an object that does not yet exist, set the DoesNotExist precondition.
-	obj = obj.If(storage.Conditions{DoesNotExist: true})
-
-	wc := obj.NewWriter(ctx)
+	sums, err := fileChecksums(path)
+	if err != nil {
+		return err
+	}
+	wc := obj.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	sendChecksums(wc, sums)
 
 	if _, err := io.Copy(wc, blobFile); err != nil {
 		return fmt.Errorf("error on Copy to bucket %v", err)
 	}
+	if err := wc.Close(); err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+			return sc.checkExistingObject(ctx, obj, sums, destObject)
+		}
+		return fmt.Errorf("error on Close during bucket upload: %v", err)
+	}
+
+	return verifyObject(wc.Attrs(), sums, destObject)
+}
+
+// checkExistingObject is called when the DoesNotExist precondition failed. An
+// object holding exactly the uploaded content is left over from an earlier,
+// interrupted run, so the upload counts as done.
+func (sc *StorageClient) checkExistingObject(
+	ctx context.Context, obj *storage.ObjectHandle, sums *checksums, destObject string) error {
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return fmt.Errorf("object %v already exists and its attributes could not be read: %v", destObject, err)
+	}
+	if attrs.Size != sums.size || !bytes.Equal(attrs.MD5, sums.md5.Sum(nil)) {
+		return fmt.Errorf("object %v already exists with different content", destObject)
+	}
+	logIdenticalObject(destObject)
+	return nil
+}
+
+// ReplaceStream is ReplaceFile for content that is written straight into the
+// object writer, without a local file.
+func (sc *StorageClient) ReplaceStream(write StreamWriter, destObject string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	obj := sc.client.Bucket(sc.bucketName).Object(destObject)
+
+	attrs, err := obj.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("cannot replace %v: %w", destObject, ErrObjectNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting object attributes: %v", err)
+	}
+	wc := obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).NewWriter(ctx)
+
+	sums := newChecksums()
+	if err := streamToWriter(write, io.MultiWriter(wc, sums), wc, cancel); err != nil {
+		return err
+	}
 	if err := wc.Close(); err != nil {
 		return fmt.Errorf("error on Close during bucket upload: %v", err)
 	}
+	return verifyObject(wc.Attrs(), sums, destObject)
+}
+
+// UploadNewStream is UploadNewFile for content that is written straight into
+// the object writer, without a local file.
+func (sc *StorageClient) UploadNewStream(write StreamWriter, destObject string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	obj := sc.client.Bucket(sc.bucketName).Object(destObject)
+	wc := obj.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+
+	sums := newChecksums()
+	if err := streamToWriter(write, io.MultiWriter(wc, sums), wc, cancel); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+			return sc.checkExistingObject(ctx, obj, sums, destObject)
+		}
+		return fmt.Errorf("error on Close during bucket upload: %v", err)
+	}
+	return verifyObject(wc.Attrs(), sums, destObject)
+}
 
+// streamToWriter cancels the upload before closing the object writer if the
+// content could not be fully written, which would otherwise store a partial object.
+func streamToWriter(write StreamWriter, w io.Writer, wc io.Closer, cancel context.CancelFunc) error {
+	if err := write(w); err != nil {
+		cancel()
+		wc.Close()
+		return fmt.Errorf("error on streaming to bucket: %w", err)
+	}
 	return nil
 }
+
+func (sc *StorageClient) DownloadFile(srcObject string, path string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	rc, err := sc.client.Bucket(sc.bucketName).Object(srcObject).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("error opening object %v: %v", srcObject, err)
+	}
+	defer rc.Close()
+
+	return writeToFile(rc, path)
+}
+
+func (sc *StorageClient) ListFiles(prefix string) ([]BackupFile, error) {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	var backupFiles []BackupFile
+	it := sc.client.Bucket(sc.bucketName).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing bucket: %v", err)
+		}
+		backupFiles = append(backupFiles, BackupFile{
+			Name:    attrs.Name,
+			Updated: attrs.Updated,
+			Size:    attrs.Size,
+		})
+	}
+	return backupFiles, nil
+}
+
+// CopyFile copies the object server side, replacing any object already at
+// destObject.
+func (sc *StorageClient) CopyFile(srcObject string, destObject string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	bucket := sc.client.Bucket(sc.bucketName)
+	if _, err := bucket.Object(destObject).CopierFrom(bucket.Object(srcObject)).Run(ctx); err != nil {
+		return fmt.Errorf("error copying %v to %v: %v", srcObject, destObject, err)
+	}
+	return nil
+}
+
+// MoveFile copies the object to its new name and deletes the original, as GCS
+// has no rename.
+func (sc *StorageClient) MoveFile(srcObject string, destObject string) error {
+	if err := sc.CopyFile(srcObject, destObject); err != nil {
+		return err
+	}
+	return sc.DeleteFile(srcObject)
+}
+
+func (sc *StorageClient) DeleteFile(object string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	err := sc.client.Bucket(sc.bucketName).Object(object).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("cannot delete %v: %w", object, ErrObjectNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting %v: %v", object, err)
+	}
+	return nil
+}
+
+// SetMetadata replaces the custom metadata of the object.
+func (sc *StorageClient) SetMetadata(object string, metadata map[string]string) error {
+	ctx := context.Background()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(sc.timeoutSeconds))
+	defer cancel()
+
+	_, err := sc.client.Bucket(sc.bucketName).Object(object).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	if err != nil {
+		return fmt.Errorf("error setting metadata of %v: %v", object, err)
+	}
+	return nil
+}
+
+// writeToFile copies reader into a new file at path, removing it on failure.
+func writeToFile(reader io.Reader, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		os.Remove(path)
+		return fmt.Errorf("error on Copy to %v: %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error on Close of %v: %v", path, err)
+	}
+	return nil
+}
+
+// checksums computes the CRC32C, MD5 and size of content as it is written, so
+// an upload can be checked against what storage says it received.
+type checksums struct {
+	crc32c hash.Hash32
+	md5    hash.Hash
+	size   int64
+}
+
+func newChecksums() *checksums {
+	return &checksums{crc32c: crc32.New(fileutil.CRC32CTable), md5: md5.New()}
+}
+
+// base64CRC32C is the CRC32C in the form S3 returns it.
+func (c *checksums) base64CRC32C() string {
+	return base64.StdEncoding.EncodeToString(c.crc32c.Sum(nil))
+}
+
+func (c *checksums) Write(p []byte) (int, error) {
+	c.crc32c.Write(p)
+	c.md5.Write(p)
+	c.size += int64(len(p))
+	return len(p), nil
+}
+
+// fileChecksums returns the checksums of the file at path.
+func fileChecksums(path string) (*checksums, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sums := newChecksums()
+	if _, err := io.Copy(sums, file); err != nil {
+		return nil, fmt.Errorf("error on hashing %v: %v", path, err)
+	}
+	return sums, nil
+}
+
+// sendChecksums has GCS reject the upload if what it receives does not match
+// the file. It must be called before the first write.
+func sendChecksums(wc *storage.Writer, sums *checksums) {
+	wc.CRC32C = sums.crc32c.Sum32()
+	wc.SendCRC32C = true
+	wc.MD5 = sums.md5.Sum(nil)
+}
+
+// verifyObject compares the attributes GCS returned for an upload with the
+// checksums of what was sent. Composite objects have no MD5, so it is only
+// compared when present.
+func verifyObject(attrs *storage.ObjectAttrs, sums *checksums, destObject string) error {
+	if attrs == nil {
+		return fmt.Errorf("no attributes were returned for object %v", destObject)
+	}
+	if attrs.CRC32C != sums.crc32c.Sum32() {
+		return fmt.Errorf("object %v was stored with CRC32C %08x, expected %08x",
+			destObject, attrs.CRC32C, sums.crc32c.Sum32())
+	}
+	if len(attrs.MD5) > 0 && !bytes.Equal(attrs.MD5, sums.md5.Sum(nil)) {
+		return fmt.Errorf("object %v was stored with MD5 %x, expected %x",
+			destObject, attrs.MD5, sums.md5.Sum(nil))
+	}
+	return nil
+}
+
+func logIdenticalObject(destObject string) {
+	log.Printf("Object %v already exists with identical content", destObject)
+}
+
+// fileStreamWriter streams the file at path.
+func fileStreamWriter(path string) StreamWriter {
+	return func(w io.Writer) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(w, file)
+		return err
+	}
+}
@@ -0,0 +1,13 @@
+package storageclient_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestStorageClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Storage Client Suite")
+}
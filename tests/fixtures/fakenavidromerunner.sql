@@ -37,4 +37,7 @@ CREATE TABLE IF NOT EXISTS "media_file"
 DELETE FROM "media_file";
 INSERT INTO "main"."media_file" ("id", "path", "title", "album", "artist", "artist_id", "album_artist", "album_id", "has_cover_art", "track_number", "disc_number", "year", "size", "suffix", "duration", "bit_rate", "genre", "compilation", "created_at", "updated_at", "full_text", "album_artist_id", "order_album_name", "order_album_artist_name", "order_artist_name", "sort_album_name", "sort_artist_name", "sort_album_artist_name", "sort_title", "disc_subtitle", "mbz_recording_id", "mbz_album_id", "mbz_artist_id", "mbz_album_artist_id", "mbz_album_type", "mbz_album_comment", "catalog_num", "comment", "bpm", "channels", "order_title", "mbz_release_track_id", "rg_album_gain", "rg_album_peak", "rg_track_gain", "rg_track_peak", "date", "original_year", "original_date", "release_year", "release_date", "lyrics", "library_id") VALUES ('5c214deb5b2dba739e0d6af56f61d1c7', 'tests/fixtures/huey lewis - sports/hue lou.mp3', 'Hue Lou', 'Sports', 'Huey Lewis', 'b3d149f33481d7070d98724eef55b8c6', 'Huey Lewis', '9f08f5b8706718e5e129f14d88d5b3c1', '1', '1', '0', '1980', '15127935', 'mp3', '372.220001220703', '320', '', '0', '2024-01-12 13:09:51', '2024-01-12 12:12:50.4830029-05:00', 'bloopers', 'b3d149f33481d7070d98724eef55b8c6', 'foo', 'foo', 'foo', '', '', '', '', '', '', '', '', '', '', '', '', '', '0', '2', 'foo', '', '0.0', '1.0', '0.0', '1.0', '1980', '0', '', '0', '', '[]', '1');
 INSERT INTO "main"."media_file" ("id", "path", "title", "album", "artist", "artist_id", "album_artist", "album_id", "has_cover_art", "track_number", "disc_number", "year", "size", "suffix", "duration", "bit_rate", "genre", "compilation", "created_at", "updated_at", "full_text", "album_artist_id", "order_album_name", "order_album_artist_name", "order_artist_name", "sort_album_name", "sort_artist_name", "sort_album_artist_name", "sort_title", "disc_subtitle", "mbz_recording_id", "mbz_album_id", "mbz_artist_id", "mbz_album_artist_id", "mbz_album_type", "mbz_album_comment", "catalog_num", "comment", "bpm", "channels", "order_title", "mbz_release_track_id", "rg_album_gain", "rg_album_peak", "rg_track_gain", "rg_track_peak", "date", "original_year", "original_date", "release_year", "release_date", "lyrics", "library_id") VALUES ('6ea5a2baa32842109925f67b3151fb80', 'tests/fixtures/mc5 - back in the usa/tutti fruitti.mp3', 'Tutti Fruitti', 'Back in the USA', 'MC5', '9bcc875883440c642594c4cb14f92832', 'MC5', '2d75e4e1738e20f7a3ce2bfb313f7e8d', '1', '1', '0', '2017', '12520563', 'mp3', '310.940002441406', '320', '', '0', '2024-01-12 13:14:51', '2024-01-14 19:48:22-05:00', 'whatev', '9bcc875883440c642594c4cb14f92832', 'foo', 'foo', 'foo', '', '', '', '', '', '', '', '', '', '', '', '', 'Visit https://xxxguyincognitoxxx.bandcamp.com', '0', '2', 'foo', '', '0.0', '1.0', '0.0', '1.0', '2017', '0', '', '0', '', '[]', '1');
+CREATE TABLE goose_db_version (id INTEGER PRIMARY KEY AUTOINCREMENT, version_id INTEGER NOT NULL, is_applied INTEGER NOT NULL, tstamp TIMESTAMP DEFAULT (datetime('now')));
+INSERT INTO goose_db_version (version_id, is_applied) VALUES (0, 1);
+INSERT INTO goose_db_version (version_id, is_applied) VALUES (20240122223340, 1);
 COMMIT;
@@ -11,6 +11,31 @@ import (
 
 const sqliteBinary = "sqlite3"
 const dateFormat = "2006-01-02 15:04:05"
+const fixturesPath = "tests/fixtures"
+
+// TestDbPath is where SetupTestDb creates the test DB called name.
+func TestDbPath(name string) (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %v", err)
+	}
+	return filepath.Join(dir, "..", fmt.Sprintf("%v/%v.db", fixturesPath, name)), nil
+}
+
+// RemoveTestDbs deletes the test DBs with the given names, for suites to clean
+// up after themselves.
+func RemoveTestDbs(names ...string) error {
+	for _, name := range names {
+		testDbFullPath, err := TestDbPath(name)
+		if err != nil {
+			return err
+		}
+		if err := RemoveFileIfExists(testDbFullPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 func SetupTestDb(name string) (string, error) {
 	dir, err := os.Getwd()
@@ -21,12 +46,13 @@ func SetupTestDb(name string) (string, error) {
 	// If we want to parallelize these, we can use a random string when creating the DB
 	// and delete it after the test run. As of now, they operate on the same file - could collide on
 	// a parallel run
-	fixturesPath := "tests/fixtures"
 	testDbScript := fmt.Sprintf("%v/%v.sql", fixturesPath, name)
 	testDbScriptFullPath := filepath.Join(dir, "..", testDbScript)
 	testDbScriptReadCommand := fmt.Sprintf(".read %v", testDbScriptFullPath)
-	testDbPath := fmt.Sprintf("%v/%v.db", fixturesPath, name)
-	testDbFullPath := filepath.Join(dir, "..", testDbPath)
+	testDbFullPath, err := TestDbPath(name)
+	if err != nil {
+		return "", err
+	}
 
 	_, err = os.Stat(testDbFullPath)
 	if err != nil {
@@ -128,3 +154,21 @@ func CheckForArchiveRun(dbPath string) (string, error) {
 	}
 	return strings.TrimSpace(string(result)), nil
 }
+
+func LibraryPathRecord(dbPath string, id int) (string, error) {
+	cmd := exec.Command(sqliteBinary, dbPath, fmt.Sprintf("SELECT path FROM library WHERE id = %v;", id))
+	result, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(result)), nil
+}
+
+func MediaFilePathRecord(dbPath string, id string) (string, error) {
+	cmd := exec.Command(sqliteBinary, dbPath, fmt.Sprintf("SELECT path FROM media_file WHERE id = '%v';", id))
+	result, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(result)), nil
+}
@@ -3,9 +3,13 @@ package flagutil
 import (
 	"flag"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/apkatsikas/archiver/fileutil"
+	"github.com/apkatsikas/archiver/zipper"
 )
 
 type RunMode string
@@ -16,7 +20,9 @@ func (rm *RunMode) String() string {
 
 func (rm *RunMode) Set(value string) error {
 	switch value {
-	case string(RunModeScheduled), string(RunModeBatch), string(RunModeLedger):
+	case string(RunModeScheduled), string(RunModeBatch), string(RunModeLedger),
+		string(RunModeRestore), string(RunModeRestoreDb), string(RunModeVerify), string(RunModeScrub),
+		string(RunModeMigrateIds):
 		*rm = RunMode(value)
 		return nil
 	default:
@@ -25,23 +31,149 @@ func (rm *RunMode) Set(value string) error {
 }
 
 const (
-	RunModeScheduled RunMode = "scheduled"
-	RunModeBatch     RunMode = "batch"
-	RunModeLedger    RunMode = "ledger"
+	RunModeScheduled  RunMode = "scheduled"
+	RunModeBatch      RunMode = "batch"
+	RunModeLedger     RunMode = "ledger"
+	RunModeRestore    RunMode = "restore"
+	RunModeRestoreDb  RunMode = "restoreDb"
+	RunModeVerify     RunMode = "verify"
+	RunModeScrub      RunMode = "scrub"
+	RunModeMigrateIds RunMode = "migrateIds"
 )
 
+type StorageBackend string
+
+const (
+	StorageBackendGCS        StorageBackend = "gcs"
+	StorageBackendS3         StorageBackend = "s3"
+	StorageBackendFileSystem StorageBackend = "filesystem"
+)
+
+// StorageBackends is a comma separated list of storage backends - the first
+// is the primary destination, the rest are secondary.
+type StorageBackends []StorageBackend
+
+func (sbs *StorageBackends) String() string {
+	var backends []string
+	for _, backend := range *sbs {
+		backends = append(backends, string(backend))
+	}
+	return strings.Join(backends, ",")
+}
+
+func (sbs *StorageBackends) Set(value string) error {
+	var backends StorageBackends
+	for _, backend := range strings.Split(value, ",") {
+		switch StorageBackend(backend) {
+		case StorageBackendGCS, StorageBackendS3, StorageBackendFileSystem:
+			if slices.Contains(backends, StorageBackend(backend)) {
+				return fmt.Errorf("duplicate value for storageBackend: %s", backend)
+			}
+			backends = append(backends, StorageBackend(backend))
+		default:
+			return fmt.Errorf("invalid value for storageBackend: %s", backend)
+		}
+	}
+	*sbs = backends
+	return nil
+}
+
+// ArchiveFormat is the name of one of the zipper.ArchiveFormats.
+type ArchiveFormat string
+
+func (af *ArchiveFormat) String() string {
+	return string(*af)
+}
+
+func (af *ArchiveFormat) Set(value string) error {
+	if zipper.FormatByName(value) == nil {
+		return fmt.Errorf("invalid value for archiveFormat: %s", value)
+	}
+	*af = ArchiveFormat(value)
+	return nil
+}
+
 type FlagUtil struct {
-	RunMode        RunMode
-	FileSizeLimit  fileutil.FileSize
-	FileCountLimit fileutil.FileCount
+	RunMode               RunMode
+	FileSizeLimit         fileutil.FileSize
+	FileCountLimit        fileutil.FileCount
+	StorageBackends       StorageBackends
+	SecondaryStorageFatal bool
+	ContinueOnError       bool
+	ReportFile            string
+	StreamUploads         bool
+	WorkDir               string
+	Recursive             bool
+	DetectAlbumRoots      bool
+	ArchiveFormat         ArchiveFormat
+	PartSizeLimit         fileutil.FileSize
+	PartFileLimit         fileutil.FileCount
+	ScrubCount            uint
+	ScrubRandom           bool
+	DeletedMediaPolicy    string
+	DeleteGracePeriod     time.Duration
+	KeepVersions          uint
+	VersionMaxAge         time.Duration
+	RestoreVersion        string
+	DbKeepDaily           uint
+	DbKeepWeekly          uint
+	DbKeepMonthly         uint
+	DbSnapshot            string
 }
 
 func (fu *FlagUtil) Setup() {
 	flag.Var(&fu.RunMode, "runMode",
 		"Which mode to run archiver in - valid values are "+
-			"'scheduled', 'batch' or 'ledger' - default is scheduled")
+			"'scheduled', 'batch', 'ledger', 'restore', 'restoreDb', 'verify', 'scrub' or 'migrateIds' - default is scheduled")
 	flag.Var(&fu.FileSizeLimit, "fileSizeLimit", "Maximum size for a file, if exceeded the archiver will throw an error")
 	flag.Var(&fu.FileCountLimit, "fileCountLimit", "Maximum number of files allowed in a folder, if exeeded the archiver will throw an error")
+	flag.Var(&fu.StorageBackends, "storageBackend",
+		"Comma separated storage backends to archive to, the first is the primary - valid values are "+
+			"'gcs', 's3' or 'filesystem' - default is gcs")
+	flag.BoolVar(&fu.SecondaryStorageFatal, "secondaryStorageFatal", false,
+		"Fail the run when a secondary storage backend fails, not just the primary")
+	flag.BoolVar(&fu.ContinueOnError, "continueOnError", false,
+		"Record a folder that fails to archive and carry on with the rest, instead of stopping the run")
+	flag.StringVar(&fu.ReportFile, "reportFile", "",
+		"Path to write a JSON report of succeeded, skipped and failed folders, or of verify results, to")
+	flag.BoolVar(&fu.StreamUploads, "streamUploads", false,
+		"Zip folders straight into storage, instead of writing each zip next to its folder first")
+	flag.StringVar(&fu.WorkDir, "workDir", "",
+		"Directory to stage zips in before upload - default is next to each folder being zipped")
+	flag.BoolVar(&fu.Recursive, "recursive", false,
+		"Zip the files in subfolders of each folder too, keeping their relative paths")
+	flag.BoolVar(&fu.DetectAlbumRoots, "detectAlbumRoots", false,
+		"Archive disc folders such as CD1 or Disc 2 as part of their parent album folder - implies -recursive")
+	flag.Var(&fu.ArchiveFormat, "archiveFormat",
+		"Format to archive folders in - valid values are 'zip', 'zip-store', 'tar' or 'tar-zstd' - default is zip")
+	flag.Var(&fu.PartSizeLimit, "partSizeLimit",
+		"Split folders into numbered part archives of at most this size each")
+	flag.Var(&fu.PartFileLimit, "partFileLimit",
+		"Split folders into numbered part archives of at most this many files, instead of failing on fileCountLimit")
+	flag.UintVar(&fu.ScrubCount, "scrubCount", 10,
+		"Number of archived folders to download and check in scrub mode")
+	flag.BoolVar(&fu.ScrubRandom, "scrubRandom", false,
+		"Scrub a random sample of archived folders, instead of the ones scrubbed least recently")
+	flag.StringVar(&fu.DeletedMediaPolicy, "deletedMediaPolicy", "",
+		"What a scheduled run does with the archives of folders that are gone from the library - valid values are "+
+			"'ignore', 'tombstone', 'move' or 'delete' - default is ignore")
+	flag.DurationVar(&fu.DeleteGracePeriod, "deleteGracePeriod", 30*24*time.Hour,
+		"How long a folder has to be gone from the library before the delete policy deletes its archives")
+	flag.UintVar(&fu.KeepVersions, "keepVersions", 0,
+		"Number of earlier archives of a folder to keep when it is archived again - default is 0, which overwrites them")
+	flag.DurationVar(&fu.VersionMaxAge, "versionMaxAge", 0,
+		"Prune kept versions replaced longer ago than this - default is 0, which keeps them until pruned by count")
+	flag.StringVar(&fu.RestoreVersion, "version", "",
+		"Kept version to restore from in restore mode, instead of the current archives")
+	flag.UintVar(&fu.DbKeepDaily, "dbKeepDaily", 0,
+		"Number of days to keep a dated Navidrome DB snapshot for - default is 0")
+	flag.UintVar(&fu.DbKeepWeekly, "dbKeepWeekly", 0,
+		"Number of weeks to keep a dated Navidrome DB snapshot for - default is 0")
+	flag.UintVar(&fu.DbKeepMonthly, "dbKeepMonthly", 0,
+		"Number of months to keep a dated Navidrome DB snapshot for - default is 0, "+
+			"and when all three are 0 a single DB backup is overwritten")
+	flag.StringVar(&fu.DbSnapshot, "dbSnapshot", "",
+		"Navidrome DB snapshot object to restore in restoreDb mode, instead of the latest")
 	flag.Parse()
 }
 
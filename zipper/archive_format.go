@@ -0,0 +1,246 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveFormat is a way of packing the files of a folder into one object.
+type ArchiveFormat interface {
+	// Name is the value used to select the format, e.g. on the command line.
+	Name() string
+	// Extension is appended to archive and object names, including the dot.
+	Extension() string
+	NewWriter(w io.Writer) ArchiveWriter
+	// Extract calls extractEntry for every entry in the archive at archivePath.
+	Extract(archivePath string, extractEntry ExtractEntry) error
+}
+
+type ArchiveWriter interface {
+	// Create adds a file under name, a path relative to the archive root, and
+	// returns a writer for its content.
+	Create(name string, info fs.FileInfo) (io.Writer, error)
+	Close() error
+}
+
+// ExtractEntry receives each entry of an archive. The name uses the separators
+// of the current OS, and content is nil for directories.
+type ExtractEntry func(name string, isDir bool, content io.Reader) error
+
+var (
+	FormatZipDeflate ArchiveFormat = &zipFormat{name: "zip", method: zip.Deflate}
+	FormatZipStore   ArchiveFormat = &zipFormat{name: "zip-store", method: zip.Store}
+	FormatTar        ArchiveFormat = &tarFormat{name: "tar", extension: ".tar"}
+	FormatTarZstd    ArchiveFormat = &tarFormat{name: "tar-zstd", extension: ".tar.zst", zstd: true}
+)
+
+// ArchiveFormats lists every format. The zip formats come first, so that
+// .zip objects are recognised as zips.
+var ArchiveFormats = []ArchiveFormat{FormatZipDeflate, FormatZipStore, FormatTar, FormatTarZstd}
+
+// FormatByName returns nil if there is no format called name.
+func FormatByName(name string) ArchiveFormat {
+	for _, format := range ArchiveFormats {
+		if format.Name() == name {
+			return format
+		}
+	}
+	return nil
+}
+
+// FormatForFile picks the format from the extension of an archive or object
+// name, and returns nil for anything that is not an archive.
+func FormatForFile(name string) ArchiveFormat {
+	var found ArchiveFormat
+	for _, format := range ArchiveFormats {
+		// .tar.zst must win over a shorter match, and the first format for
+		// an extension wins over later ones.
+		if strings.HasSuffix(name, format.Extension()) &&
+			(found == nil || len(format.Extension()) > len(found.Extension())) {
+			found = format
+		}
+	}
+	return found
+}
+
+type zipFormat struct {
+	name   string
+	method uint16
+}
+
+func (zf *zipFormat) Name() string {
+	return zf.name
+}
+
+func (zf *zipFormat) Extension() string {
+	return ".zip"
+}
+
+func (zf *zipFormat) NewWriter(w io.Writer) ArchiveWriter {
+	return &zipArchiveWriter{writer: zip.NewWriter(bufio.NewWriter(w)), method: zf.method}
+}
+
+func (zf *zipFormat) Extract(archivePath string, extractEntry ExtractEntry) error {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open zip %v: %v", archivePath, err)
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		name := filepath.FromSlash(file.Name)
+		if file.FileInfo().IsDir() {
+			if err := extractEntry(name, true, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := extractZipFile(file, name, extractEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(file *zip.File, name string, extractEntry ExtractEntry) error {
+	zippedFile, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer zippedFile.Close()
+
+	// The zip reader checks the CRC-32 of the file once it reaches the end.
+	return extractEntry(name, false, zippedFile)
+}
+
+type zipArchiveWriter struct {
+	writer *zip.Writer
+	method uint16
+}
+
+func (zaw *zipArchiveWriter) Create(name string, info fs.FileInfo) (io.Writer, error) {
+	fileInfoHeader, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfoHeader.Method = zaw.method
+	fileInfoHeader.Name = name
+
+	return zaw.writer.CreateHeader(fileInfoHeader)
+}
+
+func (zaw *zipArchiveWriter) Close() error {
+	return zaw.writer.Close()
+}
+
+type tarFormat struct {
+	name      string
+	extension string
+	zstd      bool
+}
+
+func (tf *tarFormat) Name() string {
+	return tf.name
+}
+
+func (tf *tarFormat) Extension() string {
+	return tf.extension
+}
+
+func (tf *tarFormat) NewWriter(w io.Writer) ArchiveWriter {
+	taw := &tarArchiveWriter{}
+	if tf.zstd {
+		// A single encoder goroutine keeps memory low on small machines, and
+		// the output the same from run to run.
+		encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			// Only invalid options make NewWriter fail.
+			panic(err)
+		}
+		taw.encoder = encoder
+		w = encoder
+	}
+	taw.writer = tar.NewWriter(w)
+	return taw
+}
+
+func (tf *tarFormat) Extract(archivePath string, extractEntry ExtractEntry) error {
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open tar %v: %v", archivePath, err)
+	}
+	defer archiveFile.Close()
+
+	var reader io.Reader = archiveFile
+	if tf.zstd {
+		decoder, err := zstd.NewReader(archiveFile)
+		if err != nil {
+			return fmt.Errorf("failed to open zstd stream %v: %v", archivePath, err)
+		}
+		defer decoder.Close()
+		reader = decoder
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar %v: %v", archivePath, err)
+		}
+
+		name := filepath.FromSlash(header.Name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = extractEntry(name, true, nil)
+		case tar.TypeReg:
+			err = extractEntry(name, false, tarReader)
+		default:
+			err = fmt.Errorf("tar contained an unsupported entry: %v", header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type tarArchiveWriter struct {
+	writer  *tar.Writer
+	encoder *zstd.Encoder
+}
+
+func (taw *tarArchiveWriter) Create(name string, info fs.FileInfo) (io.Writer, error) {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, err
+	}
+	header.Name = filepath.ToSlash(name)
+
+	if err := taw.writer.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	return taw.writer, nil
+}
+
+func (taw *tarArchiveWriter) Close() error {
+	err := taw.writer.Close()
+	if taw.encoder != nil {
+		if encoderErr := taw.encoder.Close(); err == nil {
+			err = encoderErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package zipper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FileHashCache keeps the SHA-256 of files, keyed by path, size and
+// modification time, so files that have not changed are not read again.
+type FileHashCache interface {
+	// CachedHash returns an empty string when the file is not cached.
+	CachedHash(path string, size int64, modTime time.Time) (string, error)
+	CacheHash(path string, size int64, modTime time.Time, sha256 string) error
+}
+
+// SetFileHashCache looks up the hashes of files in cache when fingerprinting
+// folders, instead of reading every file.
+func (z *Zipper) SetFileHashCache(cache FileHashCache) {
+	z.fileHashCache = cache
+}
+
+// FolderFingerprint hashes the names, sizes and content of the files that
+// would be archived from the folder. Names are relative to the folder, so a
+// folder keeps its fingerprint when it is renamed or moved.
+func (z *Zipper) FolderFingerprint(folderPath string) (string, error) {
+	fileNames, err := z.FilesToZip(folderPath)
+	if err != nil {
+		return "", err
+	}
+
+	var files []ManifestFile
+	for _, fileName := range fileNames {
+		file, err := z.fileFingerprint(filepath.Join(folderPath, fileName))
+		if err != nil {
+			return "", err
+		}
+		file.Path = filepath.ToSlash(fileName)
+		files = append(files, file)
+	}
+	return fingerprint(files), nil
+}
+
+func (z *Zipper) fileFingerprint(filePath string) (ManifestFile, error) {
+	if z.fileHashCache == nil {
+		return z.hashFile(filePath)
+	}
+
+	info, err := z.FileSystemOperator.GetInfo(filePath)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	sha256, err := z.fileHashCache.CachedHash(filePath, info.Size(), info.ModTime())
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("failed to look up the hash of %v: %v", filePath, err)
+	}
+	if sha256 != "" {
+		return ManifestFile{Size: info.Size(), SHA256: sha256}, nil
+	}
+
+	file, err := z.hashFile(filePath)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	// A file written to while it was hashed is cached under its old
+	// modification time, so it is hashed again next time.
+	if err := z.fileHashCache.CacheHash(filePath, info.Size(), info.ModTime(), file.SHA256); err != nil {
+		return ManifestFile{}, fmt.Errorf("failed to cache the hash of %v: %v", filePath, err)
+	}
+	return file, nil
+}
+
+func (z *Zipper) hashFile(filePath string) (ManifestFile, error) {
+	file, err := z.FileSystemOperator.OpenFile(filePath)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("failed to read %v: %v", filePath, err)
+	}
+	return ManifestFile{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// fingerprint hashes files in path order, so it does not depend on the order
+// they were listed in.
+func fingerprint(files []ManifestFile) string {
+	files = slices.Clone(files)
+	slices.SortFunc(files, func(a, b ManifestFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	hash := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(hash, "%v\t%v\t%v\n", file.Path, file.Size, file.SHA256)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,275 @@
+package zipper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestName is the name of the manifest at the root of every archive.
+const ManifestName = "MANIFEST.json"
+
+// ArchiveManifest describes the files in an archive, so the archive can be
+// checked without comparing it against the library by hand.
+type ArchiveManifest struct {
+	Folder string `json:"folder"`
+	// Path is the folder's path relative to its library root, with forward
+	// slashes, when the archive was made with access to the Navidrome DB.
+	Path string `json:"path,omitempty"`
+	// MediaFileIds are the Navidrome media_file IDs found in the folder, when
+	// the archive was made with access to the Navidrome DB.
+	MediaFileIds []string       `json:"mediaFileIds,omitempty"`
+	Files        []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	// Path is relative to the archive root, with forward slashes.
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// SetMediaFileIds sets the Navidrome media_file IDs listed in the manifest of
+// the archives zipped from now on, so it should be called before each folder.
+func (z *Zipper) SetMediaFileIds(mediaFileIds []string) {
+	z.mediaFileIds = slices.Sorted(slices.Values(mediaFileIds))
+}
+
+// SetRelativePath sets the folder path listed in the manifest of the archives
+// zipped from now on, so it should be called before each folder.
+func (z *Zipper) SetRelativePath(relativePath string) {
+	z.relativePath = filepath.ToSlash(relativePath)
+}
+
+// ReadManifest returns the manifest of the archive, or nil if it has none.
+func (z *Zipper) ReadManifest(archivePath string) (*ArchiveManifest, error) {
+	format := FormatForFile(archivePath)
+	if format == nil {
+		return nil, fmt.Errorf("%v is not a recognised archive", archivePath)
+	}
+
+	var manifest *ArchiveManifest
+	err := format.Extract(archivePath, func(name string, isDir bool, content io.Reader) error {
+		if isDir || name != ManifestName {
+			return nil
+		}
+		manifest = &ArchiveManifest{}
+		if err := json.NewDecoder(content).Decode(manifest); err != nil {
+			return fmt.Errorf("failed to read %v: %v", ManifestName, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// VerifyArchive reads every file in the archive and checks it against the
+// archive's manifest, returning the manifest if they match.
+func (z *Zipper) VerifyArchive(archivePath string) (*ArchiveManifest, error) {
+	manifest, found, err := readArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("%v has no %v", archivePath, ManifestName)
+	}
+
+	if problems := manifest.compare(found); len(problems) > 0 {
+		return nil, fmt.Errorf("%v does not match its manifest: %v", archivePath, strings.Join(problems, "; "))
+	}
+	return manifest, nil
+}
+
+// CheckArchive is VerifyArchive for archives that may have been made before
+// manifests were added. Every file is still read, which checks the CRC-32 of
+// each zip entry. It returns the paths of the files in the archive, relative
+// to its root with forward slashes.
+func (z *Zipper) CheckArchive(archivePath string) ([]string, error) {
+	manifest, found, err := readArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest != nil {
+		if problems := manifest.compare(found); len(problems) > 0 {
+			return nil, fmt.Errorf("%v does not match its manifest: %v", archivePath, strings.Join(problems, "; "))
+		}
+	}
+	return slices.Sorted(maps.Keys(found)), nil
+}
+
+// readArchive hashes every file in the archive, returning them with the
+// archive's manifest, which is nil if it has none.
+func readArchive(archivePath string) (*ArchiveManifest, map[string]ManifestFile, error) {
+	format := FormatForFile(archivePath)
+	if format == nil {
+		return nil, nil, fmt.Errorf("%v is not a recognised archive", archivePath)
+	}
+
+	var manifest *ArchiveManifest
+	found := make(map[string]ManifestFile)
+	err := format.Extract(archivePath, func(name string, isDir bool, content io.Reader) error {
+		if isDir {
+			return nil
+		}
+		if name == ManifestName {
+			manifest = &ArchiveManifest{}
+			if err := json.NewDecoder(content).Decode(manifest); err != nil {
+				return fmt.Errorf("failed to read %v: %v", ManifestName, err)
+			}
+			return nil
+		}
+
+		hash := sha256.New()
+		size, err := io.Copy(hash, content)
+		if err != nil {
+			return fmt.Errorf("failed to read %v: %v", filepath.ToSlash(name), err)
+		}
+		found[filepath.ToSlash(name)] = ManifestFile{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return manifest, found, nil
+}
+
+// compare lists the differences between the manifest and the files found in
+// the archive.
+func (am *ArchiveManifest) compare(found map[string]ManifestFile) []string {
+	var problems []string
+	listed := make(map[string]bool)
+	for _, file := range am.Files {
+		listed[file.Path] = true
+		actual, ok := found[file.Path]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%v is missing", file.Path))
+		case actual.Size != file.Size:
+			problems = append(problems, fmt.Sprintf("%v is %v bytes, expected %v", file.Path, actual.Size, file.Size))
+		case actual.SHA256 != file.SHA256:
+			problems = append(problems, fmt.Sprintf("%v has checksum %v, expected %v", file.Path, actual.SHA256, file.SHA256))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(found)) {
+		if !listed[name] {
+			problems = append(problems, fmt.Sprintf("%v is not in the manifest", name))
+		}
+	}
+	return problems
+}
+
+// manifestArchiveWriter records every file added to an archive, and adds the
+// manifest as the last file when it is closed.
+type manifestArchiveWriter struct {
+	writer   ArchiveWriter
+	manifest ArchiveManifest
+	entries  []*manifestEntry
+}
+
+// manifestEntry hashes and counts the content of a file as it is archived.
+type manifestEntry struct {
+	hash hash.Hash
+	size int64
+}
+
+func (me *manifestEntry) Write(p []byte) (int, error) {
+	me.size += int64(len(p))
+	return me.hash.Write(p)
+}
+
+func (z *Zipper) newArchiveWriter(w io.Writer, folderPath string) ArchiveWriter {
+	return &manifestArchiveWriter{
+		writer: z.ArchiveFormat().NewWriter(w),
+		manifest: ArchiveManifest{
+			Folder:       filepath.Base(folderPath),
+			Path:         z.relativePath,
+			MediaFileIds: z.mediaFileIds,
+		},
+	}
+}
+
+func (maw *manifestArchiveWriter) Create(name string, info fs.FileInfo) (io.Writer, error) {
+	info = &modTimeFileInfo{FileInfo: info, modTime: info.ModTime()}
+	entryWriter, err := maw.writer.Create(name, info)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &manifestEntry{hash: sha256.New()}
+	maw.entries = append(maw.entries, entry)
+	maw.manifest.Files = append(maw.manifest.Files, ManifestFile{
+		Path:    filepath.ToSlash(name),
+		ModTime: info.ModTime().UTC().Truncate(time.Second),
+	})
+	return io.MultiWriter(entryWriter, entry), nil
+}
+
+func (maw *manifestArchiveWriter) Close() error {
+	if err := maw.writeManifest(); err != nil {
+		maw.writer.Close()
+		return err
+	}
+	return maw.writer.Close()
+}
+
+func (maw *manifestArchiveWriter) writeManifest() error {
+	// The manifest takes the time of the newest file, so zipping the same
+	// files again gives an identical archive.
+	var newest time.Time
+	for i, entry := range maw.entries {
+		file := &maw.manifest.Files[i]
+		file.Size = entry.size
+		file.SHA256 = hex.EncodeToString(entry.hash.Sum(nil))
+		if file.ModTime.After(newest) {
+			newest = file.ModTime
+		}
+	}
+
+	data, err := json.MarshalIndent(maw.manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	manifestWriter, err := maw.writer.Create(ManifestName,
+		&manifestFileInfo{size: int64(len(data)), modTime: newest})
+	if err != nil {
+		return err
+	}
+	_, err = manifestWriter.Write(data)
+	return err
+}
+
+// modTimeFileInfo reads the mod time once, for both the archive header and
+// the manifest.
+type modTimeFileInfo struct {
+	fs.FileInfo
+	modTime time.Time
+}
+
+func (mtfi *modTimeFileInfo) ModTime() time.Time {
+	return mtfi.modTime
+}
+
+type manifestFileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (mfi *manifestFileInfo) Name() string       { return ManifestName }
+func (mfi *manifestFileInfo) Size() int64        { return mfi.size }
+func (mfi *manifestFileInfo) Mode() fs.FileMode  { return 0644 }
+func (mfi *manifestFileInfo) ModTime() time.Time { return mfi.modTime }
+func (mfi *manifestFileInfo) IsDir() bool        { return false }
+func (mfi *manifestFileInfo) Sys() any           { return nil }
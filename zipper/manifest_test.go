@@ -0,0 +1,250 @@
+package zipper_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apkatsikas/archiver/fileutil"
+	"github.com/apkatsikas/archiver/zipper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("VerifyArchive - integrated",
+	func(format zipper.ArchiveFormat) {
+		const folderName = "huey lewis - sports"
+
+		dir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		folderPath := filepath.Join(dir, "..", "tests", "fixtures", folderName)
+
+		zipp := &zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+		zipp.SetArchiveFormat(format)
+		zipp.SetWorkDir(GinkgoT().TempDir())
+		zipp.SetMediaFileIds([]string{"b456", "a123"})
+		zipp.SetRelativePath(filepath.Join("huey lewis", folderName))
+
+		archivePath, err := zipp.ZipFilesInFolder(folderPath)
+		Expect(err).To(BeNil(), "Got an error creating the archive")
+
+		manifest, err := zipp.VerifyArchive(archivePath)
+		Expect(err).To(BeNil())
+		Expect(manifest.Folder).To(Equal(folderName))
+		Expect(manifest.Path).To(Equal("huey lewis/" + folderName))
+		Expect(manifest.MediaFileIds).To(Equal([]string{"a123", "b456"}))
+		Expect(zipp.ReadManifest(archivePath)).To(Equal(manifest))
+		Expect(manifest.Files).To(HaveLen(2))
+
+		for _, file := range manifest.Files {
+			original, err := os.ReadFile(filepath.Join(folderPath, filepath.Base(file.Path)))
+			Expect(err).To(BeNil())
+			checksum := sha256.Sum256(original)
+			Expect(file.Path).To(HavePrefix(folderName + "/"))
+			Expect(file.Size).To(Equal(int64(len(original))))
+			Expect(file.SHA256).To(Equal(hex.EncodeToString(checksum[:])))
+			Expect(file.ModTime.IsZero()).To(BeFalse())
+		}
+	},
+	Entry("Deflate zip", zipper.FormatZipDeflate),
+	Entry("Store-only zip", zipper.FormatZipStore),
+	Entry("Tar", zipper.FormatTar),
+	Entry("Tar with zstd", zipper.FormatTarZstd),
+)
+
+var _ = Describe("VerifyArchive when the archive does not match its manifest", func() {
+	var zipp *zipper.Zipper
+	var archivePath string
+
+	BeforeEach(func() {
+		zipp = &zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+		archivePath = filepath.Join(GinkgoT().TempDir(), "album.zip")
+	})
+
+	writeArchive := func(manifest *zipper.ArchiveManifest, files map[string]string) {
+		archiveFile, err := os.Create(archivePath)
+		Expect(err).To(BeNil())
+		defer archiveFile.Close()
+		zipWriter := zip.NewWriter(archiveFile)
+
+		for name, content := range files {
+			writer, err := zipWriter.Create(name)
+			Expect(err).To(BeNil())
+			_, err = writer.Write([]byte(content))
+			Expect(err).To(BeNil())
+		}
+		if manifest != nil {
+			data, err := json.Marshal(manifest)
+			Expect(err).To(BeNil())
+			writer, err := zipWriter.Create(zipper.ManifestName)
+			Expect(err).To(BeNil())
+			_, err = writer.Write(data)
+			Expect(err).To(BeNil())
+		}
+		Expect(zipWriter.Close()).To(BeNil())
+	}
+
+	manifestFor := func(files map[string]string) *zipper.ArchiveManifest {
+		manifest := &zipper.ArchiveManifest{Folder: "album"}
+		for name, content := range files {
+			checksum := sha256.Sum256([]byte(content))
+			manifest.Files = append(manifest.Files, zipper.ManifestFile{
+				Path: name, Size: int64(len(content)), SHA256: hex.EncodeToString(checksum[:])})
+		}
+		return manifest
+	}
+
+	It("should fail when a file has changed", func() {
+		writeArchive(manifestFor(map[string]string{"album/01.mp3": "original"}),
+			map[string]string{"album/01.mp3": "replaced"})
+		_, err := zipp.VerifyArchive(archivePath)
+		Expect(err).To(MatchError(ContainSubstring("album/01.mp3 has checksum")))
+	})
+
+	It("should fail when a file is missing", func() {
+		writeArchive(manifestFor(map[string]string{"album/01.mp3": "one", "album/02.mp3": "two"}),
+			map[string]string{"album/01.mp3": "one"})
+		_, err := zipp.VerifyArchive(archivePath)
+		Expect(err).To(MatchError(ContainSubstring("album/02.mp3 is missing")))
+	})
+
+	It("should fail when a file is not in the manifest", func() {
+		writeArchive(manifestFor(map[string]string{"album/01.mp3": "one"}),
+			map[string]string{"album/01.mp3": "one", "album/extra.mp3": "extra"})
+		_, err := zipp.VerifyArchive(archivePath)
+		Expect(err).To(MatchError(ContainSubstring("album/extra.mp3 is not in the manifest")))
+	})
+
+	It("should fail when there is no manifest", func() {
+		writeArchive(nil, map[string]string{"album/01.mp3": "one"})
+		_, err := zipp.VerifyArchive(archivePath)
+		Expect(err).To(MatchError(ContainSubstring("has no " + zipper.ManifestName)))
+	})
+})
+
+var _ = Describe("CheckArchive", func() {
+	var zipp *zipper.Zipper
+	var archivePath string
+
+	BeforeEach(func() {
+		zipp = &zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+		archivePath = filepath.Join(GinkgoT().TempDir(), "album.zip")
+	})
+
+	writeStoredArchive := func(files map[string]string) {
+		archiveFile, err := os.Create(archivePath)
+		Expect(err).To(BeNil())
+		defer archiveFile.Close()
+		zipWriter := zip.NewWriter(archiveFile)
+		for name, content := range files {
+			writer, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+			Expect(err).To(BeNil())
+			_, err = writer.Write([]byte(content))
+			Expect(err).To(BeNil())
+		}
+		Expect(zipWriter.Close()).To(BeNil())
+	}
+
+	It("should list the files of an archive without a manifest", func() {
+		writeStoredArchive(map[string]string{"album/02.mp3": "two", "album/01.mp3": "one"})
+		Expect(zipp.CheckArchive(archivePath)).To(Equal([]string{"album/01.mp3", "album/02.mp3"}))
+	})
+
+	It("should list the files of an archive with a manifest, leaving the manifest out", func() {
+		dir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		zipp.SetWorkDir(GinkgoT().TempDir())
+		archivePath, err = zipp.ZipFilesInFolder(filepath.Join(dir, "..", "tests", "fixtures", "huey lewis - sports"))
+		Expect(err).To(BeNil())
+
+		Expect(zipp.CheckArchive(archivePath)).To(Equal(
+			[]string{"huey lewis - sports/cover.jpg", "huey lewis - sports/hue lou.mp3"}))
+	})
+
+	It("should fail when a file is corrupt", func() {
+		writeStoredArchive(map[string]string{"album/01.mp3": "original"})
+		data, err := os.ReadFile(archivePath)
+		Expect(err).To(BeNil())
+		Expect(os.WriteFile(archivePath, bytes.Replace(data, []byte("original"), []byte("origXnal"), 1), 0644)).To(BeNil())
+
+		_, err = zipp.CheckArchive(archivePath)
+		Expect(err).To(MatchError(ContainSubstring("checksum error")))
+	})
+})
+
+var _ = Describe("FolderFingerprint - integrated", func() {
+	var zipp *zipper.Zipper
+	var fixturePath string
+
+	copyFolder := func(destination string) {
+		GinkgoHelper()
+		Expect(os.MkdirAll(destination, 0755)).To(BeNil())
+		entries, err := os.ReadDir(fixturePath)
+		Expect(err).To(BeNil())
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(fixturePath, entry.Name()))
+			Expect(err).To(BeNil())
+			Expect(os.WriteFile(filepath.Join(destination, entry.Name()), data, 0644)).To(BeNil())
+		}
+	}
+
+	BeforeEach(func() {
+		dir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		fixturePath = filepath.Join(dir, "..", "tests", "fixtures", "huey lewis - sports")
+		zipp = &zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+	})
+
+	It("should be the same for a renamed copy of the folder", func() {
+		renamedPath := filepath.Join(GinkgoT().TempDir(), "huey lewis - sports (1983)")
+		copyFolder(renamedPath)
+
+		original, err := zipp.FolderFingerprint(fixturePath)
+		Expect(err).To(BeNil())
+		Expect(original).To(HaveLen(64))
+		Expect(zipp.FolderFingerprint(renamedPath)).To(Equal(original))
+	})
+
+	It("should look up the hashes of unchanged files in the cache", func() {
+		cache := &fakeFileHashCache{hashes: make(map[string]string)}
+		zipp.SetFileHashCache(cache)
+
+		original, err := zipp.FolderFingerprint(fixturePath)
+		Expect(err).To(BeNil())
+		Expect(cache.hashes).To(HaveKey(filepath.Join(fixturePath, "cover.jpg")))
+
+		By("Changing a cached hash")
+		for key := range cache.hashes {
+			cache.hashes[key] = "cached"
+		}
+		Expect(zipp.FolderFingerprint(fixturePath)).To(Not(Equal(original)))
+	})
+
+	It("should change when a file changes", func() {
+		changedPath := filepath.Join(GinkgoT().TempDir(), "huey lewis - sports")
+		copyFolder(changedPath)
+		Expect(os.WriteFile(filepath.Join(changedPath, "cover.jpg"), []byte("new cover"), 0644)).To(BeNil())
+
+		original, err := zipp.FolderFingerprint(fixturePath)
+		Expect(err).To(BeNil())
+		Expect(zipp.FolderFingerprint(changedPath)).To(Not(Equal(original)))
+	})
+})
+
+type fakeFileHashCache struct {
+	hashes map[string]string
+}
+
+func (c *fakeFileHashCache) CachedHash(path string, size int64, modTime time.Time) (string, error) {
+	return c.hashes[path], nil
+}
+
+func (c *fakeFileHashCache) CacheHash(path string, size int64, modTime time.Time, sha256 string) error {
+	c.hashes[path] = sha256
+	return nil
+}
@@ -0,0 +1,158 @@
+package zipper
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var partNamePattern = regexp.MustCompile(`^(.+)-part(\d+)$`)
+
+// SetPartLimits lets folders over the limits be split into numbered part
+// archives, each holding at most partFileLimit files and partSizeLimit bytes
+// of them. A zero partSizeLimit puts no limit on the size of a part, and a
+// zero partFileLimit uses the file count limit. Setting neither leaves
+// splitting off.
+func (z *Zipper) SetPartLimits(partSizeLimit, partFileLimit uint) {
+	z.partSizeLimit = partSizeLimit
+	z.partFileLimit = partFileLimit
+}
+
+// SplitsFolders reports whether folders over the limits are split into parts.
+func (z *Zipper) SplitsFolders() bool {
+	return z.partSizeLimit > 0 || z.partFileLimit > 0
+}
+
+// PartName inserts the part number before the archive extension of name,
+// turning album.zip into album-part2.zip.
+func PartName(name string, part int) string {
+	extension := ""
+	if format := FormatForFile(name); format != nil {
+		extension = format.Extension()
+	}
+	return fmt.Sprintf("%v-part%v%v", strings.TrimSuffix(name, extension), part, extension)
+}
+
+// ParsePartName is the reverse of PartName, returning the name the parts were
+// made from. ok is false when name is not a part.
+func ParsePartName(name string) (wholeName string, part int, ok bool) {
+	format := FormatForFile(name)
+	if format == nil {
+		return "", 0, false
+	}
+	matches := partNamePattern.FindStringSubmatch(strings.TrimSuffix(name, format.Extension()))
+	if matches == nil {
+		return "", 0, false
+	}
+	part, err := strconv.Atoi(matches[2])
+	if err != nil || part < 1 {
+		return "", 0, false
+	}
+	return matches[1] + format.Extension(), part, true
+}
+
+// FilePartsToZip is FilesToZip for a zipper that may split folders, returning
+// the names of the files for each part. A folder within the part limits, or
+// any folder when splitting is off, comes back as a single part.
+func (z *Zipper) FilePartsToZip(folderPath string) ([][]string, error) {
+	z.setDefaultLimits()
+	files, err := z.folderFiles(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	if !z.SplitsFolders() {
+		var fileNames []string
+		for _, file := range files {
+			fileNames = append(fileNames, file.name)
+		}
+		return [][]string{fileNames}, nil
+	}
+	return z.splitIntoParts(files), nil
+}
+
+// ZipFilesInParts is ZipFilesInFolder for a zipper that may split folders. It
+// returns one path for a folder within the part limits, and the paths of the
+// numbered parts otherwise. No paths are returned for a folder with no files.
+func (z *Zipper) ZipFilesInParts(folderPath string) ([]string, error) {
+	z.setDefaultLimits()
+	if z.workDir != "" {
+		if err := z.prepareWorkDir(folderPath); err != nil {
+			return nil, err
+		}
+	}
+
+	fileParts, err := z.FilePartsToZip(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var partPaths []string
+	for i, fileNames := range fileParts {
+		partPath := z.zipFullPathName(folderPath)
+		if len(fileParts) > 1 {
+			partPath = PartName(partPath, i+1)
+		}
+		if err := z.zipPartToFile(folderPath, fileNames, partPath); err != nil {
+			z.deleteParts(append(partPaths, partPath))
+			return nil, err
+		}
+		partPaths = append(partPaths, partPath)
+	}
+	return partPaths, nil
+}
+
+// splitIntoParts fills each part in name order until the next file would take
+// it over a limit. A file bigger than the part size limit gets a part to itself.
+func (z *Zipper) splitIntoParts(files []folderFile) [][]string {
+	// Sorting keeps the parts the same from run to run, so a retried upload
+	// finds identical parts already in storage.
+	slices.SortFunc(files, func(a, b folderFile) int {
+		return strings.Compare(a.name, b.name)
+	})
+	partFileLimit := z.partFileLimit
+	if partFileLimit == 0 {
+		partFileLimit = z.fileCountLimit
+	}
+
+	var parts [][]string
+	var part []string
+	var partSize int64
+	for _, file := range files {
+		full := uint(len(part)) >= partFileLimit ||
+			(z.partSizeLimit > 0 && partSize+file.size > int64(z.partSizeLimit))
+		if len(part) > 0 && full {
+			parts = append(parts, part)
+			part, partSize = nil, 0
+		}
+		part = append(part, file.name)
+		partSize += file.size
+	}
+	return append(parts, part)
+}
+
+func (z *Zipper) zipPartToFile(folderPath string, fileNames []string, partPath string) error {
+	partFile, err := z.FileSystemOperator.CreateFile(partPath)
+	if err != nil {
+		return err
+	}
+	if err := z.ZipFilesToWriter(folderPath, fileNames, partFile); err != nil {
+		partFile.Close()
+		return err
+	}
+	return partFile.Close()
+}
+
+func (z *Zipper) deleteParts(partPaths []string) {
+	for _, partPath := range partPaths {
+		if err := z.FileSystemOperator.DeleteFile(partPath); err != nil {
+			log.Printf("ERROR: Got an error when trying to delete part %v: %v", partPath, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package zipper
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+)
+
+const restoreTempSuffix = ".navarchiver-restore"
+
+// ExtractArchive extracts the archive at archivePath beneath targetDir, keeping
+// the relative paths stored in it. The archive can be in any of the
+// ArchiveFormats, picked by its extension. Files which already exist are left
+// untouched.
+func (z *Zipper) ExtractArchive(archivePath string, targetDir string) error {
+	format := FormatForFile(archivePath)
+	if format == nil {
+		return fmt.Errorf("%v is not a recognised archive", archivePath)
+	}
+	return format.Extract(archivePath, func(name string, isDir bool, content io.Reader) error {
+		return z.extractEntry(name, isDir, content, targetDir)
+	})
+}
+
+func (z *Zipper) extractEntry(name string, isDir bool, content io.Reader, targetDir string) error {
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("archive contained an invalid path: %v", filepath.ToSlash(name))
+	}
+	// Every archive has a manifest at its root, which is not part of the folder.
+	if name == ManifestName {
+		return nil
+	}
+	destPath := filepath.Join(targetDir, name)
+
+	if isDir {
+		return z.FileSystemOperator.CreateDirectory(destPath)
+	}
+
+	if _, err := z.FileSystemOperator.GetInfo(destPath); err == nil {
+		log.Printf("Skipping %v as it already exists", destPath)
+		return nil
+	}
+
+	if err := z.FileSystemOperator.CreateDirectory(filepath.Dir(destPath)); err != nil {
+		return err
+	}
+
+	// Extract next to the destination and rename into place, so an interrupted
+	// restore never leaves a partial file that a rerun would skip.
+	tempPath := destPath + restoreTempSuffix
+	if err := z.copyEntry(name, content, tempPath); err != nil {
+		if deleteErr := z.FileSystemOperator.DeleteFile(tempPath); deleteErr != nil {
+			log.Printf("ERROR: Got an error when trying to delete %v: %v", tempPath, deleteErr)
+		}
+		return err
+	}
+
+	return z.FileSystemOperator.RenameFile(tempPath, destPath)
+}
+
+func (z *Zipper) copyEntry(name string, content io.Reader, destPath string) error {
+	destFile, err := z.FileSystemOperator.CreateFile(destPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(destFile, content); err != nil {
+		destFile.Close()
+		return fmt.Errorf("failed to extract %v: %v", filepath.ToSlash(name), err)
+	}
+	return destFile.Close()
+}
@@ -0,0 +1,146 @@
+package zipper_test
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+
+	"github.com/apkatsikas/archiver/fileutil"
+	testutils "github.com/apkatsikas/archiver/tests/test-utils"
+	"github.com/apkatsikas/archiver/zipper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ExtractArchive - integrated", func() {
+	const folderName = "huey lewis - sports"
+
+	var zipp *zipper.Zipper
+	var zipPath string
+	var folderPath string
+	var targetDir string
+
+	BeforeEach(func() {
+		dir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		folderPath = filepath.Join(dir, "..", "tests", "fixtures", folderName)
+
+		zipp = &zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+
+		Expect(testutils.RemoveFileIfExists(folderPath+".zip")).To(BeNil(), "Got an error trying to remove test file")
+		zipPath, err = zipp.ZipFilesInFolder(folderPath)
+		Expect(err).To(BeNil(), "Got an error creating the zip")
+		DeferCleanup(testutils.RemoveFileIfExists, zipPath)
+
+		targetDir = GinkgoT().TempDir()
+	})
+
+	Context("When the target directory is empty", func() {
+		BeforeEach(func() {
+			Expect(zipp.ExtractArchive(zipPath, targetDir)).To(BeNil())
+		})
+
+		It("Restores every file with its original contents", func() {
+			for _, fileName := range []string{"hue lou.mp3", "cover.jpg"} {
+				original, err := os.ReadFile(filepath.Join(folderPath, fileName))
+				Expect(err).To(BeNil())
+				Expect(os.ReadFile(filepath.Join(targetDir, folderName, fileName))).To(Equal(original))
+			}
+		})
+
+		It("Does not leave temporary files behind", func() {
+			entries, err := os.ReadDir(filepath.Join(targetDir, folderName))
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(2))
+		})
+	})
+
+	Context("When a file already exists in the target directory", func() {
+		var existingFile string
+
+		BeforeEach(func() {
+			existingFile = filepath.Join(targetDir, folderName, "cover.jpg")
+			Expect(os.MkdirAll(filepath.Dir(existingFile), 0755)).To(BeNil())
+			Expect(os.WriteFile(existingFile, []byte("keep me"), 0644)).To(BeNil())
+			Expect(zipp.ExtractArchive(zipPath, targetDir)).To(BeNil())
+		})
+
+		It("Leaves the existing file alone", func() {
+			Expect(os.ReadFile(existingFile)).To(Equal([]byte("keep me")))
+		})
+
+		It("Restores the other files", func() {
+			Expect(filepath.Join(targetDir, folderName, "hue lou.mp3")).To(BeAnExistingFile())
+		})
+	})
+})
+
+var _ = Describe("ExtractArchive when the zip contains a path outside of the target directory", func() {
+	var targetDir string
+	var unzipError error
+
+	BeforeEach(func() {
+		targetDir = GinkgoT().TempDir()
+		zipPath := filepath.Join(GinkgoT().TempDir(), "evil.zip")
+
+		zipFile, err := os.Create(zipPath)
+		Expect(err).To(BeNil())
+		writer := zip.NewWriter(zipFile)
+		entry, err := writer.Create("../evil.txt")
+		Expect(err).To(BeNil())
+		_, err = entry.Write([]byte("evil"))
+		Expect(err).To(BeNil())
+		Expect(writer.Close()).To(BeNil())
+		Expect(zipFile.Close()).To(BeNil())
+
+		zipp := &zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+		unzipError = zipp.ExtractArchive(zipPath, targetDir)
+	})
+
+	It("should return an error", func() {
+		Expect(unzipError).To(Not(BeNil()))
+	})
+
+	It("should not write outside of the target directory", func() {
+		Expect(filepath.Join(targetDir, "..", "evil.txt")).To(Not(BeAnExistingFile()))
+	})
+})
+
+var _ = DescribeTable("ExtractArchive - integrated",
+	func(format zipper.ArchiveFormat, expectedExtension string) {
+		const folderName = "huey lewis - sports"
+
+		dir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		folderPath := filepath.Join(dir, "..", "tests", "fixtures", folderName)
+
+		zipp := &zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+		zipp.SetArchiveFormat(format)
+		zipp.SetWorkDir(GinkgoT().TempDir())
+
+		archivePath, err := zipp.ZipFilesInFolder(folderPath)
+		Expect(err).To(BeNil(), "Got an error creating the archive")
+		Expect(archivePath).To(HaveSuffix(folderName + expectedExtension))
+		Expect(zipper.FormatForFile(archivePath).Extension()).To(Equal(expectedExtension))
+
+		targetDir := GinkgoT().TempDir()
+		Expect(zipp.ExtractArchive(archivePath, targetDir)).To(BeNil())
+
+		for _, fileName := range []string{"hue lou.mp3", "cover.jpg"} {
+			original, err := os.ReadFile(filepath.Join(folderPath, fileName))
+			Expect(err).To(BeNil())
+			Expect(os.ReadFile(filepath.Join(targetDir, folderName, fileName))).To(Equal(original))
+		}
+	},
+	Entry("Deflate zip", zipper.FormatZipDeflate, ".zip"),
+	Entry("Store-only zip", zipper.FormatZipStore, ".zip"),
+	Entry("Tar", zipper.FormatTar, ".tar"),
+	Entry("Tar with zstd", zipper.FormatTarZstd, ".tar.zst"),
+)
+
+var _ = Describe("ExtractArchive when the archive is not recognised", func() {
+	It("Returns an error", func() {
+		zipp := &zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+		Expect(zipp.ExtractArchive("navidrome-backup.sqlite", GinkgoT().TempDir())).To(Not(BeNil()))
+	})
+})
@@ -1,13 +1,12 @@
 package zipper
 
 import (
-	"archive/zip"
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"path/filepath"
-	"strings"
 
 	"github.com/apkatsikas/archiver/fileutil"
 )
@@ -16,6 +15,7 @@ const (
 	defaultFileCountLimit uint = 150
 	MB                         = 1024 * 1024
 	defaultFileSizeLimit  uint = 500 * MB
+	stagingDirName             = ".navarchiver-staging"
 )
 
 type Zipper struct {
@@ -23,12 +23,21 @@ type Zipper struct {
 	builder            *zipBuilder
 	fileCountLimit     uint
 	fileSizeLimit      uint
+	workDir            string
+	workDirCleaned     bool
+	recursive          bool
+	format             ArchiveFormat
+	partSizeLimit      uint
+	partFileLimit      uint
+	mediaFileIds       []string
+	relativePath       string
+	fileHashCache      FileHashCache
 }
 
 type zipBuilder struct {
 	createdZip bool
 	zip        fileutil.IArchiveFile
-	writer     *zip.Writer
+	writer     ArchiveWriter
 	fullPath   string
 }
 
@@ -48,15 +57,46 @@ func (z *Zipper) SetFileLimits(fileSizeLimit, fileCountLimit uint) {
 	z.fileCountLimit = fileCountLimit
 }
 
-func (z *Zipper) ZipFilesInFolder(folderPath string) (string, error) {
-	if z.fileCountLimit == 0 {
-		z.fileCountLimit = defaultFileCountLimit
+// SetArchiveFormat changes the format archives are written in from the
+// default deflate zip.
+func (z *Zipper) SetArchiveFormat(format ArchiveFormat) {
+	z.format = format
+}
+
+// ArchiveFormat is the format archives are written in.
+func (z *Zipper) ArchiveFormat() ArchiveFormat {
+	if z.format == nil {
+		return FormatZipDeflate
 	}
-	if z.fileSizeLimit == 0 {
-		z.fileSizeLimit = defaultFileSizeLimit
+	return z.format
+}
+
+// SetRecursive zips the files in subfolders too, keeping their paths
+// relative to the folder being zipped.
+func (z *Zipper) SetRecursive(recursive bool) {
+	z.recursive = recursive
+}
+
+// SetWorkDir stages zips in a directory of their own beneath workDir instead of
+// next to the folder being zipped, keeping them out of the library and allowing
+// read-only media to be archived.
+func (z *Zipper) SetWorkDir(workDir string) {
+	z.workDir = ""
+	if workDir != "" {
+		z.workDir = filepath.Join(workDir, stagingDirName)
+	}
+	z.workDirCleaned = false
+}
+
+func (z *Zipper) ZipFilesInFolder(folderPath string) (string, error) {
+	z.setDefaultLimits()
+	if z.workDir != "" {
+		if err := z.prepareWorkDir(folderPath); err != nil {
+			return "", err
+		}
 	}
 	z.builder = &zipBuilder{}
-	fileNames, err := z.FileSystemOperator.FileNamesFromPath(folderPath)
+	fileNames, err := z.fileNames(folderPath)
 
 	if len(fileNames) == 0 {
 		return "", fmt.Errorf("0 files found at path %v", folderPath)
@@ -72,7 +112,7 @@ func (z *Zipper) ZipFilesInFolder(folderPath string) (string, error) {
 	}
 
 	for _, fileName := range fileNames {
-		if strings.HasSuffix(fileName, ".zip") {
+		if FormatForFile(fileName) != nil {
 			return "", z.closeAndError(fmt.Errorf("folder to zip contained a zip: %v", fileName))
 		}
 		err = z.addToZip(folderPath, fileName)
@@ -106,10 +146,65 @@ func (z *Zipper) createZip(folderPath string) (fileutil.IArchiveFile, error) {
 }
 
 func (z *Zipper) zipFullPathName(folderPath string) string {
-	zipFileName := fmt.Sprintf("%v.zip", filepath.Base(folderPath))
+	zipFileName := filepath.Base(folderPath) + z.ArchiveFormat().Extension()
+	if z.workDir != "" {
+		return filepath.Join(z.workDir, zipFileName)
+	}
 	return filepath.Join(folderPath, "..", zipFileName)
 }
 
+// prepareWorkDir clears out zips left behind by a crashed run the first time
+// it is used, then checks there is room to stage a zip of folderPath.
+func (z *Zipper) prepareWorkDir(folderPath string) error {
+	if !z.workDirCleaned {
+		if err := z.cleanWorkDir(); err != nil {
+			return fmt.Errorf("failed to clean work dir %v: %v", z.workDir, err)
+		}
+		z.workDirCleaned = true
+	}
+
+	files, err := z.folderFiles(folderPath)
+	if err != nil {
+		return err
+	}
+	folderSize := totalSize(files)
+	freeSpace, err := z.FileSystemOperator.FreeSpace(z.workDir)
+	if errors.Is(err, fileutil.ErrFreeSpaceUnsupported) {
+		log.Printf("WARNING: Skipping free space check for %v: %v", z.workDir, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get free space of %v: %v", z.workDir, err)
+	}
+	// Music barely compresses, so the zip is about the size of the folder.
+	if uint64(folderSize) > freeSpace {
+		return fmt.Errorf("not enough free space in %v to zip %v - folder is %v, %v free",
+			z.workDir, folderPath, fileutil.FileSize(folderSize).String(), fileutil.FileSize(freeSpace).String())
+	}
+	return nil
+}
+
+func (z *Zipper) cleanWorkDir() error {
+	if err := z.FileSystemOperator.CreateDirectory(z.workDir); err != nil {
+		return err
+	}
+	fileNames, err := z.FileSystemOperator.FileNamesFromPath(z.workDir)
+	if err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		if FormatForFile(fileName) == nil {
+			continue
+		}
+		stalePath := filepath.Join(z.workDir, fileName)
+		log.Printf("Deleting stale zip %v", stalePath)
+		if err := z.FileSystemOperator.DeleteFile(stalePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (z *Zipper) addToZip(folderPath string, fileName string) error {
 	joinedPath := filepath.Join(folderPath, fileName)
 
@@ -135,37 +230,175 @@ func (z *Zipper) addToZip(folderPath string, fileName string) error {
 			z.builder.createdZip = true
 
 			z.builder.zip = zipFile
-			z.builder.writer = zip.NewWriter(bufio.NewWriter(zipFile))
+			z.builder.writer = z.newArchiveWriter(zipFile, folderPath)
 		}
 
-		fileInfoHeader, err := zip.FileInfoHeader(pathInfo)
-		if err != nil {
-			return err
-		}
+		return z.writeToZip(z.builder.writer, folderPath, joinedPath, pathInfo)
+	}
+	return nil
+}
+
+func (z *Zipper) writeToZip(writer ArchiveWriter, folderPath string, joinedPath string, pathInfo fs.FileInfo) error {
+	name, err := filepath.Rel(filepath.Dir(folderPath), joinedPath)
+	if err != nil {
+		return err
+	}
+
+	headerWriter, err := writer.Create(name, pathInfo)
+	if err != nil {
+		return err
+	}
+
+	fileToCopy, err := z.FileSystemOperator.OpenFile(joinedPath)
+	if err != nil {
+		return err
+	}
+	defer fileToCopy.Close()
 
-		fileInfoHeader.Method = zip.Deflate
+	_, err = io.Copy(headerWriter, fileToCopy)
 
-		fileInfoHeader.Name, err = filepath.Rel(filepath.Dir(folderPath), joinedPath)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// FilesToZip applies the same checks as ZipFilesInFolder without writing
+// anything, and returns the names of the files that would be zipped. It is
+// used before streaming a zip, so a folder over the limits never starts an upload.
+func (z *Zipper) FilesToZip(folderPath string) ([]string, error) {
+	z.setDefaultLimits()
+	files, err := z.folderFiles(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var filesToZip []string
+	for _, file := range files {
+		filesToZip = append(filesToZip, file.name)
+	}
+	return filesToZip, nil
+}
+
+type folderFile struct {
+	name string
+	size int64
+}
+
+// folderFiles checks the folder against the limits and returns its files.
+// The file count limit does not apply when the folder can be split into parts.
+func (z *Zipper) folderFiles(folderPath string) ([]folderFile, error) {
+	fileNames, err := z.fileNames(folderPath)
+
+	if len(fileNames) == 0 {
+		return nil, fmt.Errorf("0 files found at path %v", folderPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file names from path: %v", err)
+	}
+
+	fileCount := len(fileNames)
+	if !z.SplitsFolders() && fileCount > int(z.fileCountLimit) {
+		return nil, fmt.Errorf(
+			"got %v files in folder %v, limit is %v", fileCount, folderPath, z.fileCountLimit)
+	}
+
+	var files []folderFile
+	for _, fileName := range fileNames {
+		if FormatForFile(fileName) != nil {
+			return nil, fmt.Errorf("folder to zip contained a zip: %v", fileName)
+		}
+		pathInfo, err := z.checkedFileInfo(filepath.Join(folderPath, fileName))
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if !pathInfo.IsDir() {
+			files = append(files, folderFile{name: fileName, size: pathInfo.Size()})
 		}
+	}
+	return files, nil
+}
+
+func totalSize(files []folderFile) int64 {
+	var size int64
+	for _, file := range files {
+		size += file.size
+	}
+	return size
+}
+
+// ZipFilesToWriter zips the named files from FilesToZip straight into writer,
+// with the same layout as ZipFilesInFolder. Nothing is written to disk.
+func (z *Zipper) ZipFilesToWriter(folderPath string, fileNames []string, writer io.Writer) error {
+	z.setDefaultLimits()
+	zipWriter := z.newArchiveWriter(writer, folderPath)
 
-		headerWriter, err := z.builder.writer.CreateHeader(fileInfoHeader)
+	for _, fileName := range fileNames {
+		joinedPath := filepath.Join(folderPath, fileName)
+		// Files can change between FilesToZip and the upload.
+		pathInfo, err := z.checkedFileInfo(joinedPath)
 		if err != nil {
 			return err
 		}
-
-		fileToCopy, err := z.FileSystemOperator.OpenFile(joinedPath)
-		if err != nil {
+		if err := z.writeToZip(zipWriter, folderPath, joinedPath, pathInfo); err != nil {
 			return err
 		}
-		defer fileToCopy.Close()
+	}
+	return zipWriter.Close()
+}
+
+// fileNames lists the folder, or every file beneath it when recursive, with
+// names relative to folderPath.
+func (z *Zipper) fileNames(folderPath string) ([]string, error) {
+	if !z.recursive {
+		return z.FileSystemOperator.FileNamesFromPath(folderPath)
+	}
+	return z.nestedFileNames(folderPath, "")
+}
 
-		_, err = io.Copy(headerWriter, fileToCopy)
+func (z *Zipper) nestedFileNames(folderPath string, relativePath string) ([]string, error) {
+	entries, err := z.FileSystemOperator.FileNamesFromPath(filepath.Join(folderPath, relativePath))
+	if err != nil {
+		return nil, err
+	}
 
+	var fileNames []string
+	for _, entry := range entries {
+		entryPath := filepath.Join(relativePath, entry)
+		pathInfo, err := z.FileSystemOperator.GetInfo(filepath.Join(folderPath, entryPath))
 		if err != nil {
-			return err
+			return nil, err
 		}
+		if !pathInfo.IsDir() {
+			fileNames = append(fileNames, entryPath)
+			continue
+		}
+		nested, err := z.nestedFileNames(folderPath, entryPath)
+		if err != nil {
+			return nil, err
+		}
+		fileNames = append(fileNames, nested...)
+	}
+	return fileNames, nil
+}
+
+func (z *Zipper) checkedFileInfo(joinedPath string) (fs.FileInfo, error) {
+	pathInfo, err := z.FileSystemOperator.GetInfo(joinedPath)
+	if err != nil {
+		return nil, err
+	}
+	if fileSize := pathInfo.Size(); !pathInfo.IsDir() && fileSize > int64(z.fileSizeLimit) {
+		return nil, fmt.Errorf("file %v size is %v. Limit is %v", joinedPath,
+			fileutil.FileSize(fileSize).String(), fileutil.FileSize(z.fileSizeLimit).String())
+	}
+	return pathInfo, nil
+}
+
+func (z *Zipper) setDefaultLimits() {
+	if z.fileCountLimit == 0 {
+		z.fileCountLimit = defaultFileCountLimit
+	}
+	if z.fileSizeLimit == 0 {
+		z.fileSizeLimit = defaultFileSizeLimit
 	}
-	return nil
 }
@@ -2,6 +2,7 @@ package zipper_test
 
 import (
 	"archive/zip"
+	"bytes"
 	"fmt"
 	"io"
 	"math/rand"
@@ -78,7 +79,8 @@ var _ = Describe("zipper when there are only files - integrated", func() {
 		It("should create a zip with the expected contents", func() {
 			Expect(fileNames).To(ConsistOf([]string{
 				filepath.Join(folderName, "hue lou.mp3"),
-				filepath.Join(folderName, "cover.jpg")}))
+				filepath.Join(folderName, "cover.jpg"),
+				zipper.ManifestName}))
 		})
 	})
 })
@@ -165,6 +167,269 @@ var _ = Describe("zipper when there are only files - integrated - when user over
 	})
 })
 
+var _ = Describe("zipper when streaming - integrated", func() {
+	const folderName = "huey lewis - sports"
+
+	var folderPath string
+	var zipp zipper.Zipper
+
+	BeforeEach(func() {
+		dir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		folderPath = filepath.Join(dir, "..", "tests", "fixtures", folderName)
+
+		Expect(testutils.RemoveFileIfExists(folderPath+".zip")).To(BeNil(), "Got an error trying to remove test file")
+		zipp = zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+	})
+
+	It("should stream a zip with the same contents without writing to disk", func() {
+		fileNames, err := zipp.FilesToZip(folderPath)
+		Expect(err).To(BeNil())
+		Expect(fileNames).To(ConsistOf("hue lou.mp3", "cover.jpg"))
+
+		var buffer bytes.Buffer
+		Expect(zipp.ZipFilesToWriter(folderPath, fileNames, &buffer)).To(BeNil())
+
+		reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+		Expect(err).To(BeNil())
+		var zippedNames []string
+		for _, file := range reader.File {
+			zippedNames = append(zippedNames, file.Name)
+		}
+		Expect(zippedNames).To(ConsistOf(
+			filepath.Join(folderName, "hue lou.mp3"),
+			filepath.Join(folderName, "cover.jpg"),
+			zipper.ManifestName))
+		Expect(testutils.FileExists(folderPath + ".zip")).To(BeFalse())
+	})
+
+	It("should refuse a folder over the file size limit before writing anything", func() {
+		zipp.SetFileLimits(1, 0)
+		fileNames, err := zipp.FilesToZip(folderPath)
+		Expect(err).To(Not(BeNil()))
+		Expect(fileNames).To(BeEmpty())
+	})
+
+	It("should refuse a folder over the file count limit", func() {
+		zipp.SetFileLimits(0, 1)
+		_, err := zipp.FilesToZip(folderPath)
+		Expect(err).To(Not(BeNil()))
+	})
+})
+
+var _ = Describe("zipper when staging in a work dir - integrated", func() {
+	const folderName = "huey lewis - sports"
+
+	var folderPath string
+	var workDir string
+	var staleZip string
+	var userZip string
+	var zipPath string
+	var zipError error
+
+	BeforeEach(func() {
+		dir, err := os.Getwd()
+		Expect(err).To(BeNil(), "Got an error getting working directory")
+		folderPath = filepath.Join(dir, "..", "tests", "fixtures", folderName)
+		Expect(testutils.RemoveFileIfExists(folderPath+".zip")).To(BeNil(), "Got an error trying to remove test file")
+
+		workDir = GinkgoT().TempDir()
+		stagingDir := filepath.Join(workDir, ".navarchiver-staging")
+		Expect(os.MkdirAll(stagingDir, 0755)).To(BeNil())
+		staleZip = filepath.Join(stagingDir, "crashed run.zip")
+		Expect(os.WriteFile(staleZip, []byte("partial zip"), 0644)).To(BeNil())
+		userZip = filepath.Join(workDir, "someone else's.zip")
+		Expect(os.WriteFile(userZip, []byte("not ours"), 0644)).To(BeNil())
+
+		zipp := zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+		zipp.SetWorkDir(workDir)
+		zipPath, zipError = zipp.ZipFilesInFolder(folderPath)
+	})
+
+	It("should zip without error", func() {
+		Expect(zipError).To(BeNil())
+	})
+
+	It("should create the zip in the work dir", func() {
+		Expect(zipPath).To(Equal(filepath.Join(workDir, ".navarchiver-staging", folderName+".zip")))
+		Expect(testutils.FileExists(zipPath)).To(BeTrue())
+		Expect(testutils.FileExists(folderPath + ".zip")).To(BeFalse())
+	})
+
+	It("should delete stale zips from the work dir", func() {
+		Expect(testutils.FileExists(staleZip)).To(BeFalse())
+	})
+
+	It("should leave other zips in the work dir alone", func() {
+		Expect(testutils.FileExists(userZip)).To(BeTrue())
+	})
+})
+
+var _ = Describe("zipper when the work dir is out of space", func() {
+	const folderPath = "/path/to/music/album"
+	const workDir = "/path/to/work"
+	const stagingDir = workDir + "/.navarchiver-staging"
+	const hueySong = "hue lou.mp3"
+
+	var zipError error
+	var zipFullPath string
+
+	BeforeEach(func() {
+		gt := GinkgoT()
+		mockFileSystemOperator := mocks.NewIFileSystemOperator(gt)
+
+		mockFileSystemOperator.EXPECT().CreateDirectory(stagingDir).Return(nil).Once()
+		setupFileNamesFromPath(mockFileSystemOperator, stagingDir)
+		setupFileNamesFromPath(mockFileSystemOperator, folderPath, hueySong)
+
+		archiveFileInfo := mocks.NewIArchiveFileInfo(gt)
+		archiveFileInfo.EXPECT().IsDir().Return(false).Times(2)
+		archiveFileInfo.EXPECT().Size().Return(666).Times(2)
+		mockFileSystemOperator.EXPECT().GetInfo(path.Join(folderPath, hueySong)).Return(archiveFileInfo, nil).Once()
+		mockFileSystemOperator.EXPECT().FreeSpace(stagingDir).Return(100, nil).Once()
+
+		zipp := &zipper.Zipper{FileSystemOperator: mockFileSystemOperator}
+		zipp.SetWorkDir(workDir)
+		zipFullPath, zipError = zipp.ZipFilesInFolder(folderPath)
+	})
+
+	It("should return an error", func() {
+		Expect(zipError).To(MatchError(ContainSubstring("not enough free space")))
+	})
+
+	It("should return an empty path", func() {
+		Expect(zipFullPath).To(BeEmpty())
+	})
+})
+
+var _ = Describe("zipper when zipping recursively - integrated", func() {
+	const folderName = "multi disc album"
+
+	var folderPath string
+	var zipPath string
+	var zipError error
+
+	BeforeEach(func() {
+		folderPath = filepath.Join(GinkgoT().TempDir(), folderName)
+		for _, name := range []string{
+			"cover.jpg",
+			filepath.Join("CD1", "01 track.mp3"),
+			filepath.Join("CD2", "01 track.mp3"),
+			filepath.Join("Scans", "booklet", "page 1.jpg"),
+		} {
+			Expect(os.MkdirAll(filepath.Dir(filepath.Join(folderPath, name)), 0755)).To(BeNil())
+			Expect(os.WriteFile(filepath.Join(folderPath, name), []byte(name), 0644)).To(BeNil())
+		}
+
+		zipp := zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+		zipp.SetRecursive(true)
+		zipPath, zipError = zipp.ZipFilesInFolder(folderPath)
+	})
+
+	It("should zip without error", func() {
+		Expect(zipError).To(BeNil())
+	})
+
+	It("should keep the relative paths of nested files", func() {
+		files, err := openZip(zipPath)
+		Expect(err).To(BeNil(), "Got an error trying to open zip file")
+		defer files.Close()
+
+		var fileNames []string
+		for _, file := range files.File {
+			fileNames = append(fileNames, file.Name)
+		}
+		Expect(fileNames).To(ConsistOf(
+			filepath.Join(folderName, "cover.jpg"),
+			filepath.Join(folderName, "CD1", "01 track.mp3"),
+			filepath.Join(folderName, "CD2", "01 track.mp3"),
+			filepath.Join(folderName, "Scans", "booklet", "page 1.jpg"),
+			zipper.ManifestName))
+	})
+})
+
+var _ = Describe("zipper when splitting into parts - integrated", func() {
+	const folderName = "box set"
+
+	var folderPath string
+	var zipp zipper.Zipper
+
+	BeforeEach(func() {
+		folderPath = filepath.Join(GinkgoT().TempDir(), folderName)
+		Expect(os.MkdirAll(folderPath, 0755)).To(BeNil())
+		for _, name := range []string{"01.mp3", "02.mp3", "03.mp3", "04.mp3", "05.mp3"} {
+			Expect(os.WriteFile(filepath.Join(folderPath, name), []byte(name), 0644)).To(BeNil())
+		}
+		zipp = zipper.Zipper{FileSystemOperator: &fileutil.FileSystemOperator{}}
+		zipp.SetFileLimits(0, 3)
+	})
+
+	It("should fail on the file count limit when splitting is off", func() {
+		_, err := zipp.ZipFilesInParts(folderPath)
+		Expect(err).To(Not(BeNil()))
+	})
+
+	It("should split the folder into numbered parts under the part file limit", func() {
+		zipp.SetPartLimits(0, 2)
+		partPaths, err := zipp.ZipFilesInParts(folderPath)
+		Expect(err).To(BeNil())
+		Expect(partPaths).To(Equal([]string{
+			folderPath + "-part1.zip", folderPath + "-part2.zip", folderPath + "-part3.zip"}))
+
+		var zippedNames []string
+		for _, partPath := range partPaths {
+			files, err := openZip(partPath)
+			Expect(err).To(BeNil(), "Got an error trying to open zip file")
+			Expect(len(files.File)).To(BeNumerically("<=", 3), "Expected 2 files and a manifest at most")
+			for _, file := range files.File {
+				if file.Name != zipper.ManifestName {
+					zippedNames = append(zippedNames, file.Name)
+				}
+			}
+			files.Close()
+		}
+		Expect(zippedNames).To(Equal([]string{
+			filepath.Join(folderName, "01.mp3"), filepath.Join(folderName, "02.mp3"),
+			filepath.Join(folderName, "03.mp3"), filepath.Join(folderName, "04.mp3"),
+			filepath.Join(folderName, "05.mp3")}))
+	})
+
+	It("should split the folder under the part size limit", func() {
+		zipp.SetPartLimits(12, 0)
+		fileParts, err := zipp.FilePartsToZip(folderPath)
+		Expect(err).To(BeNil())
+		Expect(fileParts).To(Equal([][]string{
+			{"01.mp3", "02.mp3"}, {"03.mp3", "04.mp3"}, {"05.mp3"}}))
+	})
+
+	It("should not split a folder within the part limits", func() {
+		zipp.SetPartLimits(0, 5)
+		partPaths, err := zipp.ZipFilesInParts(folderPath)
+		Expect(err).To(BeNil())
+		Expect(partPaths).To(Equal([]string{folderPath + ".zip"}))
+	})
+})
+
+var _ = Describe("zipper part names", func() {
+	DescribeTable("should number parts and parse them back",
+		func(name string, part int, partName string) {
+			Expect(zipper.PartName(name, part)).To(Equal(partName))
+
+			wholeName, parsedPart, ok := zipper.ParsePartName(partName)
+			Expect(ok).To(BeTrue())
+			Expect(wholeName).To(Equal(name))
+			Expect(parsedPart).To(Equal(part))
+		},
+		Entry("zip", "album123.zip", 1, "album123-part1.zip"),
+		Entry("tar.zst", "album123.tar.zst", 12, "album123-part12.tar.zst"),
+	)
+
+	It("should not treat a whole archive as a part", func() {
+		_, _, ok := zipper.ParsePartName("album123.zip")
+		Expect(ok).To(BeFalse())
+	})
+})
+
 var _ = Describe("zipper when there are a mix of folders and files", func() {
 	const folderPath = "/path/to/music/album"
 	const hueySong = "hue lou.mp3"